@@ -0,0 +1,139 @@
+/*
+Copyright 2017, 2019 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/audit"
+)
+
+// auditFlushTimeout bounds sending the queued audit records and spans when the plugin exits.
+// Velero kills the plugin process 2 seconds after asking it to exit.
+const auditFlushTimeout = 1500 * time.Millisecond
+
+// auditLoggers holds the audit loggers that may still be sending records, so that Shutdown flushes them.
+var auditLoggers struct {
+	mu      sync.Mutex
+	loggers map[*audit.Logger]struct{}
+}
+
+// enableAudit replaces the audit logger of f with the one configured by auditLog.
+// The previous logger keeps sending its queued records in the background.
+func (f *ObjectStore) enableAudit(config map[string]string) error {
+	logger, err := audit.NewLogger(config[auditLogKey], config[auditSpoolFileKey], f.log)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s", auditLogKey)
+	}
+	auditLoggers.mu.Lock()
+	defer auditLoggers.mu.Unlock()
+	if prev := f.audit; prev != nil {
+		go func() {
+			closeAuditLogger(context.Background(), prev, f.log)
+			auditLoggers.mu.Lock()
+			delete(auditLoggers.loggers, prev)
+			auditLoggers.mu.Unlock()
+		}()
+	}
+	f.audit = logger
+	if logger != nil {
+		if auditLoggers.loggers == nil {
+			auditLoggers.loggers = make(map[*audit.Logger]struct{})
+		}
+		auditLoggers.loggers[logger] = struct{}{}
+	}
+	return nil
+}
+
+// Shutdown flushes the audit records still queued for the webhooks, writing those that cannot be sent
+// in time to the spool file, and exports the spans still batched. It is called once Velero has stopped
+// the plugin server.
+func Shutdown(log logrus.FieldLogger) {
+	ctx, cancel := context.WithTimeout(context.Background(), auditFlushTimeout)
+	defer cancel()
+
+	auditLoggers.mu.Lock()
+	loggers := auditLoggers.loggers
+	auditLoggers.loggers = nil
+	auditLoggers.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for logger := range loggers {
+		wg.Add(1)
+		go func(logger *audit.Logger) {
+			defer wg.Done()
+			closeAuditLogger(ctx, logger, log)
+		}(logger)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		shutdownTracing(ctx, log)
+	}()
+	wg.Wait()
+}
+
+func closeAuditLogger(ctx context.Context, logger *audit.Logger, log logrus.FieldLogger) {
+	if err := logger.Close(ctx); err != nil {
+		log.Warnf("flush audit log error: %v, undelivered records are written to the spool file", err)
+	}
+}
+
+// auditBody hashes the content Velero uploads, before it is compressed or encrypted, so that
+// the checksum in the audit log matches the backup file. It returns body unchanged when the
+// audit log is disabled.
+func (f *ObjectStore) auditBody(body io.Reader) (io.Reader, hash.Hash) {
+	if f.audit == nil {
+		return body, nil
+	}
+	sum := sha256.New()
+	return io.TeeReader(body, sum), sum
+}
+
+// auditRecord completes record with the backend, the credentials and the result of err, and
+// writes it to the audit log, if any.
+func (f *ObjectStore) auditRecord(record audit.Record, err error) {
+	if f.audit == nil {
+		return
+	}
+	record.Backend = f.backend
+	if f.creds != nil {
+		// the credentials are cached, so this only hits the providers when they are expiring
+		if value, err := f.creds.Get(context.Background()); err == nil {
+			record.AccessKeyID, record.CredentialSource, record.Profile = value.AccessKeyID, value.Source, value.Profile
+		}
+	}
+	record.Result = audit.ResultSuccess
+	if err != nil {
+		record.Result, record.Error = audit.ResultError, err.Error()
+	}
+	f.audit.Record(record)
+}
+
+// checksum formats the digest of sum for the audit log.
+func checksum(sum hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(sum.Sum(nil))
+}
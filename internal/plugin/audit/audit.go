@@ -0,0 +1,327 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ResultSuccess 操作成功
+	ResultSuccess = "success"
+	// ResultError 操作失败，失败原因记录在 Error 中
+	ResultError = "error"
+
+	// webhookTimeout 发送一条审计记录到 webhook 的超时时间
+	webhookTimeout = 10 * time.Second
+	// webhookQueueSize 等待发送到 webhook 的最大记录数
+	webhookQueueSize = 1024
+	// webhookAttempts 每条记录发送到 webhook 的最大尝试次数
+	webhookAttempts = 5
+)
+
+// webhookBackoff 发送失败后第一次重试前的等待时间，之后每次加倍
+var webhookBackoff = time.Second
+
+// DefaultSpoolFile 默认保存无法发送到 webhook 的审计记录的文件
+var DefaultSpoolFile = filepath.Join(os.TempDir(), "velero-os-plugin-audit-undelivered.log")
+
+// Record 一条审计记录，以一行 JSON 输出
+type Record struct {
+	// Timestamp 操作完成的时间（UTC）
+	Timestamp time.Time `json:"timestamp"`
+	// Operation ObjectStore 的方法名，如 PutObject
+	Operation string `json:"operation"`
+	// Backend 存储类型，即 s3Type
+	Backend string `json:"backend"`
+	Bucket  string `json:"bucket"`
+	Key     string `json:"key"`
+	// Size 上传的字节数，仅 PutObject 记录
+	Size *int64 `json:"size,omitempty"`
+	// Checksum 上传内容的校验和，格式为 sha256:<hex>，仅 PutObject 记录
+	Checksum string `json:"checksum,omitempty"`
+	// TTL 预签名地址的有效期，仅 CreateSignedURL 记录
+	TTL string `json:"ttl,omitempty"`
+	// CredentialSource 凭证的来源，例如环境变量、Vault 或凭证文件，filesystem 存储为空
+	CredentialSource string `json:"credentialSource,omitempty"`
+	// Profile 凭证文件中使用的 profile，仅在凭证来自凭证文件时设置
+	Profile string `json:"profile,omitempty"`
+	// AccessKeyID 执行操作时使用的访问密钥 ID
+	AccessKeyID string `json:"accessKeyId,omitempty"`
+	// Result 操作结果，ResultSuccess 或 ResultError
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Sink 审计记录的输出目标
+type Sink interface {
+	// Write 写入一条以换行结尾的 JSON 记录
+	Write(line []byte) error
+	// String 返回输出目标的描述，用于日志
+	String() string
+}
+
+// Logger 将审计记录写入所有输出目标，与插件的 logrus 日志相互独立。
+// 写入失败不影响存储操作本身，只在插件日志中记录错误
+type Logger struct {
+	sinks []Sink
+	log   logrus.FieldLogger
+}
+
+// NewLogger 根据以逗号分隔的输出目标创建 Logger：stdout 为 Velero 的标准输出，
+// http:// 或 https:// 开头的为 webhook 地址，其余为文件的绝对路径。destinations 为空时返回 nil。
+// 无法发送到 webhook 的记录追加到 spoolFile，为空时使用 DefaultSpoolFile
+func NewLogger(destinations, spoolFile string, log logrus.FieldLogger) (*Logger, error) {
+	if spoolFile == "" {
+		spoolFile = DefaultSpoolFile
+	}
+	if !filepath.IsAbs(spoolFile) {
+		return nil, fmt.Errorf("invalid audit spool file %q (expected an absolute path)", spoolFile)
+	}
+	var sinks []Sink
+	for _, dest := range strings.Split(destinations, ",") {
+		dest = strings.TrimSpace(dest)
+		switch {
+		case dest == "":
+			continue
+		case dest == "stdout":
+			sinks = append(sinks, &StdoutSink{})
+		case strings.HasPrefix(dest, "http://"), strings.HasPrefix(dest, "https://"):
+			sinks = append(sinks, NewWebhookSink(dest, &FileSink{Path: spoolFile}, log))
+		case filepath.IsAbs(dest):
+			sinks = append(sinks, &FileSink{Path: dest})
+		default:
+			return nil, fmt.Errorf("invalid audit destination %q (expected stdout, a webhook url or an absolute file path)", dest)
+		}
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return &Logger{sinks: sinks, log: log}, nil
+}
+
+// Record 补全时间戳后将 record 写入所有输出目标
+func (l *Logger) Record(record Record) {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now().UTC()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		l.log.Errorf("marshal audit record error: %v", err)
+		return
+	}
+	line = append(line, '\n')
+	for _, sink := range l.sinks {
+		if err := sink.Write(line); err != nil {
+			l.log.Errorf("write audit record of %s [%s/%s] to %s error: %v", record.Operation, record.Bucket, record.Key, sink, err)
+		}
+	}
+}
+
+// Close 等待已记录的审计记录发送完毕，ctx 结束时仍未发送的记录写入 spool 文件
+func (l *Logger) Close(ctx context.Context) error {
+	var err error
+	for _, sink := range l.sinks {
+		if closer, ok := sink.(interface{ Close(context.Context) error }); ok {
+			if closeErr := closer.Close(ctx); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}
+	return err
+}
+
+// FileSink 将审计记录追加到文件，每次写入时打开文件，文件被轮转（重命名或删除）后自动创建新文件
+type FileSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (f *FileSink) Write(line []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *FileSink) String() string {
+	return f.Path
+}
+
+// parentStdout 返回父进程（Velero）标准输出的路径
+var parentStdout = func() string {
+	return fmt.Sprintf("/proc/%d/fd/1", os.Getppid())
+}
+
+// StdoutSink 将审计记录写入 Velero 的标准输出，与 Velero 的日志一起由 kubectl logs 查看。
+// 插件自身的标准输出由 go-plugin 转发给 Velero 后被丢弃，因此写入父进程的标准输出，无法打开时写入插件自身的标准输出
+type StdoutSink struct {
+	mu sync.Mutex
+}
+
+func (s *StdoutSink) Write(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out io.Writer = os.Stdout
+	if file, err := os.OpenFile(parentStdout(), os.O_WRONLY|os.O_APPEND, 0); err == nil {
+		defer file.Close()
+		out = file
+	}
+	_, err := out.Write(line)
+	return err
+}
+
+func (s *StdoutSink) String() string {
+	return "stdout"
+}
+
+// WebhookSink 以 POST 请求将每条审计记录发送到 URL，请求体为 JSON 记录，
+// URL 中的用户名与密码以 Basic 认证发送。
+// 记录先进入有界队列，由后台 goroutine 按顺序发送，失败时退避重试，存储操作不等待发送结果。
+// 队列已满、重试后仍然失败以及 Close 超时未发送的记录写入 Spill，不会被丢弃
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+	// Spill 保存无法发送的记录
+	Spill Sink
+
+	log   logrus.FieldLogger
+	queue chan []byte
+	// mu 保护 closed，Close 之后不再向 queue 写入
+	mu     sync.Mutex
+	closed bool
+	// ctx 在 Close 超时时取消，中断正在进行的发送与重试等待
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWebhookSink 创建一个 WebhookSink 并启动后台发送
+func NewWebhookSink(url string, spill Sink, log logrus.FieldLogger) *WebhookSink {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &WebhookSink{
+		URL:    url,
+		Client: &http.Client{Timeout: webhookTimeout},
+		Spill:  spill,
+		log:    log,
+		queue:  make(chan []byte, webhookQueueSize),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write 将记录放入发送队列，队列已满或已经关闭时写入 Spill
+func (w *WebhookSink) Write(line []byte) error {
+	line = append([]byte(nil), line...)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		select {
+		case w.queue <- line:
+			return nil
+		default:
+		}
+	}
+	return w.spill(line, fmt.Errorf("webhook queue is full or closed"))
+}
+
+// Close 等待队列中的记录发送完毕，ctx 结束时中断发送，剩余的记录写入 Spill
+func (w *WebhookSink) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		<-w.done
+		return ctx.Err()
+	}
+}
+
+func (w *WebhookSink) run() {
+	defer close(w.done)
+	for line := range w.queue {
+		if err := w.send(line); err != nil {
+			if err := w.spill(line, err); err != nil {
+				w.log.Errorf("audit record %s is lost: %v", bytes.TrimSpace(line), err)
+			}
+		}
+	}
+}
+
+// spill 将发送失败的记录写入 Spill
+func (w *WebhookSink) spill(line []byte, reason error) error {
+	w.log.Warnf("could not send audit record to %s, write it to %s: %v", w, w.Spill, reason)
+	return w.Spill.Write(line)
+}
+
+// send 发送一条记录，失败时退避重试
+func (w *WebhookSink) send(line []byte) error {
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(line)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-w.ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (w *WebhookSink) post(line []byte) error {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, w.URL, bytes.NewReader(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// String 返回隐去密码的 URL
+func (w *WebhookSink) String() string {
+	u, err := url.Parse(w.URL)
+	if err != nil {
+		return "webhook"
+	}
+	return u.Redacted()
+}
@@ -1,8 +0,0 @@
-package plugin
-
-const (
-	DefaultSharedConfigProfile = `default`
-	accessKeyIDKey             = `aws_access_key_id`     // group required
-	secretAccessKey            = `aws_secret_access_key` // group required
-	DefaultCredentialsFile     = "/credentials/cloud"
-)
@@ -0,0 +1,248 @@
+package credentials
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscreds "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	// DefaultRoleSessionName 未配置 role_session_name 时使用的会话名称
+	DefaultRoleSessionName = "velero-os-plugin"
+	// DefaultRoleDuration 未配置 duration_seconds 时临时凭证的有效期
+	DefaultRoleDuration = time.Hour
+	// minRoleDuration AWS STS 与阿里云 STS 允许的最短有效期
+	minRoleDuration = 15 * time.Minute
+
+	// defaultAWSSTSRegion 未配置 region 时 AWS STS 使用的区域
+	defaultAWSSTSRegion = "us-east-1"
+	// defaultRAMSTSEndpoint 阿里云 STS 的默认地址
+	defaultRAMSTSEndpoint = "https://sts.aliyuncs.com"
+	// ramRolePrefix 阿里云 RAM 角色 ARN 的前缀，其他 ARN 使用 AWS STS 协议（MinIO 同样兼容）
+	ramRolePrefix = "acs:ram::"
+)
+
+// STSOptions 调用 STS 服务的默认配置，profile 中的 sts_endpoint 与 region 优先
+type STSOptions struct {
+	// Endpoint STS 服务地址，为空时 AWS 使用官方地址，阿里云使用 sts.aliyuncs.com。
+	// MinIO 的 STS 接口与对象存储服务使用同一个地址
+	Endpoint string
+	// Region AWS STS 签名使用的区域
+	Region string
+	// HTTPClient 调用 STS 使用的客户端，为空时使用 http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// AssumeRoleInput 扮演角色的参数，对应 profile 中的 role_arn、role_session_name、
+// duration_seconds、external_id、oidc_provider_arn、sts_endpoint 与 region
+type AssumeRoleInput struct {
+	RoleARN     string
+	SessionName string
+	Duration    time.Duration
+	ExternalID  string
+	// OIDCProviderARN 阿里云 RRSA 的 OIDC 身份提供商 ARN，仅用于 AssumeRoleWithOIDC
+	OIDCProviderARN string
+	Endpoint        string
+	Region          string
+}
+
+// assumeRole 使用 source 凭证扮演角色，返回角色的临时凭证
+func assumeRole(ctx context.Context, source Value, input AssumeRoleInput, client *http.Client) (Value, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if strings.HasPrefix(input.RoleARN, ramRolePrefix) {
+		return assumeRAMRole(ctx, source, input, client)
+	}
+	return assumeAWSRole(ctx, source, input, client)
+}
+
+// assumeAWSRole 调用 AWS STS AssumeRole 接口
+func assumeAWSRole(ctx context.Context, source Value, input AssumeRoleInput, httpClient *http.Client) (Value, error) {
+	client, err := newAWSSTSClient(input.Endpoint, input.Region,
+		awscreds.NewStaticCredentials(source.AccessKeyID, source.SecretAccessKey, source.SessionToken), httpClient)
+	if err != nil {
+		return Value{}, err
+	}
+
+	request := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(input.RoleARN),
+		RoleSessionName: aws.String(input.SessionName),
+		DurationSeconds: aws.Int64(int64(input.Duration.Seconds())),
+	}
+	if input.ExternalID != "" {
+		request.ExternalId = aws.String(input.ExternalID)
+	}
+	output, err := client.AssumeRoleWithContext(ctx, request)
+	if err != nil {
+		return Value{}, fmt.Errorf("assume role %s error: %w", input.RoleARN, err)
+	}
+	return awsSTSValue(input.RoleARN, output.Credentials)
+}
+
+// newAWSSTSClient 创建 AWS STS 客户端，endpoint 为空时使用官方地址
+func newAWSSTSClient(endpoint, region string, creds *awscreds.Credentials, httpClient *http.Client) (*sts.STS, error) {
+	if region == "" {
+		region = defaultAWSSTSRegion
+	}
+	config := aws.NewConfig().
+		WithRegion(region).
+		WithCredentials(creds).
+		WithHTTPClient(httpClient)
+	if endpoint != "" {
+		config = config.WithEndpoint(stsEndpointURL(endpoint))
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	return sts.New(sess), nil
+}
+
+// awsSTSValue 将 AWS STS 返回的临时凭证转换为 Value
+func awsSTSValue(roleARN string, creds *sts.Credentials) (Value, error) {
+	if creds == nil {
+		return Value{}, fmt.Errorf("assume role %s returned no credentials", roleARN)
+	}
+	return Value{
+		AccessKeyID:     aws.StringValue(creds.AccessKeyId),
+		SecretAccessKey: aws.StringValue(creds.SecretAccessKey),
+		SessionToken:    aws.StringValue(creds.SessionToken),
+		Expires:         aws.TimeValue(creds.Expiration),
+	}, nil
+}
+
+// ramAssumeRoleResponse 阿里云 STS AssumeRole 接口的返回结果
+type ramAssumeRoleResponse struct {
+	RequestID   string `json:"RequestId"`
+	Code        string `json:"Code"`
+	Message     string `json:"Message"`
+	Credentials struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		AccessKeySecret string `json:"AccessKeySecret"`
+		SecurityToken   string `json:"SecurityToken"`
+		Expiration      string `json:"Expiration"`
+	} `json:"Credentials"`
+}
+
+// assumeRAMRole 调用阿里云 STS AssumeRole 接口，请求使用 RPC 风格的 HMAC-SHA1 签名
+func assumeRAMRole(ctx context.Context, source Value, input AssumeRoleInput, client *http.Client) (Value, error) {
+	endpoint := input.Endpoint
+	if endpoint == "" {
+		endpoint = defaultRAMSTSEndpoint
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Value{}, err
+	}
+	params := url.Values{
+		"Action":           {"AssumeRole"},
+		"Format":           {"JSON"},
+		"Version":          {"2015-04-01"},
+		"AccessKeyId":      {source.AccessKeyID},
+		"SignatureMethod":  {"HMAC-SHA1"},
+		"SignatureVersion": {"1.0"},
+		"SignatureNonce":   {hex.EncodeToString(nonce)},
+		"Timestamp":        {time.Now().UTC().Format("2006-01-02T15:04:05Z")},
+		"RoleArn":          {input.RoleARN},
+		"RoleSessionName":  {input.SessionName},
+		"DurationSeconds":  {strconv.Itoa(int(input.Duration.Seconds()))},
+	}
+	if input.ExternalID != "" {
+		params.Set("ExternalId", input.ExternalID)
+	}
+	if source.SessionToken != "" {
+		params.Set("SecurityToken", source.SessionToken)
+	}
+	params.Set("Signature", ramSignature(http.MethodGet, params, source.SecretAccessKey))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, stsEndpointURL(endpoint)+"/?"+params.Encode(), nil)
+	if err != nil {
+		return Value{}, err
+	}
+	return doRAMSTSRequest(req, input.RoleARN, client)
+}
+
+// doRAMSTSRequest 发送阿里云 STS 请求并解析返回的临时凭证
+func doRAMSTSRequest(req *http.Request, roleARN string, client *http.Client) (Value, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return Value{}, fmt.Errorf("assume role %s error: %w", roleARN, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Value{}, fmt.Errorf("assume role %s error: %w", roleARN, err)
+	}
+
+	var result ramAssumeRoleResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return Value{}, fmt.Errorf("assume role %s: decode response (status %d) error: %w", roleARN, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Value{}, fmt.Errorf("assume role %s error: %s: %s (request id %s)", roleARN, result.Code, result.Message, result.RequestID)
+	}
+	expires, err := time.Parse(time.RFC3339, result.Credentials.Expiration)
+	if err != nil {
+		return Value{}, fmt.Errorf("assume role %s: parse expiration error: %w", roleARN, err)
+	}
+	return Value{
+		AccessKeyID:     result.Credentials.AccessKeyID,
+		SecretAccessKey: result.Credentials.AccessKeySecret,
+		SessionToken:    result.Credentials.SecurityToken,
+		Expires:         expires,
+	}, nil
+}
+
+// ramSignature 计算阿里云 RPC 风格接口的签名
+func ramSignature(method string, params url.Values, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, ramPercentEncode(k)+"="+ramPercentEncode(params.Get(k)))
+	}
+	stringToSign := method + "&" + ramPercentEncode("/") + "&" + ramPercentEncode(strings.Join(pairs, "&"))
+
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ramPercentEncode 按 RFC 3986 编码，与 url.QueryEscape 的区别在于空格、* 与 ~ 的处理
+func ramPercentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.ReplaceAll(s, "+", "%20")
+	s = strings.ReplaceAll(s, "*", "%2A")
+	return strings.ReplaceAll(s, "%7E", "~")
+}
+
+// stsEndpointURL 为没有协议的地址补全 https://
+func stsEndpointURL(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		return strings.TrimSuffix(endpoint, "/")
+	}
+	return "https://" + strings.TrimSuffix(endpoint, "/")
+}
@@ -0,0 +1,286 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeSTS 模拟 AWS STS 与阿里云 STS 的 AssumeRole 接口，记录每次请求的参数
+type fakeSTS struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []url.Values
+	// expires 返回的临时凭证的过期时间
+	expires time.Time
+}
+
+func newFakeSTS(t *testing.T) *fakeSTS {
+	f := &fakeSTS{expires: time.Now().Add(time.Hour).UTC().Truncate(time.Second)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeSTS) handle(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Form)
+	n := len(f.requests)
+	f.mu.Unlock()
+
+	action := r.Form.Get("Action")
+	// 阿里云 STS 的请求带有 Format 参数，AssumeRole 使用 RPC 签名，AssumeRoleWithOIDC 不签名
+	if r.Form.Has("Format") {
+		if action == "AssumeRole" && r.Form.Get("Signature") != ramSignature(http.MethodGet, unsigned(r.Form), secretFor(r.Form.Get("AccessKeyId"))) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"Code": "SignatureDoesNotMatch", "Message": "bad signature", "RequestId": "1"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"RequestId": "1",
+			"Credentials": map[string]string{
+				"AccessKeyId":     fmt.Sprintf("STS.ram-%d", n),
+				"AccessKeySecret": fmt.Sprintf("ram-secret-%d", n),
+				"SecurityToken":   fmt.Sprintf("ram-token-%d", n),
+				"Expiration":      f.expires.Format(time.RFC3339),
+			},
+		})
+		return
+	}
+
+	// AWS STS 返回 XML，AssumeRoleWithWebIdentity 请求不带签名
+	auth := r.Header.Get("Authorization")
+	id := strings.TrimPrefix(strings.SplitN(auth, "/", 2)[0], "AWS4-HMAC-SHA256 Credential=")
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>ASIA-%[2]d-%[3]s</AccessKeyId>
+      <SecretAccessKey>aws-secret-%[2]d</SecretAccessKey>
+      <SessionToken>aws-token-%[2]d</SessionToken>
+      <Expiration>%[4]s</Expiration>
+    </Credentials>
+  </%[1]sResult>
+</%[1]sResponse>`, action, n, id, f.expires.Format(time.RFC3339))
+}
+
+// unsigned 返回去掉 Signature 后的请求参数
+func unsigned(form url.Values) url.Values {
+	params := url.Values{}
+	for k, v := range form {
+		if k != "Signature" {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+func (f *fakeSTS) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = nil
+}
+
+func (f *fakeSTS) calls() []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]url.Values(nil), f.requests...)
+}
+
+// secretFor 返回测试凭证文件中 access key 对应的 secret
+func secretFor(id string) string {
+	return strings.Replace(id, "-id", "-secret", 1)
+}
+
+func TestFileProviderAssumeRole(t *testing.T) {
+	server := newFakeSTS(t)
+	path := writeCredentialsFile(t, fmt.Sprintf(`[base]
+aws_access_key_id = base-id
+aws_secret_access_key = base-secret
+
+[aws]
+role_arn = arn:aws:iam::123456789012:role/velero
+source_profile = base
+role_session_name = backup
+duration_seconds = 1800
+external_id = tenant-a
+
+[oss]
+role_arn = acs:ram::1234567890:role/velero
+source_profile = base
+external_id = tenant-b
+
+[chained]
+role_arn = arn:aws:iam::123456789012:role/second
+source_profile = aws
+
+[self]
+aws_access_key_id = self-id
+aws_secret_access_key = self-secret
+role_arn = arn:aws:iam::123456789012:role/self
+source_profile = self
+
+[loop-a]
+role_arn = arn:aws:iam::123456789012:role/a
+source_profile = loop-b
+
+[loop-b]
+role_arn = arn:aws:iam::123456789012:role/b
+source_profile = loop-a
+
+[no-source]
+role_arn = arn:aws:iam::123456789012:role/velero
+
+[short]
+role_arn = arn:aws:iam::123456789012:role/velero
+source_profile = base
+duration_seconds = 60
+
+[remote]
+role_arn = arn:aws:iam::123456789012:role/velero
+source_profile = base
+sts_endpoint = %s
+`, server.URL))
+
+	tests := []struct {
+		name       string
+		profile    string
+		endpoint   string
+		wantPrefix string
+		wantParams map[string]string
+		wantCalls  int
+		wantErr    bool
+	}{
+		{
+			name:       "aws sts",
+			profile:    "aws",
+			endpoint:   server.URL,
+			wantPrefix: "ASIA-1-base-id",
+			wantParams: map[string]string{
+				"Action":          "AssumeRole",
+				"RoleArn":         "arn:aws:iam::123456789012:role/velero",
+				"RoleSessionName": "backup",
+				"DurationSeconds": "1800",
+				"ExternalId":      "tenant-a",
+			},
+			wantCalls: 1,
+		},
+		{
+			name:       "alibaba ram",
+			profile:    "oss",
+			endpoint:   server.URL,
+			wantPrefix: "STS.ram-1",
+			wantParams: map[string]string{
+				"Action":          "AssumeRole",
+				"RoleArn":         "acs:ram::1234567890:role/velero",
+				"RoleSessionName": DefaultRoleSessionName,
+				"DurationSeconds": "3600",
+				"ExternalId":      "tenant-b",
+			},
+			wantCalls: 1,
+		},
+		{
+			name:       "role chaining",
+			profile:    "chained",
+			endpoint:   server.URL,
+			wantPrefix: "ASIA-2-ASIA-1-base-id",
+			wantParams: map[string]string{"RoleArn": "arn:aws:iam::123456789012:role/second"},
+			wantCalls:  2,
+		},
+		{
+			name:       "source profile is itself",
+			profile:    "self",
+			endpoint:   server.URL,
+			wantPrefix: "ASIA-1-self-id",
+			wantCalls:  1,
+		},
+		{
+			name:       "endpoint from profile",
+			profile:    "remote",
+			wantPrefix: "ASIA-1-base-id",
+			wantCalls:  1,
+		},
+		{name: "source profile loop", profile: "loop-a", endpoint: server.URL, wantErr: true},
+		{name: "missing source profile", profile: "no-source", endpoint: server.URL, wantErr: true},
+		{name: "duration too short", profile: "short", endpoint: server.URL, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.reset()
+
+			provider := NewFileProvider(path, tt.profile, true)
+			provider.STS = STSOptions{Endpoint: tt.endpoint}
+			got, err := provider.Retrieve(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Retrieve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.AccessKeyID != tt.wantPrefix {
+				t.Errorf("Retrieve() access key = %s, want %s", got.AccessKeyID, tt.wantPrefix)
+			}
+			if got.SessionToken == "" || !got.Expires.Equal(server.expires) {
+				t.Errorf("Retrieve() = %+v, want temporary credentials expiring at %s", got, server.expires)
+			}
+			calls := server.calls()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("STS called %d times, want %d", len(calls), tt.wantCalls)
+			}
+			last := calls[len(calls)-1]
+			for k, want := range tt.wantParams {
+				if v := last.Get(k); v != want {
+					t.Errorf("STS request %s = %q, want %q", k, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAssumeRoleCachedUntilExpiry(t *testing.T) {
+	server := newFakeSTS(t)
+	path := writeCredentialsFile(t, `[base]
+aws_access_key_id = base-id
+aws_secret_access_key = base-secret
+
+[default]
+role_arn = acs:ram::1234567890:role/velero
+source_profile = base
+`)
+	provider := NewFileProvider(path, "", true)
+	provider.STS = STSOptions{Endpoint: server.URL}
+	creds := NewCredentials(NewChain(logrus.New(), provider), logrus.New())
+
+	for i := 0; i < 3; i++ {
+		if _, err := creds.Get(context.Background()); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if n := len(server.calls()); n != 1 {
+		t.Errorf("STS called %d times for cached credentials, want 1", n)
+	}
+
+	// 临时凭证进入过期窗口后重新扮演角色
+	creds.ExpiryWindow = 2 * time.Hour
+	got, err := creds.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.SessionToken != "ram-token-2" {
+		t.Errorf("Get() session token = %s, want ram-token-2", got.SessionToken)
+	}
+}
@@ -0,0 +1,304 @@
+// Package credentials 提供对象存储访问凭证的获取方式，多个 Provider 可以组成 Chain，
+// 按顺序尝试，使用第一个返回凭证的 Provider
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoCredentials 表示 Provider 没有可用的凭证，Chain 遇到该错误时继续尝试下一个 Provider
+var ErrNoCredentials = errors.New("no credentials found")
+
+// Value 访问对象存储使用的凭证
+type Value struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken STS 临时凭证的安全令牌，长期凭证为空
+	SessionToken string
+	// Expires 临时凭证的过期时间，零值表示不会过期
+	Expires time.Time
+	// Source 凭证的来源，用于日志与审计记录
+	Source string
+	// Profile 凭证文件中使用的 profile，仅从凭证文件获取的凭证设置
+	Profile string
+}
+
+// expiresWithin 判断凭证是否会在 window 时间内过期
+func (v Value) expiresWithin(window time.Duration) bool {
+	return !v.Expires.IsZero() && time.Now().Add(window).After(v.Expires)
+}
+
+// Provider 凭证提供者
+type Provider interface {
+	// Name 返回 Provider 的名称，用于日志
+	Name() string
+	// Retrieve 获取凭证，没有可用凭证时返回包装了 ErrNoCredentials 的错误，
+	// 其他错误说明凭证配置有误，Chain 会直接返回
+	Retrieve(ctx context.Context) (Value, error)
+}
+
+// Chain 按顺序尝试各个 Provider，并记录每一步的结果
+type Chain struct {
+	Providers []Provider
+	Log       logrus.FieldLogger
+}
+
+// NewChain 创建一个 Chain
+func NewChain(log logrus.FieldLogger, providers ...Provider) *Chain {
+	return &Chain{Providers: providers, Log: log}
+}
+
+// Name 实现 Provider 接口，Chain 可以嵌套使用
+func (c *Chain) Name() string {
+	names := make([]string, 0, len(c.Providers))
+	for _, p := range c.Providers {
+		names = append(names, p.Name())
+	}
+	return "chain(" + strings.Join(names, ", ") + ")"
+}
+
+// Retrieve 返回第一个可用的凭证，所有 Provider 都没有凭证时返回 ErrNoCredentials
+func (c *Chain) Retrieve(ctx context.Context) (Value, error) {
+	var skipped []string
+	for _, p := range c.Providers {
+		c.Log.Debugf("resolve credentials from %s", p.Name())
+		value, err := p.Retrieve(ctx)
+		if err == nil {
+			c.Log.Infof("use credentials from %s", value.Source)
+			return value, nil
+		}
+		if !errors.Is(err, ErrNoCredentials) {
+			return Value{}, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+		c.Log.Infof("skip %s: %v", p.Name(), err)
+		skipped = append(skipped, err.Error())
+	}
+	return Value{}, fmt.Errorf("%w in chain: %s", ErrNoCredentials, strings.Join(skipped, "; "))
+}
+
+// DefaultExpiryWindow 临时凭证在过期前多久重新获取，避免凭证在上传过程中失效
+const DefaultExpiryWindow = 5 * time.Minute
+
+// Credentials 缓存 Provider 返回的凭证，临时凭证在过期前 ExpiryWindow 时自动重新获取，
+// 各对象存储 SDK 在每次请求前通过它获取凭证，并发安全
+type Credentials struct {
+	provider Provider
+	log      logrus.FieldLogger
+	// ExpiryWindow 临时凭证在过期前多久重新获取，需要在第一次调用 Get 之前设置
+	ExpiryWindow time.Duration
+
+	// cached 当前缓存的凭证，读取时不加锁，尚未获取凭证时为 nil
+	cached atomic.Pointer[cachedValue]
+	// mu 保护 inflight、started、stored 以及对 cached 的替换
+	mu sync.Mutex
+	// inflight Get 发起的正在进行的重新获取，同一时间只有一个 goroutine 为 Get 调用 Provider
+	inflight *refreshCall
+	// started 最近一次开始获取的序号，stored 已缓存的凭证的获取序号。
+	// Get 与 Refresh 的获取可能同时进行，较早开始的获取后完成时丢弃其结果，避免旧凭证覆盖新凭证
+	started, stored uint64
+}
+
+// cachedValue 缓存的凭证及其版本
+type cachedValue struct {
+	value Value
+	// generation 每次缓存的凭证发生变化时加一，SDK 适配器据此判断自身缓存的凭证是否过时
+	generation uint64
+}
+
+// refreshCall 一次正在进行的重新获取，done 关闭后 value 与 err 可读
+type refreshCall struct {
+	// seq 开始获取时的序号
+	seq   uint64
+	done  chan struct{}
+	value Value
+	err   error
+}
+
+// NewCredentials 创建一个 Credentials，首次调用 Get 时才会获取凭证
+func NewCredentials(provider Provider, log logrus.FieldLogger) *Credentials {
+	return &Credentials{provider: provider, log: log, ExpiryWindow: DefaultExpiryWindow}
+}
+
+// NewStaticCredentials 创建一个使用固定凭证的 Credentials
+func NewStaticCredentials(accessKeyID, secretAccessKey, sessionToken string) *Credentials {
+	value := Value{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey, SessionToken: sessionToken, Source: "static"}
+	c := &Credentials{provider: StaticProvider{Value: value}, log: logrus.New()}
+	c.cached.Store(&cachedValue{value: value, generation: 1})
+	return c
+}
+
+// Get 返回当前可用的凭证，凭证即将过期时重新获取。
+// 并发的重新获取合并为一次，其余调用方在旧凭证尚未过期时直接使用旧凭证，否则等待重新获取的结果；
+// 重新获取失败但旧凭证尚未过期时继续使用旧凭证
+func (c *Credentials) Get(ctx context.Context) (Value, error) {
+	for {
+		cached := c.cached.Load()
+		if cached != nil && !cached.value.expiresWithin(c.ExpiryWindow) {
+			return cached.value, nil
+		}
+
+		c.mu.Lock()
+		call := c.inflight
+		if call == nil {
+			c.started++
+			call = &refreshCall{seq: c.started, done: make(chan struct{})}
+			c.inflight = call
+			c.mu.Unlock()
+			return c.refresh(ctx, call)
+		}
+		c.mu.Unlock()
+
+		if cached != nil && !cached.value.expiresWithin(0) {
+			return cached.value, nil
+		}
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return Value{}, ctx.Err()
+		}
+		// 发起重新获取的调用方被取消时，由当前调用方重新发起
+		if call.err != nil && ctx.Err() == nil && (errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded)) {
+			continue
+		}
+		return call.value, call.err
+	}
+}
+
+// refresh 调用 Provider 重新获取凭证，结果通过 call 通知等待的调用方
+func (c *Credentials) refresh(ctx context.Context, call *refreshCall) (Value, error) {
+	value, err := c.provider.Retrieve(ctx)
+
+	c.mu.Lock()
+	if err == nil {
+		value = c.storeFetched(call.seq, value)
+	} else if cached := c.cached.Load(); cached != nil && !cached.value.expiresWithin(0) {
+		c.log.Warnf("refresh credentials from %s error, keep using credentials expiring at %s: %v",
+			cached.value.Source, cached.value.Expires.Format(time.RFC3339), err)
+		value, err = cached.value, nil
+	}
+	c.inflight = nil
+	c.mu.Unlock()
+
+	call.value, call.err = value, err
+	close(call.done)
+	return value, err
+}
+
+// Refresh 立即重新获取凭证并替换缓存的凭证，用于凭证文件更新等场景。
+// 获取失败时保留原有凭证并返回错误，获取期间 Get 继续返回原有凭证
+func (c *Credentials) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	c.started++
+	seq := c.started
+	c.mu.Unlock()
+
+	value, err := c.provider.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.storeFetched(seq, value)
+	return nil
+}
+
+// storeFetched 缓存序号为 seq 的获取得到的凭证并返回当前缓存的凭证。
+// 之后开始的获取已经缓存了凭证时丢弃 value，调用方需持有 mu
+func (c *Credentials) storeFetched(seq uint64, value Value) Value {
+	if seq < c.stored {
+		c.log.Debugf("drop credentials from %s retrieved before the cached ones", value.Source)
+		return c.cached.Load().value
+	}
+	c.store(value)
+	c.stored = seq
+	return value
+}
+
+// DefaultRenewInterval 后台检查凭证是否即将过期的默认间隔
+const DefaultRenewInterval = time.Minute
+
+// RenewBeforeExpiry 在后台每隔 interval 检查一次缓存的凭证，即将过期时重新获取，直到 ctx 结束。
+// Vault 等租约类凭证需要在插件的整个生命周期内持续续期，不能等到下一次请求时才获取
+func RenewBeforeExpiry(ctx context.Context, creds *Credentials, interval time.Duration, log logrus.FieldLogger) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if !creds.IsExpired() {
+				continue
+			}
+			if _, err := creds.Get(ctx); err != nil {
+				log.Errorf("renew credentials error: %v", err)
+			}
+		}
+	}()
+}
+
+// Generation 返回缓存凭证的版本，凭证每次变化时递增
+func (c *Credentials) Generation() uint64 {
+	if cached := c.cached.Load(); cached != nil {
+		return cached.generation
+	}
+	return 0
+}
+
+// store 替换缓存的凭证并记录日志，调用方需持有 mu
+func (c *Credentials) store(value Value) {
+	var previous cachedValue
+	cached := c.cached.Load()
+	if cached != nil {
+		previous = *cached
+	}
+	changed := cached == nil || value.AccessKeyID != previous.value.AccessKeyID || value.SecretAccessKey != previous.value.SecretAccessKey ||
+		value.SessionToken != previous.value.SessionToken
+	switch {
+	case !changed && value.Expires.Equal(previous.value.Expires):
+		c.log.Debugf("credentials from %s are unchanged", value.Source)
+	case value.Expires.IsZero():
+		c.log.Infof("retrieved credentials from %s", value.Source)
+	default:
+		c.log.Infof("retrieved temporary credentials from %s, expire at %s", value.Source, value.Expires.Format(time.RFC3339))
+	}
+	if value.expiresWithin(0) {
+		c.log.Warnf("credentials from %s expired at %s", value.Source, value.Expires.Format(time.RFC3339))
+	}
+	generation := previous.generation
+	if changed {
+		generation++
+	}
+	c.cached.Store(&cachedValue{value: value, generation: generation})
+}
+
+// IsExpired 判断缓存的凭证是否需要重新获取
+func (c *Credentials) IsExpired() bool {
+	cached := c.cached.Load()
+	return cached == nil || cached.value.expiresWithin(c.ExpiryWindow)
+}
+
+// StaticProvider 返回固定凭证的 Provider
+type StaticProvider struct {
+	Value Value
+}
+
+// Name 实现 Provider 接口
+func (s StaticProvider) Name() string {
+	return "static"
+}
+
+// Retrieve 实现 Provider 接口
+func (s StaticProvider) Retrieve(ctx context.Context) (Value, error) {
+	return s.Value, nil
+}
@@ -0,0 +1,344 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// staticProvider 返回固定结果的 Provider
+type staticProvider struct {
+	name  string
+	value Value
+	err   error
+	calls int
+}
+
+func (s *staticProvider) Name() string { return s.name }
+
+func (s *staticProvider) Retrieve(ctx context.Context) (Value, error) {
+	s.calls++
+	return s.value, s.err
+}
+
+func TestChainRetrieve(t *testing.T) {
+	empty := &staticProvider{name: "empty", err: fmt.Errorf("%w here", ErrNoCredentials)}
+	found := &staticProvider{name: "found", value: Value{AccessKeyID: "id", SecretAccessKey: "secret", Source: "found"}}
+	never := &staticProvider{name: "never", value: Value{AccessKeyID: "other"}}
+
+	got, err := NewChain(logrus.New(), empty, found, never).Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if got.AccessKeyID != "id" || got.Source != "found" {
+		t.Errorf("Retrieve() = %+v, want credentials from found", got)
+	}
+	if empty.calls != 1 || never.calls != 0 {
+		t.Errorf("providers called empty=%d never=%d, want 1 and 0", empty.calls, never.calls)
+	}
+}
+
+func TestChainStopsOnConfigurationError(t *testing.T) {
+	broken := &staticProvider{name: "broken", err: errors.New("malformed")}
+	found := &staticProvider{name: "found", value: Value{AccessKeyID: "id"}}
+
+	_, err := NewChain(logrus.New(), broken, found).Retrieve(context.Background())
+	if err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("Retrieve() error = %v, want configuration error", err)
+	}
+	if found.calls != 0 {
+		t.Errorf("provider after a configuration error was called")
+	}
+}
+
+func TestChainNoCredentials(t *testing.T) {
+	chain := NewChain(logrus.New(),
+		&staticProvider{name: "a", err: fmt.Errorf("%w: a", ErrNoCredentials)},
+		&staticProvider{name: "b", err: fmt.Errorf("%w: b", ErrNoCredentials)},
+	)
+	if _, err := chain.Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Retrieve() error = %v, want %v", err, ErrNoCredentials)
+	}
+}
+
+func TestChainEnvironmentBeforeFiles(t *testing.T) {
+	file := writeCredentialsFile(t, "[default]\naws_access_key_id = file-id\naws_secret_access_key = file-secret\n")
+	env := NewEnvProvider()
+	env.lookup = fakeEnv(map[string]string{"MINIO_ACCESS_KEY": "env-id", "MINIO_SECRET_KEY": "env-secret"})
+	chain := NewChain(logrus.New(), env, NewFileProvider(file, "", true))
+
+	got, err := chain.Retrieve(context.Background())
+	if err != nil || got.AccessKeyID != "env-id" {
+		t.Fatalf("Retrieve() = %+v, %v, want env-id", got, err)
+	}
+
+	env.lookup = fakeEnv(map[string]string{})
+	got, err = chain.Retrieve(context.Background())
+	if err != nil || got.AccessKeyID != "file-id" {
+		t.Fatalf("Retrieve() without env = %+v, %v, want file-id", got, err)
+	}
+
+	chain = NewChain(logrus.New(), env, NewFileProvider(filepath.Join(t.TempDir(), "missing"), "", false))
+	if _, err := chain.Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Retrieve() with nothing configured error = %v, want %v", err, ErrNoCredentials)
+	}
+}
+
+func TestCredentialsRefresh(t *testing.T) {
+	now := time.Now()
+	provider := &staticProvider{name: "sts", value: Value{AccessKeyID: "id-1", SessionToken: "token-1", Expires: now.Add(time.Hour)}}
+	creds := NewCredentials(provider, logrus.New())
+
+	if !creds.IsExpired() {
+		t.Errorf("IsExpired() before the first Get = false, want true")
+	}
+	got, err := creds.Get(context.Background())
+	if err != nil || got.SessionToken != "token-1" {
+		t.Fatalf("Get() = %+v, %v, want token-1", got, err)
+	}
+	if _, err := creds.Get(context.Background()); err != nil || provider.calls != 1 {
+		t.Errorf("Get() with valid credentials called the provider %d times, want 1", provider.calls)
+	}
+
+	// 进入过期窗口后重新获取
+	provider.value = Value{AccessKeyID: "id-2", SessionToken: "token-2", Expires: now.Add(2 * time.Hour)}
+	creds.ExpiryWindow = 90 * time.Minute
+	if !creds.IsExpired() {
+		t.Errorf("IsExpired() within the expiry window = false, want true")
+	}
+	if got, err = creds.Get(context.Background()); err != nil || got.SessionToken != "token-2" {
+		t.Fatalf("Get() within the expiry window = %+v, %v, want token-2", got, err)
+	}
+
+	// 重新获取失败时继续使用尚未过期的凭证
+	creds.ExpiryWindow = 3 * time.Hour
+	provider.err = errors.New("sts unavailable")
+	if got, err = creds.Get(context.Background()); err != nil || got.SessionToken != "token-2" {
+		t.Errorf("Get() with a failing provider = %+v, %v, want cached token-2", got, err)
+	}
+
+	// 凭证已经过期时返回错误
+	expired := *creds.cached.Load()
+	expired.value.Expires = now.Add(-time.Minute)
+	creds.cached.Store(&expired)
+	if _, err = creds.Get(context.Background()); err == nil {
+		t.Errorf("Get() with expired credentials and a failing provider succeeded, want error")
+	}
+}
+
+// blockingProvider 在 release 关闭之前阻塞 Retrieve，记录调用次数
+type blockingProvider struct {
+	value   Value
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingProvider) Name() string { return "blocking" }
+
+func (b *blockingProvider) Retrieve(ctx context.Context) (Value, error) {
+	if b.calls.Add(1) == 1 {
+		close(b.started)
+	}
+	select {
+	case <-b.release:
+		return b.value, nil
+	case <-ctx.Done():
+		return Value{}, ctx.Err()
+	}
+}
+
+func TestCredentialsConcurrentRefresh(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		// cached 重新获取之前缓存的凭证，nil 表示尚未获取
+		cached *Value
+		// wantWait 其余调用方是否等待重新获取的结果
+		wantWait bool
+	}{
+		{name: "within expiry window", cached: &Value{AccessKeyID: "id-1", Expires: now.Add(time.Minute)}},
+		{name: "not retrieved", wantWait: true},
+		{name: "expired", cached: &Value{AccessKeyID: "id-1", Expires: now.Add(-time.Minute)}, wantWait: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &blockingProvider{
+				value:   Value{AccessKeyID: "id-2", Expires: now.Add(time.Hour)},
+				started: make(chan struct{}),
+				release: make(chan struct{}),
+			}
+			creds := NewCredentials(provider, logrus.New())
+			if tt.cached != nil {
+				creds.cached.Store(&cachedValue{value: *tt.cached, generation: 1})
+			}
+
+			// 第一个调用方发起重新获取并阻塞在 Provider 中
+			leader := make(chan Value, 1)
+			go func() {
+				value, _ := creds.Get(context.Background())
+				leader <- value
+			}()
+			<-provider.started
+
+			const callers = 8
+			results := make(chan Value, callers)
+			var wg sync.WaitGroup
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					value, err := creds.Get(context.Background())
+					if err != nil {
+						t.Errorf("Get() error = %v", err)
+					}
+					results <- value
+				}()
+			}
+			if !tt.wantWait {
+				wg.Wait()
+			}
+			close(provider.release)
+			wg.Wait()
+			close(results)
+
+			want := "id-2"
+			if !tt.wantWait {
+				want = tt.cached.AccessKeyID
+			}
+			for value := range results {
+				if value.AccessKeyID != want {
+					t.Errorf("Get() during the refresh = %s, want %s", value.AccessKeyID, want)
+				}
+			}
+			if got := <-leader; got.AccessKeyID != "id-2" {
+				t.Errorf("Get() of the refreshing caller = %s, want id-2", got.AccessKeyID)
+			}
+			if calls := provider.calls.Load(); calls != 1 {
+				t.Errorf("provider called %d times, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestCredentialsGetCancelled(t *testing.T) {
+	provider := &blockingProvider{
+		value:   Value{AccessKeyID: "id", Expires: time.Now().Add(time.Hour)},
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	creds := NewCredentials(provider, logrus.New())
+
+	// 发起重新获取的调用方被取消后，等待中的调用方重新发起
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := creds.Get(ctx)
+		leader <- err
+	}()
+	<-provider.started
+	waiter := make(chan Value, 1)
+	go func() {
+		value, _ := creds.Get(context.Background())
+		waiter <- value
+	}()
+	cancel()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("Get() with a cancelled context error = %v, want %v", err, context.Canceled)
+	}
+	close(provider.release)
+	if got := <-waiter; got.AccessKeyID != "id" {
+		t.Errorf("Get() of the waiting caller = %+v, want id", got)
+	}
+}
+
+// sequencedProvider 第一次调用阻塞到 release 关闭后返回 first，之后的调用立即返回 rest
+type sequencedProvider struct {
+	first, rest Value
+	calls       atomic.Int32
+	started     chan struct{}
+	release     chan struct{}
+}
+
+func (s *sequencedProvider) Name() string { return "sequenced" }
+
+func (s *sequencedProvider) Retrieve(ctx context.Context) (Value, error) {
+	if s.calls.Add(1) > 1 {
+		return s.rest, nil
+	}
+	close(s.started)
+	<-s.release
+	return s.first, nil
+}
+
+func TestCredentialsRefreshDuringGet(t *testing.T) {
+	provider := &sequencedProvider{
+		first:   Value{AccessKeyID: "old", Source: "sequenced"},
+		rest:    Value{AccessKeyID: "new", Source: "sequenced"},
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	creds := NewCredentials(provider, logrus.New())
+
+	// Get 开始获取后凭证文件更新，Refresh 先完成，Get 的获取结果较旧，不能覆盖 Refresh 的结果
+	got := make(chan Value, 1)
+	go func() {
+		value, _ := creds.Get(context.Background())
+		got <- value
+	}()
+	<-provider.started
+	if err := creds.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	close(provider.release)
+
+	if value := <-got; value.AccessKeyID != "new" {
+		t.Errorf("Get() started before Refresh() = %s, want new", value.AccessKeyID)
+	}
+	if value, _ := creds.Get(context.Background()); value.AccessKeyID != "new" {
+		t.Errorf("Get() after both fetches = %s, want new", value.AccessKeyID)
+	}
+}
+
+func TestStaticCredentials(t *testing.T) {
+	creds := NewStaticCredentials("id", "secret", "token")
+	if creds.IsExpired() {
+		t.Errorf("IsExpired() = true, want false")
+	}
+	got, err := creds.Get(context.Background())
+	if err != nil || got.AccessKeyID != "id" || got.SecretAccessKey != "secret" || got.SessionToken != "token" {
+		t.Errorf("Get() = %+v, %v", got, err)
+	}
+}
+
+func TestCredentialsRefreshKeepsCurrentOnError(t *testing.T) {
+	provider := &staticProvider{name: "file", value: Value{AccessKeyID: "id-1", SecretAccessKey: "secret"}}
+	creds := NewCredentials(provider, logrus.New())
+	if _, err := creds.Get(context.Background()); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	generation := creds.Generation()
+
+	provider.err = errors.New("malformed")
+	if err := creds.Refresh(context.Background()); err == nil {
+		t.Errorf("Refresh() with a failing provider succeeded, want error")
+	}
+	if got, _ := creds.Get(context.Background()); got.AccessKeyID != "id-1" || creds.Generation() != generation {
+		t.Errorf("Get() after a failed Refresh() = %+v, want unchanged id-1", got)
+	}
+
+	provider.err, provider.value.AccessKeyID = nil, "id-2"
+	if err := creds.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got, _ := creds.Get(context.Background()); got.AccessKeyID != "id-2" || creds.Generation() == generation {
+		t.Errorf("Get() after Refresh() = %+v, want id-2 with a new generation", got)
+	}
+}
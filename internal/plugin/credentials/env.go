@@ -0,0 +1,68 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// EnvKeys 一组保存凭证的环境变量名
+type EnvKeys struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken 保存 STS 安全令牌的环境变量，为空时不读取
+	SessionToken string
+}
+
+// DefaultEnvKeys 默认按顺序检查的环境变量，兼容 AWS、阿里云与 MinIO 的习惯用法
+var DefaultEnvKeys = []EnvKeys{
+	{AccessKeyID: "AWS_ACCESS_KEY_ID", SecretAccessKey: "AWS_SECRET_ACCESS_KEY", SessionToken: "AWS_SESSION_TOKEN"},
+	{AccessKeyID: "ALIBABA_CLOUD_ACCESS_KEY_ID", SecretAccessKey: "ALIBABA_CLOUD_ACCESS_KEY_SECRET", SessionToken: "ALIBABA_CLOUD_SECURITY_TOKEN"},
+	{AccessKeyID: "MINIO_ACCESS_KEY", SecretAccessKey: "MINIO_SECRET_KEY"},
+	{AccessKeyID: "MINIO_ROOT_USER", SecretAccessKey: "MINIO_ROOT_PASSWORD"},
+}
+
+// EnvProvider 从环境变量中读取凭证，适合通过 Kubernetes Secret 的 env/envFrom 注入
+type EnvProvider struct {
+	// Keys 按顺序检查的环境变量，为空时使用 DefaultEnvKeys
+	Keys []EnvKeys
+	// lookup 读取环境变量，测试时替换
+	lookup func(string) (string, bool)
+}
+
+// NewEnvProvider 创建一个 EnvProvider，keys 为空时使用 DefaultEnvKeys
+func NewEnvProvider(keys ...EnvKeys) *EnvProvider {
+	if len(keys) == 0 {
+		keys = DefaultEnvKeys
+	}
+	return &EnvProvider{Keys: keys, lookup: os.LookupEnv}
+}
+
+// Name 实现 Provider 接口
+func (e *EnvProvider) Name() string {
+	return "environment"
+}
+
+// Retrieve 返回第一组同时设置了 access key 与 secret key 的环境变量，
+// 只设置了其中一个时说明配置有误，返回错误
+func (e *EnvProvider) Retrieve(ctx context.Context) (Value, error) {
+	for _, keys := range e.Keys {
+		id, hasID := e.lookup(keys.AccessKeyID)
+		secret, hasSecret := e.lookup(keys.SecretAccessKey)
+		hasID, hasSecret = hasID && id != "", hasSecret && secret != ""
+
+		switch {
+		case hasID && hasSecret:
+			value := Value{AccessKeyID: id, SecretAccessKey: secret, Source: "environment " + keys.AccessKeyID}
+			if keys.SessionToken != "" {
+				value.SessionToken, _ = e.lookup(keys.SessionToken)
+			}
+			return value, nil
+		case hasID:
+			return Value{}, fmt.Errorf("environment %s is set but %s is empty", keys.AccessKeyID, keys.SecretAccessKey)
+		case hasSecret:
+			return Value{}, fmt.Errorf("environment %s is set but %s is empty", keys.SecretAccessKey, keys.AccessKeyID)
+		}
+	}
+	return Value{}, fmt.Errorf("%w in environment", ErrNoCredentials)
+}
@@ -0,0 +1,86 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func fakeEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func TestEnvProviderRetrieve(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantID     string
+		wantSource string
+		wantErr    error
+	}{
+		{
+			name:       "aws",
+			env:        map[string]string{"AWS_ACCESS_KEY_ID": "aws-id", "AWS_SECRET_ACCESS_KEY": "aws-secret"},
+			wantID:     "aws-id",
+			wantSource: "environment AWS_ACCESS_KEY_ID",
+		},
+		{
+			name:       "alibaba cloud",
+			env:        map[string]string{"ALIBABA_CLOUD_ACCESS_KEY_ID": "ali-id", "ALIBABA_CLOUD_ACCESS_KEY_SECRET": "ali-secret"},
+			wantID:     "ali-id",
+			wantSource: "environment ALIBABA_CLOUD_ACCESS_KEY_ID",
+		},
+		{
+			name:       "minio root user",
+			env:        map[string]string{"MINIO_ROOT_USER": "minio", "MINIO_ROOT_PASSWORD": "minio123"},
+			wantID:     "minio",
+			wantSource: "environment MINIO_ROOT_USER",
+		},
+		{
+			name: "aws takes precedence",
+			env: map[string]string{
+				"AWS_ACCESS_KEY_ID": "aws-id", "AWS_SECRET_ACCESS_KEY": "aws-secret",
+				"MINIO_ACCESS_KEY": "minio-id", "MINIO_SECRET_KEY": "minio-secret",
+			},
+			wantID:     "aws-id",
+			wantSource: "environment AWS_ACCESS_KEY_ID",
+		},
+		{
+			name:    "empty values are ignored",
+			env:     map[string]string{"AWS_ACCESS_KEY_ID": "", "AWS_SECRET_ACCESS_KEY": ""},
+			wantErr: ErrNoCredentials,
+		},
+		{name: "nothing set", env: map[string]string{}, wantErr: ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewEnvProvider()
+			p.lookup = fakeEnv(tt.env)
+			got, err := p.Retrieve(context.Background())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Retrieve() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if got.AccessKeyID != tt.wantID || got.SecretAccessKey == "" || got.Source != tt.wantSource {
+				t.Errorf("Retrieve() = %+v, want id %s from %s", got, tt.wantID, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestEnvProviderPartialPair(t *testing.T) {
+	p := NewEnvProvider()
+	p.lookup = fakeEnv(map[string]string{"ALIBABA_CLOUD_ACCESS_KEY_ID": "ali-id"})
+	_, err := p.Retrieve(context.Background())
+	if err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Retrieve() error = %v, want configuration error", err)
+	}
+}
@@ -0,0 +1,232 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/noovertime7/velero-os-plugin/internal/ini"
+)
+
+const (
+	// DefaultCredentialsFile Velero 默认挂载凭证 Secret 的路径
+	DefaultCredentialsFile = "/credentials/cloud"
+	// DefaultProfile 未指定 profile 时使用的 profile
+	DefaultProfile = "default"
+
+	accessKeyIDKey     = "aws_access_key_id"
+	secretAccessKeyKey = "aws_secret_access_key"
+	sessionTokenKey    = "aws_session_token"
+	// securityTokenKey 部分工具写入的 aws_session_token 的旧名称
+	securityTokenKey = "aws_security_token"
+
+	// 扮演角色使用的配置，与 AWS CLI 的 shared config 一致
+	roleARNKey         = "role_arn"
+	sourceProfileKey   = "source_profile"
+	roleSessionNameKey = "role_session_name"
+	durationSecondsKey = "duration_seconds"
+	externalIDKey      = "external_id"
+	stsEndpointKey     = "sts_endpoint"
+	regionKey          = "region"
+	// webIdentityTokenFileKey 配置后使用 ServiceAccount token 扮演角色，不再需要 source_profile
+	webIdentityTokenFileKey = "web_identity_token_file"
+	oidcProviderARNKey      = "oidc_provider_arn"
+	// credentialProcessKey 输出 JSON 格式凭证的外部命令
+	credentialProcessKey = "credential_process"
+)
+
+// expirationKeys 记录临时凭证过期时间（RFC3339）的键，设置后插件会在过期前重新读取凭证文件
+var expirationKeys = []string{"aws_expiration", "x_security_token_expires"}
+
+// FileProvider 从 AWS 格式的 INI 凭证文件中读取指定 profile 的凭证。
+// profile 配置了 role_arn 时，先读取 source_profile 的凭证，再用它扮演 role_arn 对应的角色，
+// source_profile 同样可以配置 role_arn，实现角色链；同时配置了 web_identity_token_file 时
+// 使用 ServiceAccount token 扮演角色。profile 也可以通过 credential_process 配置一个输出凭证的外部命令
+type FileProvider struct {
+	Path    string
+	Profile string
+	// Required 为 true 时文件不存在视为配置错误，否则视为没有凭证
+	Required bool
+	// STS 扮演角色时调用 STS 服务的默认配置
+	STS STSOptions
+	// ProcessTimeout credential_process 命令的超时时间，为 0 时使用 DefaultProcessTimeout
+	ProcessTimeout time.Duration
+}
+
+// NewFileProvider 创建一个 FileProvider，profile 为空时使用 DefaultProfile
+func NewFileProvider(path, profile string, required bool) *FileProvider {
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	return &FileProvider{Path: path, Profile: profile, Required: required}
+}
+
+// Name 实现 Provider 接口
+func (f *FileProvider) Name() string {
+	return fmt.Sprintf("credentials file %s [%s]", f.Path, f.Profile)
+}
+
+// Retrieve 读取凭证文件中 profile 对应的凭证，profile 配置了 role_arn 时返回扮演角色得到的临时凭证
+func (f *FileProvider) Retrieve(ctx context.Context) (Value, error) {
+	if _, err := os.Stat(f.Path); err != nil {
+		switch {
+		case os.IsNotExist(err) && f.Required:
+			return Value{}, fmt.Errorf("provided credentials file %s does not exist", f.Path)
+		case os.IsNotExist(err):
+			return Value{}, fmt.Errorf("%w: %s does not exist", ErrNoCredentials, f.Path)
+		default:
+			return Value{}, fmt.Errorf("could not get credentials file info: %w", err)
+		}
+	}
+
+	sections, err := ini.OpenFile(f.Path)
+	if err != nil {
+		return Value{}, fmt.Errorf("read credentials file %s error: %w", f.Path, err)
+	}
+	value, err := f.retrieveProfile(ctx, sections, f.Profile, nil)
+	if err != nil {
+		return Value{}, err
+	}
+	value.Profile = f.Profile
+	return value, nil
+}
+
+// retrieveProfile 读取 profile 的凭证，chain 记录角色链上已经访问过的 profile，用于发现循环引用
+func (f *FileProvider) retrieveProfile(ctx context.Context, sections ini.Sections, profile string, chain []string) (Value, error) {
+	section, ok := sections.GetSection(profile)
+	if !ok {
+		return Value{}, fmt.Errorf("profile %s not found in %s", profile, f.Path)
+	}
+
+	roleARN := section.String(roleARNKey)
+	if roleARN == "" {
+		return f.baseProfile(ctx, section, profile)
+	}
+
+	if tokenFile := section.String(webIdentityTokenFileKey); tokenFile != "" {
+		input, err := f.assumeRoleInput(section, profile, roleARN)
+		if err != nil {
+			return Value{}, err
+		}
+		value, err := assumeRoleWithWebIdentity(ctx, tokenFile, input, f.STS.HTTPClient)
+		if err != nil {
+			return Value{}, fmt.Errorf("profile %s: %w", profile, err)
+		}
+		return value, nil
+	}
+
+	sourceProfile := section.String(sourceProfileKey)
+	if sourceProfile == "" {
+		return Value{}, fmt.Errorf("%s of profile %s is empty in %s", sourceProfileKey, profile, f.Path)
+	}
+	chain = append(chain, profile)
+	var source Value
+	var err error
+	switch {
+	case sourceProfile == profile:
+		// 与 AWS CLI 一致，source_profile 指向自身时使用该 profile 中的长期凭证
+		source, err = f.baseProfile(ctx, section, profile)
+	case contains(chain, sourceProfile):
+		return Value{}, fmt.Errorf("source profile loop in %s: %s -> %s", f.Path, strings.Join(chain, " -> "), sourceProfile)
+	default:
+		source, err = f.retrieveProfile(ctx, sections, sourceProfile, chain)
+	}
+	if err != nil {
+		return Value{}, err
+	}
+
+	input, err := f.assumeRoleInput(section, profile, roleARN)
+	if err != nil {
+		return Value{}, err
+	}
+	value, err := assumeRole(ctx, source, input, f.STS.HTTPClient)
+	if err != nil {
+		return Value{}, fmt.Errorf("profile %s: %w", profile, err)
+	}
+	value.Source = fmt.Sprintf("role %s assumed with %s", roleARN, source.Source)
+	return value, nil
+}
+
+// baseProfile 读取不扮演角色的 profile 的凭证：优先使用静态密钥，
+// 没有配置 aws_access_key_id 但配置了 credential_process 时执行该命令获取凭证
+func (f *FileProvider) baseProfile(ctx context.Context, section ini.Section, profile string) (Value, error) {
+	command := section.String(credentialProcessKey)
+	if command == "" || section.String(accessKeyIDKey) != "" {
+		return f.staticProfile(section, profile)
+	}
+	value, err := runCredentialProcess(ctx, command, f.ProcessTimeout)
+	if err != nil {
+		return Value{}, fmt.Errorf("profile %s: %w", profile, err)
+	}
+	value.Source = fmt.Sprintf("credential process of %s [%s]", f.Path, profile)
+	return value, nil
+}
+
+// staticProfile 读取 profile 中的 aws_access_key_id 与 aws_secret_access_key，
+// 以及可选的 aws_session_token 与过期时间
+func (f *FileProvider) staticProfile(section ini.Section, profile string) (Value, error) {
+	id := section.String(accessKeyIDKey)
+	if id == "" {
+		return Value{}, fmt.Errorf("%s of profile %s is empty in %s", accessKeyIDKey, profile, f.Path)
+	}
+	secret := section.String(secretAccessKeyKey)
+	if secret == "" {
+		return Value{}, fmt.Errorf("%s of profile %s is empty in %s", secretAccessKeyKey, profile, f.Path)
+	}
+
+	value := Value{AccessKeyID: id, SecretAccessKey: secret, Source: fmt.Sprintf("credentials file %s [%s]", f.Path, profile)}
+	if value.SessionToken = section.String(sessionTokenKey); value.SessionToken == "" {
+		value.SessionToken = section.String(securityTokenKey)
+	}
+	for _, key := range expirationKeys {
+		if expiration := section.String(key); expiration != "" {
+			var err error
+			if value.Expires, err = time.Parse(time.RFC3339, expiration); err != nil {
+				return Value{}, fmt.Errorf("parse %s of profile %s in %s error: %w", key, profile, f.Path, err)
+			}
+			break
+		}
+	}
+	return value, nil
+}
+
+// assumeRoleInput 读取 profile 中扮演角色的参数，未配置的参数使用默认值
+func (f *FileProvider) assumeRoleInput(section ini.Section, profile, roleARN string) (AssumeRoleInput, error) {
+	input := AssumeRoleInput{
+		RoleARN:         roleARN,
+		SessionName:     section.String(roleSessionNameKey),
+		Duration:        DefaultRoleDuration,
+		ExternalID:      section.String(externalIDKey),
+		OIDCProviderARN: section.String(oidcProviderARNKey),
+		Endpoint:        section.String(stsEndpointKey),
+		Region:          section.String(regionKey),
+	}
+	if input.SessionName == "" {
+		input.SessionName = DefaultRoleSessionName
+	}
+	if input.Endpoint == "" {
+		input.Endpoint = f.STS.Endpoint
+	}
+	if input.Region == "" {
+		input.Region = f.STS.Region
+	}
+	if section.Has(durationSecondsKey) {
+		input.Duration = time.Duration(section.Int(durationSecondsKey)) * time.Second
+		if input.Duration < minRoleDuration {
+			return AssumeRoleInput{}, fmt.Errorf("%s of profile %s in %s must be at least %d",
+				durationSecondsKey, profile, f.Path, int(minRoleDuration.Seconds()))
+		}
+	}
+	return input, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCredentialsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cloud")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileProviderRetrieve(t *testing.T) {
+	path := writeCredentialsFile(t, `[default]
+aws_access_key_id = default-id
+aws_secret_access_key = default-secret
+
+[backup]
+aws_access_key_id = backup-id
+aws_secret_access_key = backup-secret
+
+[broken]
+aws_access_key_id = broken-id
+`)
+
+	tests := []struct {
+		name        string
+		profile     string
+		wantID      string
+		wantProfile string
+		wantErr     bool
+	}{
+		{name: "default profile", profile: "", wantID: "default-id", wantProfile: "default"},
+		{name: "named profile", profile: "backup", wantID: "backup-id", wantProfile: "backup"},
+		{name: "missing profile", profile: "missing", wantErr: true},
+		{name: "missing secret", profile: "broken", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFileProvider(path, tt.profile, true).Retrieve(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Retrieve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.AccessKeyID != tt.wantID || got.Profile != tt.wantProfile) {
+				t.Errorf("Retrieve() access key = %s, profile = %s, want %s, %s", got.AccessKeyID, got.Profile, tt.wantID, tt.wantProfile)
+			}
+			if err != nil && errors.Is(err, ErrNoCredentials) {
+				t.Errorf("Retrieve() error = %v, want configuration error", err)
+			}
+		})
+	}
+}
+
+func TestFileProviderMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing")
+
+	if _, err := NewFileProvider(path, "", false).Retrieve(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("optional file: Retrieve() error = %v, want %v", err, ErrNoCredentials)
+	}
+	if _, err := NewFileProvider(path, "", true).Retrieve(context.Background()); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("required file: Retrieve() error = %v, want configuration error", err)
+	}
+}
+
+func TestFileProviderSessionToken(t *testing.T) {
+	path := writeCredentialsFile(t, `[sts]
+aws_access_key_id = sts-id
+aws_secret_access_key = sts-secret
+aws_session_token = session-token
+aws_expiration = 2030-01-02T03:04:05Z
+
+[legacy]
+aws_access_key_id = legacy-id
+aws_secret_access_key = legacy-secret
+aws_security_token = security-token
+x_security_token_expires = 2030-01-02T03:04:05+08:00
+
+[bad-expiration]
+aws_access_key_id = id
+aws_secret_access_key = secret
+aws_expiration = tomorrow
+`)
+
+	tests := []struct {
+		profile     string
+		wantToken   string
+		wantExpires time.Time
+		wantErr     bool
+	}{
+		{profile: "sts", wantToken: "session-token", wantExpires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{profile: "legacy", wantToken: "security-token", wantExpires: time.Date(2030, 1, 1, 19, 4, 5, 0, time.UTC)},
+		{profile: "bad-expiration", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			got, err := NewFileProvider(path, tt.profile, true).Retrieve(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Retrieve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.SessionToken != tt.wantToken {
+				t.Errorf("Retrieve() session token = %s, want %s", got.SessionToken, tt.wantToken)
+			}
+			if !got.Expires.Equal(tt.wantExpires) {
+				t.Errorf("Retrieve() expires = %s, want %s", got.Expires, tt.wantExpires)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// metadataTokenTTL 元数据服务会话 token 的有效期
+	metadataTokenTTL = 6 * time.Hour
+	// metadataTokenWindow 会话 token 在过期前多久重新获取
+	metadataTokenWindow = time.Minute
+	// metadataTimeout 访问元数据服务的超时时间，元数据服务在本机网络内，响应很快
+	metadataTimeout = 5 * time.Second
+)
+
+// metadataService 描述一种元数据服务的接口地址与请求头，ECS 与 EC2 都使用 IMDSv2 风格的会话 token
+type metadataService struct {
+	name            string
+	endpoint        string
+	credentialsPath string
+	tokenPath       string
+	tokenTTLHeader  string
+	tokenHeader     string
+}
+
+var (
+	// ecsMetadata 阿里云 ECS 元数据服务，返回实例 RAM 角色的临时凭证
+	ecsMetadata = metadataService{
+		name:            "ecs ram role",
+		endpoint:        "http://100.100.100.200",
+		credentialsPath: "/latest/meta-data/ram/security-credentials/",
+		tokenPath:       "/latest/api/token",
+		tokenTTLHeader:  "X-aliyun-ecs-metadata-token-ttl-seconds",
+		tokenHeader:     "X-aliyun-ecs-metadata-token",
+	}
+	// ec2Metadata AWS EC2 元数据服务（IMDSv2），返回实例 IAM 角色的临时凭证
+	ec2Metadata = metadataService{
+		name:            "ec2 instance profile",
+		endpoint:        "http://169.254.169.254",
+		credentialsPath: "/latest/meta-data/iam/security-credentials/",
+		tokenPath:       "/latest/api/token",
+		tokenTTLHeader:  "X-aws-ec2-metadata-token-ttl-seconds",
+		tokenHeader:     "X-aws-ec2-metadata-token",
+	}
+)
+
+// MetadataProvider 从实例元数据服务获取实例角色的临时凭证，适用于直接运行在 ECS/EC2 节点上、
+// 不在集群中保存访问密钥的场景。元数据服务的会话 token 会缓存到过期前
+type MetadataProvider struct {
+	service metadataService
+	// Endpoint 元数据服务地址，为空时使用官方地址
+	Endpoint string
+	// RoleName 实例角色名称，为空时从元数据服务查询
+	RoleName string
+	// HTTPClient 访问元数据服务使用的客户端
+	HTTPClient *http.Client
+
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+}
+
+// NewECSRAMRoleProvider 创建一个获取阿里云 ECS 实例 RAM 角色凭证的 MetadataProvider
+func NewECSRAMRoleProvider(roleName string) *MetadataProvider {
+	return newMetadataProvider(ecsMetadata, roleName)
+}
+
+// NewEC2RoleProvider 创建一个通过 IMDSv2 获取 AWS EC2 实例角色凭证的 MetadataProvider
+func NewEC2RoleProvider(roleName string) *MetadataProvider {
+	return newMetadataProvider(ec2Metadata, roleName)
+}
+
+func newMetadataProvider(service metadataService, roleName string) *MetadataProvider {
+	return &MetadataProvider{
+		service:    service,
+		Endpoint:   service.endpoint,
+		RoleName:   roleName,
+		HTTPClient: &http.Client{Timeout: metadataTimeout},
+	}
+}
+
+// Name 实现 Provider 接口
+func (m *MetadataProvider) Name() string {
+	return "instance metadata (" + m.service.name + ")"
+}
+
+// metadataCredentials 元数据服务返回的临时凭证，ECS 与 EC2 的字段名称不同
+type metadataCredentials struct {
+	Code            string `json:"Code"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SecurityToken   string `json:"SecurityToken"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration"`
+}
+
+// Retrieve 实现 Provider 接口
+func (m *MetadataProvider) Retrieve(ctx context.Context) (Value, error) {
+	role := m.RoleName
+	if role == "" {
+		body, err := m.get(ctx, m.service.credentialsPath)
+		if err != nil {
+			return Value{}, fmt.Errorf("query instance role error: %w", err)
+		}
+		if role = strings.TrimSpace(strings.SplitN(string(body), "\n", 2)[0]); role == "" {
+			return Value{}, fmt.Errorf("no role is attached to the instance")
+		}
+	}
+
+	body, err := m.get(ctx, m.service.credentialsPath+role)
+	if err != nil {
+		return Value{}, fmt.Errorf("get credentials of instance role %s error: %w", role, err)
+	}
+	var creds metadataCredentials
+	if err := json.Unmarshal(body, &creds); err != nil {
+		return Value{}, fmt.Errorf("decode credentials of instance role %s error: %w", role, err)
+	}
+	if creds.Code != "" && creds.Code != "Success" {
+		return Value{}, fmt.Errorf("get credentials of instance role %s error: %s", role, creds.Code)
+	}
+
+	value := Value{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: firstNonEmpty(creds.AccessKeySecret, creds.SecretAccessKey),
+		SessionToken:    firstNonEmpty(creds.SecurityToken, creds.Token),
+		Source:          fmt.Sprintf("%s %s", m.Name(), role),
+	}
+	if value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return Value{}, fmt.Errorf("credentials of instance role %s are empty", role)
+	}
+	if value.Expires, err = time.Parse(time.RFC3339, creds.Expiration); err != nil {
+		return Value{}, fmt.Errorf("parse expiration of instance role %s error: %w", role, err)
+	}
+	return value, nil
+}
+
+// get 携带会话 token 访问元数据服务，token 失效时重新获取一次
+func (m *MetadataProvider) get(ctx context.Context, path string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := m.sessionToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(m.Endpoint, "/")+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(m.service.tokenHeader, token)
+		resp, err := m.HTTPClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized && attempt == 0:
+			m.resetSessionToken()
+		case resp.StatusCode != http.StatusOK:
+			return nil, fmt.Errorf("%s returned status %d", path, resp.StatusCode)
+		default:
+			return body, nil
+		}
+	}
+}
+
+// sessionToken 返回缓存的会话 token，即将过期时重新获取
+func (m *MetadataProvider) sessionToken(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != "" && time.Now().Add(metadataTokenWindow).Before(m.tokenExpires) {
+		return m.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, strings.TrimSuffix(m.Endpoint, "/")+m.service.tokenPath, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(m.service.tokenTTLHeader, strconv.Itoa(int(metadataTokenTTL.Seconds())))
+	resp, err := m.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("get metadata session token error: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("get metadata session token error: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get metadata session token error: status %d", resp.StatusCode)
+	}
+
+	m.token, m.tokenExpires = strings.TrimSpace(string(body)), time.Now().Add(metadataTokenTTL)
+	return m.token, nil
+}
+
+func (m *MetadataProvider) resetSessionToken() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.token = ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeMetadata 模拟 ECS 与 EC2 的元数据服务
+type fakeMetadata struct {
+	*httptest.Server
+	service metadataService
+	role    string
+	creds   map[string]string
+
+	mu sync.Mutex
+	// tokens 已经签发的会话 token
+	tokens      []string
+	tokenCalls  int
+	credsCalls  int
+	rejectToken string
+}
+
+func newFakeMetadata(t *testing.T, service metadataService, creds map[string]string) *fakeMetadata {
+	f := &fakeMetadata{service: service, role: "velero-role", creds: creds}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeMetadata) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method == http.MethodPut && r.URL.Path == f.service.tokenPath {
+		if r.Header.Get(f.service.tokenTTLHeader) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.tokenCalls++
+		token := "session-" + strings.Repeat("x", f.tokenCalls)
+		f.tokens = append(f.tokens, token)
+		w.Write([]byte(token))
+		return
+	}
+
+	token := r.Header.Get(f.service.tokenHeader)
+	if token == "" || token == f.rejectToken || !contains(f.tokens, token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case f.service.credentialsPath:
+		w.Write([]byte(f.role))
+	case f.service.credentialsPath + f.role:
+		f.credsCalls++
+		json.NewEncoder(w).Encode(f.creds)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// counts 返回凭证与会话 token 接口的调用次数
+func (f *fakeMetadata) counts() (creds, tokens int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.credsCalls, f.tokenCalls
+}
+
+func TestMetadataProviderRetrieve(t *testing.T) {
+	expires := time.Now().Add(6 * time.Hour).UTC().Truncate(time.Second)
+	tests := []struct {
+		name      string
+		service   metadataService
+		newFunc   func(role string) *MetadataProvider
+		creds     map[string]string
+		role      string
+		wantToken string
+		wantErr   bool
+	}{
+		{
+			name:    "ecs ram role",
+			service: ecsMetadata,
+			newFunc: NewECSRAMRoleProvider,
+			creds: map[string]string{
+				"Code": "Success", "AccessKeyId": "STS.ecs", "AccessKeySecret": "ecs-secret",
+				"SecurityToken": "ecs-token", "Expiration": expires.Format(time.RFC3339),
+			},
+			wantToken: "ecs-token",
+		},
+		{
+			name:    "ec2 imdsv2",
+			service: ec2Metadata,
+			newFunc: NewEC2RoleProvider,
+			creds: map[string]string{
+				"Code": "Success", "AccessKeyId": "ASIA-ec2", "SecretAccessKey": "ec2-secret",
+				"Token": "ec2-token", "Expiration": expires.Format(time.RFC3339),
+			},
+			wantToken: "ec2-token",
+		},
+		{
+			name:    "configured role name",
+			service: ec2Metadata,
+			newFunc: NewEC2RoleProvider,
+			role:    "velero-role",
+			creds: map[string]string{
+				"AccessKeyId": "ASIA-ec2", "SecretAccessKey": "ec2-secret", "Token": "ec2-token", "Expiration": expires.Format(time.RFC3339),
+			},
+			wantToken: "ec2-token",
+		},
+		{
+			name:    "unknown role name",
+			service: ecsMetadata,
+			newFunc: NewECSRAMRoleProvider,
+			role:    "other",
+			wantErr: true,
+		},
+		{
+			name:    "error code",
+			service: ecsMetadata,
+			newFunc: NewECSRAMRoleProvider,
+			creds:   map[string]string{"Code": "Failed"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeMetadata(t, tt.service, tt.creds)
+			provider := tt.newFunc(tt.role)
+			provider.Endpoint = server.URL
+
+			got, err := provider.Retrieve(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Retrieve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.SessionToken != tt.wantToken || got.SecretAccessKey == "" || !got.Expires.Equal(expires) {
+				t.Errorf("Retrieve() = %+v, want token %s expiring at %s", got, tt.wantToken, expires)
+			}
+		})
+	}
+}
+
+func TestMetadataProviderCachesSessionToken(t *testing.T) {
+	server := newFakeMetadata(t, ec2Metadata, map[string]string{
+		"AccessKeyId": "ASIA-ec2", "SecretAccessKey": "ec2-secret", "Token": "ec2-token",
+		"Expiration": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	})
+	provider := NewEC2RoleProvider("")
+	provider.Endpoint = server.URL
+	creds := NewCredentials(provider, logrus.New())
+
+	// 临时凭证未过期时不访问元数据服务
+	for i := 0; i < 3; i++ {
+		if _, err := creds.Get(context.Background()); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if credsCalls, tokenCalls := server.counts(); credsCalls != 1 || tokenCalls != 1 {
+		t.Errorf("metadata called creds=%d token=%d, want 1 and 1", credsCalls, tokenCalls)
+	}
+
+	// 凭证过期后重新获取，会话 token 仍然有效，不重新申请
+	creds.ExpiryWindow = 2 * time.Hour
+	if _, err := creds.Get(context.Background()); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if credsCalls, tokenCalls := server.counts(); credsCalls != 2 || tokenCalls != 1 {
+		t.Errorf("metadata called creds=%d token=%d, want 2 and 1", credsCalls, tokenCalls)
+	}
+
+	// 会话 token 被服务端作废后重新申请
+	server.mu.Lock()
+	server.rejectToken = server.tokens[0]
+	server.mu.Unlock()
+	if _, err := provider.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() with revoked session token error = %v", err)
+	}
+	if _, tokenCalls := server.counts(); tokenCalls != 2 {
+		t.Errorf("metadata token requested %d times, want 2", tokenCalls)
+	}
+}
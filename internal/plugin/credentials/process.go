@@ -0,0 +1,90 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultProcessTimeout credential_process 命令的默认超时时间
+	DefaultProcessTimeout = time.Minute
+	// processOutputLimit credential_process 命令输出的最大长度
+	processOutputLimit = 64 * 1024
+)
+
+// processOutput credential_process 命令输出的 JSON，格式与 AWS CLI 的约定一致
+type processOutput struct {
+	Version         int    `json:"Version"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+// limitedBuffer 超过 limit 后丢弃多余输出的 Buffer
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remain := b.limit - b.Len(); len(p) > remain {
+		b.truncated = true
+		b.Buffer.Write(p[:remain])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// runCredentialProcess 通过 sh -c 执行 command，解析其标准输出中的凭证
+func runCredentialProcess(ctx context.Context, command string, timeout time.Duration) (Value, error) {
+	if timeout <= 0 {
+		timeout = DefaultProcessTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: processOutputLimit}
+	stderr := &limitedBuffer{limit: processOutputLimit}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// 命令启动的子进程可能继续占用输出管道，超时后不再等待
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return Value{}, fmt.Errorf("credential process timed out after %s", timeout)
+		}
+		return Value{}, fmt.Errorf("credential process error: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.truncated {
+		return Value{}, fmt.Errorf("credential process output exceeds %d bytes", processOutputLimit)
+	}
+
+	var output processOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return Value{}, fmt.Errorf("decode credential process output error: %w", err)
+	}
+	if output.Version != 1 {
+		return Value{}, fmt.Errorf("unsupported credential process output version %d", output.Version)
+	}
+	if output.AccessKeyID == "" || output.SecretAccessKey == "" {
+		return Value{}, fmt.Errorf("credential process returned empty AccessKeyId or SecretAccessKey")
+	}
+
+	value := Value{AccessKeyID: output.AccessKeyID, SecretAccessKey: output.SecretAccessKey, SessionToken: output.SessionToken}
+	if output.Expiration != "" {
+		expires, err := time.Parse(time.RFC3339, output.Expiration)
+		if err != nil {
+			return Value{}, fmt.Errorf("parse credential process expiration error: %w", err)
+		}
+		value.Expires = expires
+	}
+	return value, nil
+}
@@ -0,0 +1,134 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// writeScript 写入一个可执行的 shell 脚本，返回其路径。
+// 凭证文件中的命令通常是脚本路径，内联的 JSON 包含多个逗号时 ini 无法正确解析
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "creds.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileProviderCredentialProcess(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	tests := []struct {
+		name      string
+		script    string
+		timeout   time.Duration
+		wantID    string
+		wantToken string
+		wantErr   string
+	}{
+		{
+			name: "temporary credentials",
+			script: fmt.Sprintf(`echo '{"Version": 1, "AccessKeyId": "process-id", "SecretAccessKey": "process-secret", "SessionToken": "process-token", "Expiration": "%s"}'`,
+				expires.Format(time.RFC3339)),
+			wantID:    "process-id",
+			wantToken: "process-token",
+		},
+		{
+			name:   "long-term credentials",
+			script: `echo '{"Version": 1, "AccessKeyId": "process-id", "SecretAccessKey": "process-secret"}'`,
+			wantID: "process-id",
+		},
+		{name: "unsupported version", script: `echo '{"Version": 2, "AccessKeyId": "id", "SecretAccessKey": "secret"}'`, wantErr: "version 2"},
+		{name: "missing secret", script: `echo '{"Version": 1, "AccessKeyId": "id"}'`, wantErr: "empty AccessKeyId or SecretAccessKey"},
+		{name: "invalid json", script: `echo not json`, wantErr: "decode"},
+		{name: "command fails", script: `echo vault is sealed >&2; exit 3`, wantErr: "vault is sealed"},
+		{name: "timeout", script: `sleep 10`, timeout: 100 * time.Millisecond, wantErr: "timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeCredentialsFile(t, fmt.Sprintf("[default]\ncredential_process = %s --profile backup\n", writeScript(t, tt.script)))
+			provider := NewFileProvider(path, "", true)
+			provider.ProcessTimeout = tt.timeout
+
+			start := time.Now()
+			got, err := provider.Retrieve(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Retrieve() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				if elapsed := time.Since(start); elapsed > 5*time.Second {
+					t.Errorf("Retrieve() took %s", elapsed)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if got.AccessKeyID != tt.wantID || got.SecretAccessKey != "process-secret" || got.SessionToken != tt.wantToken {
+				t.Errorf("Retrieve() = %+v, want %s", got, tt.wantID)
+			}
+			if tt.wantToken != "" && !got.Expires.Equal(expires) {
+				t.Errorf("Retrieve() expires = %s, want %s", got.Expires, expires)
+			}
+		})
+	}
+}
+
+func TestCredentialProcessCachedUntilExpiry(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "runs")
+	// 脚本每次执行时记录一次，并返回一小时后过期的临时凭证
+	script := writeScript(t, fmt.Sprintf(`echo run >> %s
+echo '{"Version": 1, "AccessKeyId": "vault-id", "SecretAccessKey": "vault-secret", "SessionToken": "vault-token", "Expiration": "%s"}'`,
+		counter, time.Now().Add(time.Hour).UTC().Format(time.RFC3339)))
+	path := writeCredentialsFile(t, fmt.Sprintf("[default]\ncredential_process = %s\n", script))
+	creds := NewCredentials(NewFileProvider(path, "", true), logrus.New())
+
+	runs := func() int {
+		data, _ := os.ReadFile(counter)
+		return strings.Count(string(data), "run")
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := creds.Get(context.Background()); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if n := runs(); n != 1 {
+		t.Errorf("credential process ran %d times for cached credentials, want 1", n)
+	}
+
+	creds.ExpiryWindow = 2 * time.Hour
+	if _, err := creds.Get(context.Background()); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if n := runs(); n != 2 {
+		t.Errorf("credential process ran %d times after expiry, want 2", n)
+	}
+}
+
+func TestCredentialProcessAsSourceProfile(t *testing.T) {
+	server := newFakeSTS(t)
+	script := writeScript(t, `echo '{"Version": 1, "AccessKeyId": "vault-id", "SecretAccessKey": "vault-secret"}'`)
+	path := writeCredentialsFile(t, fmt.Sprintf(`[vault]
+credential_process = %s
+
+[default]
+role_arn = arn:aws:iam::123456789012:role/velero
+source_profile = vault
+`, script))
+	provider := NewFileProvider(path, "", true)
+	provider.STS = STSOptions{Endpoint: server.URL}
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if got.AccessKeyID != "ASIA-1-vault-id" {
+		t.Errorf("Retrieve() access key = %s, want role credentials assumed with vault-id", got.AccessKeyID)
+	}
+}
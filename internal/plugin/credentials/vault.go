@@ -0,0 +1,294 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultVaultAuthPath Vault Kubernetes 认证方式的默认挂载路径
+	DefaultVaultAuthPath = "kubernetes"
+	// DefaultServiceAccountTokenFile Pod 中 ServiceAccount token 的默认路径
+	DefaultServiceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// vaultTimeout 访问 Vault 的超时时间
+	vaultTimeout = 30 * time.Second
+)
+
+// vaultKeys 从 Vault 返回的 data 中读取凭证使用的键，依次检查。
+// AWS 引擎返回 access_key/secret_key/security_token，KV 引擎中的键由用户写入，兼容凭证文件的写法
+var (
+	vaultAccessKeyIDKeys     = []string{"access_key", "access_key_id", accessKeyIDKey}
+	vaultSecretAccessKeyKeys = []string{"secret_key", "secret_access_key", secretAccessKeyKey}
+	vaultSessionTokenKeys    = []string{"security_token", "session_token", sessionTokenKey, securityTokenKey}
+)
+
+// VaultProvider 使用 Kubernetes 认证方式登录 HashiCorp Vault，从 Path 读取凭证：
+// KV 引擎（v1 与 v2）返回保存的访问密钥，AWS 引擎为每次读取生成新的访问密钥。
+// 登录得到的 token 与 AWS 引擎的租约在过期前续期，续期失败时重新登录并读取
+type VaultProvider struct {
+	// Address Vault 服务地址，如 https://vault.vault:8200
+	Address string
+	// Role Kubernetes 认证方式中配置的角色
+	Role string
+	// Path 读取凭证的路径，如 secret/data/velero 或 aws/creds/velero
+	Path string
+	// AuthPath Kubernetes 认证方式的挂载路径，为空时使用 DefaultVaultAuthPath
+	AuthPath string
+	// Namespace Vault 企业版的命名空间
+	Namespace string
+	// TokenFile ServiceAccount token 文件，为空时使用 DefaultServiceAccountTokenFile
+	TokenFile string
+	// HTTPClient 访问 Vault 使用的客户端
+	HTTPClient *http.Client
+
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+	tokenRenew   bool
+	// lease 上一次读取到的可续期凭证
+	lease *vaultLease
+}
+
+// vaultLease 可续期的凭证及其租约
+type vaultLease struct {
+	id    string
+	value Value
+}
+
+// NewVaultProvider 创建一个 VaultProvider
+func NewVaultProvider(address, role, path string) *VaultProvider {
+	return &VaultProvider{
+		Address:    address,
+		Role:       role,
+		Path:       path,
+		AuthPath:   DefaultVaultAuthPath,
+		TokenFile:  DefaultServiceAccountTokenFile,
+		HTTPClient: &http.Client{Timeout: vaultTimeout},
+	}
+}
+
+// Name 实现 Provider 接口
+func (v *VaultProvider) Name() string {
+	return "vault " + v.Path
+}
+
+// vaultResponse Vault 接口的响应
+type vaultResponse struct {
+	LeaseID       string          `json:"lease_id"`
+	LeaseDuration int64           `json:"lease_duration"`
+	Renewable     bool            `json:"renewable"`
+	Data          json.RawMessage `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// Retrieve 实现 Provider 接口。上一次读取的凭证可以续期时续期租约并返回同一组凭证，
+// 否则重新读取 Path
+func (v *VaultProvider) Retrieve(ctx context.Context) (Value, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.ensureToken(ctx); err != nil {
+		return Value{}, err
+	}
+	if v.lease != nil {
+		value, err := v.renewLease(ctx)
+		if err == nil {
+			return value, nil
+		}
+		// 租约无法续期时（如达到最长有效期）读取新的凭证
+		v.lease = nil
+	}
+
+	resp, err := v.do(ctx, http.MethodGet, v.Path, nil)
+	if err != nil {
+		return Value{}, fmt.Errorf("read vault %s error: %w", v.Path, err)
+	}
+	value, err := vaultValue(resp.Data)
+	if err != nil {
+		return Value{}, fmt.Errorf("read vault %s error: %w", v.Path, err)
+	}
+	value.Source = fmt.Sprintf("vault %s%s", strings.TrimSuffix(v.Address, "/")+"/v1/", v.Path)
+	if resp.LeaseDuration > 0 {
+		value.Expires = time.Now().Add(time.Duration(resp.LeaseDuration) * time.Second)
+	}
+	if resp.LeaseID != "" {
+		// 租约随 token 一起失效，凭证不能比 token 更晚过期
+		value.Expires = v.boundByToken(value.Expires)
+		if resp.Renewable {
+			v.lease = &vaultLease{id: resp.LeaseID, value: value}
+		}
+	}
+	return value, nil
+}
+
+// renewLease 续期上一次读取的凭证的租约，调用方需持有 mu
+func (v *VaultProvider) renewLease(ctx context.Context) (Value, error) {
+	lease := v.lease
+	resp, err := v.do(ctx, http.MethodPut, "sys/leases/renew", map[string]interface{}{"lease_id": lease.id})
+	if err != nil {
+		return Value{}, fmt.Errorf("renew vault lease %s error: %w", lease.id, err)
+	}
+	if resp.LeaseDuration <= 0 {
+		return Value{}, fmt.Errorf("vault lease %s is not renewed", lease.id)
+	}
+	lease.value.Expires = v.boundByToken(time.Now().Add(time.Duration(resp.LeaseDuration) * time.Second))
+	return lease.value, nil
+}
+
+// ensureToken 登录 Vault，或在 token 即将过期时续期，续期失败时重新登录。调用方需持有 mu
+func (v *VaultProvider) ensureToken(ctx context.Context) error {
+	if v.token != "" && !v.tokenExpiresWithin(DefaultExpiryWindow) {
+		return nil
+	}
+	if v.token != "" && v.tokenRenew {
+		resp, err := v.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]interface{}{})
+		// 已达到最长有效期的 token 续期后的有效期会缩短，此时重新登录
+		if err == nil && resp.Auth != nil && time.Duration(resp.Auth.LeaseDuration)*time.Second > DefaultExpiryWindow {
+			v.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+			return nil
+		}
+	}
+	return v.login(ctx)
+}
+
+// login 使用 ServiceAccount token 通过 Kubernetes 认证方式登录，调用方需持有 mu
+func (v *VaultProvider) login(ctx context.Context) error {
+	tokenFile := v.TokenFile
+	if tokenFile == "" {
+		tokenFile = DefaultServiceAccountTokenFile
+	}
+	jwt, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return fmt.Errorf("read service account token error: %w", err)
+	}
+	authPath := v.AuthPath
+	if authPath == "" {
+		authPath = DefaultVaultAuthPath
+	}
+
+	// 新 token 登录后，旧 token 下的租约不再续期
+	v.token, v.lease = "", nil
+	resp, err := v.do(ctx, http.MethodPost, "auth/"+strings.Trim(authPath, "/")+"/login", map[string]interface{}{
+		"role": v.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+	if err != nil {
+		return fmt.Errorf("vault kubernetes login with role %s error: %w", v.Role, err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("vault kubernetes login with role %s returned no token", v.Role)
+	}
+	v.setToken(resp.Auth.ClientToken, resp.Auth.LeaseDuration, resp.Auth.Renewable)
+	return nil
+}
+
+func (v *VaultProvider) setToken(token string, leaseDuration int64, renewable bool) {
+	v.token, v.tokenRenew = token, renewable
+	v.tokenExpires = time.Time{}
+	if leaseDuration > 0 {
+		v.tokenExpires = time.Now().Add(time.Duration(leaseDuration) * time.Second)
+	}
+}
+
+func (v *VaultProvider) tokenExpiresWithin(window time.Duration) bool {
+	return !v.tokenExpires.IsZero() && time.Now().Add(window).After(v.tokenExpires)
+}
+
+// boundByToken 返回 expires 与 token 过期时间中较早的一个
+func (v *VaultProvider) boundByToken(expires time.Time) time.Time {
+	if v.tokenExpires.IsZero() || (!expires.IsZero() && expires.Before(v.tokenExpires)) {
+		return expires
+	}
+	return v.tokenExpires
+}
+
+// do 调用 Vault 接口，path 不包含 /v1/ 前缀
+func (v *VaultProvider) do(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(v.Address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), reader)
+	if err != nil {
+		return nil, err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result vaultResponse
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("decode response (status %d) error: %w", resp.StatusCode, err)
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, strings.Join(result.Errors, "; "))
+	}
+	return &result, nil
+}
+
+// vaultValue 从 Vault 返回的 data 中读取凭证，KV v2 引擎的数据位于 data.data
+func vaultValue(raw json.RawMessage) (Value, error) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(raw, &data); err != nil || data == nil {
+		return Value{}, fmt.Errorf("no data in response")
+	}
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+
+	value := Value{
+		AccessKeyID:     vaultString(data, vaultAccessKeyIDKeys),
+		SecretAccessKey: vaultString(data, vaultSecretAccessKeyKeys),
+		SessionToken:    vaultString(data, vaultSessionTokenKeys),
+	}
+	if value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return Value{}, fmt.Errorf("no access key in data, expected one of %v and one of %v", vaultAccessKeyIDKeys, vaultSecretAccessKeyKeys)
+	}
+	return value, nil
+}
+
+func vaultString(data map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		if s, ok := data[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,266 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeVault 模拟 Vault 的 Kubernetes 认证、KV 引擎、AWS 引擎与续期接口
+type fakeVault struct {
+	*httptest.Server
+	jwt  string
+	role string
+
+	mu sync.Mutex
+	// tokens 已经签发的 token
+	tokens []string
+	// tokenTTL 签发与续期的 token 有效期（秒）
+	tokenTTL int64
+	// leaseTTL AWS 引擎租约的有效期（秒），为 0 时拒绝续期租约
+	leaseTTL   int64
+	logins     int
+	tokenRenew int
+	leaseRenew int
+	awsReads   int
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	f := &fakeVault{jwt: "service-account-jwt", role: "velero", tokenTTL: 3600, leaseTTL: 3600}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeVault) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+	reply := func(status int, v interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	auth := func(token string) map[string]interface{} {
+		return map[string]interface{}{"client_token": token, "lease_duration": f.tokenTTL, "renewable": true}
+	}
+
+	if r.URL.Path == "/v1/auth/kubernetes/login" {
+		if body["jwt"] != f.jwt || body["role"] != f.role {
+			reply(http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+			return
+		}
+		f.logins++
+		token := fmt.Sprintf("hvs.%d", f.logins)
+		f.tokens = append(f.tokens, token)
+		reply(http.StatusOK, map[string]interface{}{"auth": auth(token)})
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if !contains(f.tokens, token) {
+		reply(http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/auth/token/renew-self":
+		f.tokenRenew++
+		reply(http.StatusOK, map[string]interface{}{"auth": auth(token)})
+	case "/v1/sys/leases/renew":
+		if f.leaseTTL == 0 {
+			reply(http.StatusBadRequest, map[string]interface{}{"errors": []string{"lease not found"}})
+			return
+		}
+		f.leaseRenew++
+		reply(http.StatusOK, map[string]interface{}{"lease_id": body["lease_id"], "lease_duration": f.leaseTTL, "renewable": true})
+	case "/v1/secret/data/velero":
+		reply(http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{
+				"data":     map[string]string{"aws_access_key_id": "kv2-id", "aws_secret_access_key": "kv2-secret"},
+				"metadata": map[string]interface{}{"version": 3},
+			},
+		})
+	case "/v1/kv/velero":
+		reply(http.StatusOK, map[string]interface{}{
+			"lease_duration": 2764800,
+			"data":           map[string]string{"access_key": "kv1-id", "secret_key": "kv1-secret"},
+		})
+	case "/v1/kv/empty":
+		reply(http.StatusOK, map[string]interface{}{"data": map[string]string{"user": "velero"}})
+	case "/v1/aws/creds/velero":
+		f.awsReads++
+		reply(http.StatusOK, map[string]interface{}{
+			"lease_id":       fmt.Sprintf("aws/creds/velero/%d", f.awsReads),
+			"lease_duration": f.leaseTTL,
+			"renewable":      true,
+			"data": map[string]interface{}{
+				"access_key": fmt.Sprintf("AKIA-%d", f.awsReads), "secret_key": "aws-secret", "security_token": nil,
+			},
+		})
+	default:
+		reply(http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+// counts 返回登录、token 续期、租约续期与 AWS 引擎读取的次数
+func (f *fakeVault) counts() (logins, tokenRenew, leaseRenew, awsReads int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins, f.tokenRenew, f.leaseRenew, f.awsReads
+}
+
+func newTestVaultProvider(t *testing.T, server *fakeVault, path string) *VaultProvider {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte(server.jwt+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider := NewVaultProvider(server.URL, "velero", path)
+	provider.TokenFile = tokenFile
+	return provider
+}
+
+func TestVaultProviderRetrieve(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		role        string
+		wantID      string
+		wantExpires bool
+		wantErr     string
+	}{
+		{name: "kv v2", path: "secret/data/velero", wantID: "kv2-id"},
+		{name: "kv v1", path: "kv/velero", wantID: "kv1-id", wantExpires: true},
+		{name: "aws engine", path: "aws/creds/velero", wantID: "AKIA-1", wantExpires: true},
+		{name: "no access key", path: "kv/empty", wantErr: "no access key"},
+		{name: "missing path", path: "kv/missing", wantErr: "status 404"},
+		{name: "unknown role", path: "kv/velero", role: "other", wantErr: "permission denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeVault(t)
+			provider := newTestVaultProvider(t, server, tt.path)
+			if tt.role != "" {
+				provider.Role = tt.role
+			}
+
+			got, err := provider.Retrieve(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Retrieve() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if got.AccessKeyID != tt.wantID || got.SecretAccessKey == "" || got.SessionToken != "" {
+				t.Errorf("Retrieve() = %+v, want %s", got, tt.wantID)
+			}
+			if got.Expires.IsZero() == tt.wantExpires {
+				t.Errorf("Retrieve() expires = %s, want expiring %v", got.Expires, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func TestVaultProviderRenewsLease(t *testing.T) {
+	server := newFakeVault(t)
+	provider := newTestVaultProvider(t, server, "aws/creds/velero")
+
+	first, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	// 租约可以续期时继续使用同一组访问密钥
+	renewed, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if renewed.AccessKeyID != first.AccessKeyID {
+		t.Errorf("Retrieve() after renewal = %s, want %s", renewed.AccessKeyID, first.AccessKeyID)
+	}
+	if logins, _, leaseRenew, awsReads := server.counts(); logins != 1 || leaseRenew != 1 || awsReads != 1 {
+		t.Errorf("vault called login=%d lease renew=%d aws read=%d, want 1, 1 and 1", logins, leaseRenew, awsReads)
+	}
+
+	// 租约无法续期时生成新的访问密钥
+	server.mu.Lock()
+	server.leaseTTL = 0
+	server.mu.Unlock()
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if got.AccessKeyID != "AKIA-2" {
+		t.Errorf("Retrieve() after the lease expired = %s, want AKIA-2", got.AccessKeyID)
+	}
+}
+
+func TestVaultProviderRenewsToken(t *testing.T) {
+	server := newFakeVault(t)
+	provider := newTestVaultProvider(t, server, "secret/data/velero")
+	if _, err := provider.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+
+	// token 即将过期时续期
+	provider.tokenExpires = time.Now().Add(time.Minute)
+	if _, err := provider.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if logins, tokenRenew, _, _ := server.counts(); logins != 1 || tokenRenew != 1 {
+		t.Errorf("vault called login=%d token renew=%d, want 1 and 1", logins, tokenRenew)
+	}
+
+	// token 达到最长有效期后重新登录
+	server.mu.Lock()
+	server.tokenTTL = 60
+	server.mu.Unlock()
+	provider.tokenExpires = time.Now().Add(time.Minute)
+	if _, err := provider.Retrieve(context.Background()); err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if logins, tokenRenew, _, _ := server.counts(); logins != 2 || tokenRenew != 2 {
+		t.Errorf("vault called login=%d token renew=%d, want 2 and 2", logins, tokenRenew)
+	}
+}
+
+func TestRenewBeforeExpiry(t *testing.T) {
+	server := newFakeVault(t)
+	creds := NewCredentials(newTestVaultProvider(t, server, "aws/creds/velero"), logrus.New())
+	if _, err := creds.Get(context.Background()); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	generation := creds.Generation()
+
+	// 租约在过期窗口内，后台在没有请求的情况下续期
+	creds.ExpiryWindow = 2 * time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	RenewBeforeExpiry(ctx, creds, 10*time.Millisecond, logrus.New())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, _, leaseRenew, _ := server.counts(); leaseRenew >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("lease was not renewed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if creds.Generation() != generation {
+		t.Errorf("Generation() changed after renewing the lease, want the same access key")
+	}
+}
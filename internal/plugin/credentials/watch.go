@@ -0,0 +1,124 @@
+package credentials
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/noovertime7/velero-os-plugin/internal/ini"
+)
+
+// DefaultWatchInterval 检查凭证文件是否变化的默认间隔。
+// Kubernetes 通过替换符号链接更新挂载的 Secret，inotify 难以可靠地监听，因此定期检查文件内容
+const DefaultWatchInterval = 30 * time.Second
+
+// profileKeys 读取凭证时使用的 profile 配置，用于判断哪些 profile 发生了变化
+var profileKeys = append([]string{
+	accessKeyIDKey, secretAccessKeyKey, sessionTokenKey, securityTokenKey,
+	roleARNKey, sourceProfileKey, roleSessionNameKey, durationSecondsKey, externalIDKey, stsEndpointKey, regionKey,
+	webIdentityTokenFileKey, oidcProviderARNKey, credentialProcessKey,
+}, expirationKeys...)
+
+// fileState 凭证文件上一次被读取时的内容摘要
+type fileState struct {
+	hash     [sha256.Size]byte
+	profiles map[string]string
+}
+
+// WatchFiles 记录 paths 中凭证文件的当前内容后返回，并在后台每隔 interval 检查一次，
+// 内容变化时重新获取凭证并替换 creds 中缓存的凭证，直到 ctx 结束。
+// 文件无法解析或重新获取凭证失败时继续使用原有凭证，并在下一次检查时重试
+func WatchFiles(ctx context.Context, creds *Credentials, interval time.Duration, log logrus.FieldLogger, paths ...string) {
+	states := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		states[path], _ = readFileState(path)
+	}
+	go watchFiles(ctx, creds, interval, log, paths, states)
+}
+
+func watchFiles(ctx context.Context, creds *Credentials, interval time.Duration, log logrus.FieldLogger,
+	paths []string, states map[string]fileState) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var changed []string
+		for _, path := range paths {
+			state, err := readFileState(path)
+			if err != nil {
+				log.Warnf("read credentials file %s error, keep using current credentials: %v", path, err)
+				continue
+			}
+			old := states[path]
+			if state.hash == old.hash {
+				continue
+			}
+			log.Infof("credentials file %s changed, profiles changed: %v", path, changedProfiles(old.profiles, state.profiles))
+			changed = append(changed, path)
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		if err := creds.Refresh(ctx); err != nil {
+			log.Errorf("reload credentials after %v changed error, keep using current credentials: %v", changed, err)
+			continue
+		}
+		for _, path := range changed {
+			states[path], _ = readFileState(path)
+		}
+	}
+}
+
+// readFileState 读取并解析凭证文件，文件不存在时返回空状态
+func readFileState(path string) (fileState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fileState{}, nil
+	}
+	if err != nil {
+		return fileState{}, err
+	}
+	sections, err := ini.ParseBytes(data)
+	if err != nil {
+		return fileState{}, err
+	}
+
+	state := fileState{hash: sha256.Sum256(data), profiles: make(map[string]string)}
+	for _, name := range sections.List() {
+		section, _ := sections.GetSection(name)
+		var b strings.Builder
+		for _, key := range profileKeys {
+			b.WriteString(key + "=" + section.String(key) + "\n")
+		}
+		state.profiles[name] = b.String()
+	}
+	return state, nil
+}
+
+// changedProfiles 返回新增、删除或修改过的 profile
+func changedProfiles(old, new map[string]string) []string {
+	var changed []string
+	for name, content := range new {
+		if oldContent, ok := old[name]; !ok || oldContent != content {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,75 @@
+package credentials
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// waitForKey 等待 creds 返回 accessKeyID 对应的凭证
+func waitForKey(t *testing.T, creds *Credentials, accessKeyID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v, err := creds.Get(context.Background())
+		if err == nil && v.AccessKeyID == accessKeyID {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get() = %+v, %v, want %s", v, err, accessKeyID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchFilesReloadsChangedFile(t *testing.T) {
+	path := writeCredentialsFile(t, "[default]\naws_access_key_id = id-1\naws_secret_access_key = secret-1\n")
+	creds := NewCredentials(NewFileProvider(path, "", true), logrus.New())
+	waitForKey(t, creds, "id-1")
+	generation := creds.Generation()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	WatchFiles(ctx, creds, 10*time.Millisecond, logrus.New(), path)
+
+	if err := os.WriteFile(path, []byte("[default]\naws_access_key_id = id-2\naws_secret_access_key = secret-2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitForKey(t, creds, "id-2")
+	if creds.Generation() == generation {
+		t.Errorf("Generation() did not change after the credentials file changed")
+	}
+
+	// 文件内容不完整或无法解析时继续使用原有凭证
+	for _, content := range []string{
+		"[default]\naws_access_key_id = id-3\n",
+		"[default\naws_access_key_id = id-3\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if v, err := creds.Get(context.Background()); err != nil || v.AccessKeyID != "id-2" {
+			t.Errorf("Get() after writing %q = %+v, %v, want id-2", content, v, err)
+		}
+	}
+
+	// 文件修复后重新加载
+	if err := os.WriteFile(path, []byte("[default]\naws_access_key_id = id-3\naws_secret_access_key = secret-3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitForKey(t, creds, "id-3")
+}
+
+func TestChangedProfiles(t *testing.T) {
+	old := map[string]string{"default": "a", "backup": "b", "removed": "c"}
+	new := map[string]string{"default": "a", "backup": "changed", "added": "d"}
+	want := []string{"added", "backup", "removed"}
+	if got := changedProfiles(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("changedProfiles() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,161 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscreds "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// WebIdentityEnv 一组描述 Web Identity 配置的环境变量名
+type WebIdentityEnv struct {
+	TokenFile   string
+	RoleARN     string
+	SessionName string
+	// OIDCProviderARN 阿里云 RRSA 的 OIDC 身份提供商 ARN，AWS 为空
+	OIDCProviderARN string
+}
+
+// DefaultWebIdentityEnvs 默认按顺序检查的环境变量，分别由 EKS 的 IRSA 与 ACK 的 RRSA 注入 Pod
+var DefaultWebIdentityEnvs = []WebIdentityEnv{
+	{TokenFile: "AWS_WEB_IDENTITY_TOKEN_FILE", RoleARN: "AWS_ROLE_ARN", SessionName: "AWS_ROLE_SESSION_NAME"},
+	{
+		TokenFile:       "ALIBABA_CLOUD_OIDC_TOKEN_FILE",
+		RoleARN:         "ALIBABA_CLOUD_ROLE_ARN",
+		SessionName:     "ALIBABA_CLOUD_ROLE_SESSION_NAME",
+		OIDCProviderARN: "ALIBABA_CLOUD_OIDC_PROVIDER_ARN",
+	},
+}
+
+// WebIdentityProvider 使用 Kubernetes 投射的 ServiceAccount token 换取临时凭证：
+// AWS 与 MinIO 调用 AssumeRoleWithWebIdentity，阿里云 RRSA 调用 AssumeRoleWithOIDC。
+// token 文件会被 kubelet 定期轮换，因此每次获取凭证时都重新读取
+type WebIdentityProvider struct {
+	// Envs 按顺序检查的环境变量，为空时使用 DefaultWebIdentityEnvs
+	Envs []WebIdentityEnv
+	// STS 调用 STS 服务的默认配置
+	STS STSOptions
+	// lookup 读取环境变量，测试时替换
+	lookup func(string) (string, bool)
+}
+
+// NewWebIdentityProvider 创建一个从环境变量读取配置的 WebIdentityProvider
+func NewWebIdentityProvider(stsOptions STSOptions) *WebIdentityProvider {
+	return &WebIdentityProvider{Envs: DefaultWebIdentityEnvs, STS: stsOptions, lookup: os.LookupEnv}
+}
+
+// Name 实现 Provider 接口
+func (w *WebIdentityProvider) Name() string {
+	return "web identity"
+}
+
+// Retrieve 使用第一组设置了 token 文件的环境变量换取临时凭证，没有设置时返回 ErrNoCredentials
+func (w *WebIdentityProvider) Retrieve(ctx context.Context) (Value, error) {
+	for _, env := range w.Envs {
+		tokenFile, _ := w.lookup(env.TokenFile)
+		if tokenFile == "" {
+			continue
+		}
+		input := AssumeRoleInput{
+			SessionName: DefaultRoleSessionName,
+			Duration:    DefaultRoleDuration,
+			Endpoint:    w.STS.Endpoint,
+			Region:      w.STS.Region,
+		}
+		if input.RoleARN, _ = w.lookup(env.RoleARN); input.RoleARN == "" {
+			return Value{}, fmt.Errorf("environment %s is set but %s is empty", env.TokenFile, env.RoleARN)
+		}
+		if name, _ := w.lookup(env.SessionName); name != "" {
+			input.SessionName = name
+		}
+		if env.OIDCProviderARN != "" {
+			if input.OIDCProviderARN, _ = w.lookup(env.OIDCProviderARN); input.OIDCProviderARN == "" {
+				return Value{}, fmt.Errorf("environment %s is set but %s is empty", env.TokenFile, env.OIDCProviderARN)
+			}
+		}
+		return assumeRoleWithWebIdentity(ctx, tokenFile, input, w.STS.HTTPClient)
+	}
+	return Value{}, fmt.Errorf("%w: no web identity token file in environment", ErrNoCredentials)
+}
+
+// assumeRoleWithWebIdentity 读取 tokenFile 中的 token 并换取 input.RoleARN 的临时凭证
+func assumeRoleWithWebIdentity(ctx context.Context, tokenFile string, input AssumeRoleInput, client *http.Client) (Value, error) {
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return Value{}, fmt.Errorf("read web identity token file error: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return Value{}, fmt.Errorf("web identity token file %s is empty", tokenFile)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var value Value
+	if strings.HasPrefix(input.RoleARN, ramRolePrefix) {
+		value, err = assumeRAMRoleWithOIDC(ctx, token, input, client)
+	} else {
+		value, err = assumeAWSRoleWithWebIdentity(ctx, token, input, client)
+	}
+	if err != nil {
+		return Value{}, err
+	}
+	value.Source = fmt.Sprintf("web identity role %s with token %s", input.RoleARN, tokenFile)
+	return value, nil
+}
+
+// assumeAWSRoleWithWebIdentity 调用 AWS STS AssumeRoleWithWebIdentity 接口，该接口不需要签名
+func assumeAWSRoleWithWebIdentity(ctx context.Context, token string, input AssumeRoleInput, httpClient *http.Client) (Value, error) {
+	client, err := newAWSSTSClient(input.Endpoint, input.Region, awscreds.AnonymousCredentials, httpClient)
+	if err != nil {
+		return Value{}, err
+	}
+	output, err := client.AssumeRoleWithWebIdentityWithContext(ctx, &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(input.RoleARN),
+		RoleSessionName:  aws.String(input.SessionName),
+		WebIdentityToken: aws.String(token),
+		DurationSeconds:  aws.Int64(int64(input.Duration.Seconds())),
+	})
+	if err != nil {
+		return Value{}, fmt.Errorf("assume role %s with web identity error: %w", input.RoleARN, err)
+	}
+	return awsSTSValue(input.RoleARN, output.Credentials)
+}
+
+// assumeRAMRoleWithOIDC 调用阿里云 STS AssumeRoleWithOIDC 接口，该接口不需要签名
+func assumeRAMRoleWithOIDC(ctx context.Context, token string, input AssumeRoleInput, client *http.Client) (Value, error) {
+	if input.OIDCProviderARN == "" {
+		return Value{}, fmt.Errorf("oidc provider arn is required to assume ram role %s", input.RoleARN)
+	}
+	endpoint := input.Endpoint
+	if endpoint == "" {
+		endpoint = defaultRAMSTSEndpoint
+	}
+
+	form := url.Values{
+		"Action":          {"AssumeRoleWithOIDC"},
+		"Format":          {"JSON"},
+		"Version":         {"2015-04-01"},
+		"Timestamp":       {time.Now().UTC().Format("2006-01-02T15:04:05Z")},
+		"RoleArn":         {input.RoleARN},
+		"OIDCProviderArn": {input.OIDCProviderARN},
+		"OIDCToken":       {token},
+		"RoleSessionName": {input.SessionName},
+		"DurationSeconds": {strconv.Itoa(int(input.Duration.Seconds()))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, stsEndpointURL(endpoint)+"/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return Value{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doRAMSTSRequest(req, input.RoleARN, client)
+}
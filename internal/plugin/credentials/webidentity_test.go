@@ -0,0 +1,164 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeToken(t *testing.T, path, token string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebIdentityProviderRetrieve(t *testing.T) {
+	server := newFakeSTS(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		wantID     string
+		wantParams map[string]string
+		wantErr    error
+	}{
+		{
+			name: "aws irsa",
+			env: map[string]string{
+				"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile,
+				"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/velero",
+				"AWS_ROLE_SESSION_NAME":       "irsa",
+			},
+			wantID: "ASIA-1-",
+			wantParams: map[string]string{
+				"Action":           "AssumeRoleWithWebIdentity",
+				"RoleArn":          "arn:aws:iam::123456789012:role/velero",
+				"RoleSessionName":  "irsa",
+				"WebIdentityToken": "token-1",
+			},
+		},
+		{
+			name: "alibaba rrsa",
+			env: map[string]string{
+				"ALIBABA_CLOUD_OIDC_TOKEN_FILE":   tokenFile,
+				"ALIBABA_CLOUD_ROLE_ARN":          "acs:ram::1234567890:role/velero",
+				"ALIBABA_CLOUD_OIDC_PROVIDER_ARN": "acs:ram::1234567890:oidc-provider/ack-rrsa",
+			},
+			wantID: "STS.ram-1",
+			wantParams: map[string]string{
+				"Action":          "AssumeRoleWithOIDC",
+				"RoleArn":         "acs:ram::1234567890:role/velero",
+				"OIDCProviderArn": "acs:ram::1234567890:oidc-provider/ack-rrsa",
+				"OIDCToken":       "token-1",
+				"RoleSessionName": DefaultRoleSessionName,
+			},
+		},
+		{name: "not configured", env: map[string]string{}, wantErr: ErrNoCredentials},
+		{
+			name:    "missing role arn",
+			env:     map[string]string{"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile},
+			wantErr: errors.New("configuration error"),
+		},
+		{
+			name: "missing oidc provider arn",
+			env: map[string]string{
+				"ALIBABA_CLOUD_OIDC_TOKEN_FILE": tokenFile,
+				"ALIBABA_CLOUD_ROLE_ARN":        "acs:ram::1234567890:role/velero",
+			},
+			wantErr: errors.New("configuration error"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.reset()
+			writeToken(t, tokenFile, "token-1")
+			provider := NewWebIdentityProvider(STSOptions{Endpoint: server.URL})
+			provider.lookup = fakeEnv(tt.env)
+
+			got, err := provider.Retrieve(context.Background())
+			switch {
+			case tt.wantErr == ErrNoCredentials:
+				if !errors.Is(err, ErrNoCredentials) {
+					t.Errorf("Retrieve() error = %v, want %v", err, ErrNoCredentials)
+				}
+				return
+			case tt.wantErr != nil:
+				if err == nil || errors.Is(err, ErrNoCredentials) {
+					t.Errorf("Retrieve() error = %v, want configuration error", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+
+			if got.AccessKeyID != tt.wantID || got.SessionToken == "" || got.Expires.IsZero() {
+				t.Errorf("Retrieve() = %+v, want temporary credentials %s", got, tt.wantID)
+			}
+			calls := server.calls()
+			if len(calls) != 1 {
+				t.Fatalf("STS called %d times, want 1", len(calls))
+			}
+			for k, want := range tt.wantParams {
+				if v := calls[0].Get(k); v != want {
+					t.Errorf("STS request %s = %q, want %q", k, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWebIdentityProviderReadsRotatedToken(t *testing.T) {
+	server := newFakeSTS(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	provider := NewWebIdentityProvider(STSOptions{Endpoint: server.URL})
+	provider.lookup = fakeEnv(map[string]string{
+		"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile,
+		"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/velero",
+	})
+
+	for i := 1; i <= 2; i++ {
+		// kubelet 轮换 token 后，下一次获取凭证使用新的 token
+		writeToken(t, tokenFile, fmt.Sprintf("token-%d", i))
+		if _, err := provider.Retrieve(context.Background()); err != nil {
+			t.Fatalf("Retrieve() error = %v", err)
+		}
+		calls := server.calls()
+		if got, want := calls[len(calls)-1].Get("WebIdentityToken"), fmt.Sprintf("token-%d", i); got != want {
+			t.Errorf("STS request WebIdentityToken = %q, want %q", got, want)
+		}
+	}
+
+	os.Remove(tokenFile)
+	if _, err := provider.Retrieve(context.Background()); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Retrieve() without token file error = %v, want configuration error", err)
+	}
+}
+
+func TestFileProviderWebIdentity(t *testing.T) {
+	server := newFakeSTS(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeToken(t, tokenFile, "profile-token")
+	path := writeCredentialsFile(t, fmt.Sprintf(`[default]
+role_arn = acs:ram::1234567890:role/velero
+web_identity_token_file = %s
+oidc_provider_arn = acs:ram::1234567890:oidc-provider/ack-rrsa
+`, tokenFile))
+
+	provider := NewFileProvider(path, "", true)
+	provider.STS = STSOptions{Endpoint: server.URL}
+	got, err := provider.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if got.AccessKeyID != "STS.ram-1" {
+		t.Errorf("Retrieve() access key = %s, want STS.ram-1", got.AccessKeyID)
+	}
+	if token := server.calls()[0].Get("OIDCToken"); token != "profile-token" {
+		t.Errorf("STS request OIDCToken = %q, want profile-token", token)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2017, 2019 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/uploader"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/sirupsen/logrus"
+)
+
+// metricsJob is the Pushgateway job the plugin metrics are pushed under.
+const metricsJob = "velero-os-plugin"
+
+// metricsExporter exposes the metrics of every ObjectStore in the plugin process. Velero may call Init
+// several times and run one ObjectStore per backup storage location, so the registry, the HTTP listener
+// and the Pushgateway pusher are shared by the whole process.
+type metricsExporter struct {
+	registry *prometheus.Registry
+	metrics  *uploader.Metrics
+
+	mu sync.Mutex
+	// address is the metricsAddress being served, listener serves /metrics on it
+	address  string
+	listener net.Listener
+	// pushURL is the Pushgateway the metrics are pushed to, stopPush stops pushing to it
+	pushURL  string
+	stopPush context.CancelFunc
+}
+
+var exporter = newMetricsExporter()
+
+func newMetricsExporter() *metricsExporter {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return &metricsExporter{registry: registry, metrics: uploader.NewMetrics(registry)}
+}
+
+// serve starts serving /metrics on address unless it is served already. The plugin runs as a
+// subprocess of Velero and several of them may be alive at once, so a busy address is logged
+// instead of failing Init; only the process that binds it first serves the metrics.
+func (e *metricsExporter) serve(address string, log logrus.FieldLogger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if address == "" || address == e.address {
+		return
+	}
+	if e.listener != nil {
+		log.Warnf("metrics are already served on %s, ignore %s %s", e.address, metricsAddressKey, address)
+		return
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Warnf("serve metrics on %s error: %v", address, err)
+		return
+	}
+	e.address, e.listener = address, listener
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Warnf("serve metrics on %s error: %v", address, err)
+		}
+	}()
+	log.Infof("serving metrics on %s", listener.Addr())
+}
+
+// push pushes the metrics to the Pushgateway at url after every operation, since the plugin process
+// may be stopped by Velero at any time. Metrics are grouped by host name, so the Pushgateway keeps
+// those of the latest plugin process on each Velero pod. A new url replaces the previous one.
+func (e *metricsExporter) push(url string, log logrus.FieldLogger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if url == "" || url == e.pushURL {
+		return
+	}
+	if e.stopPush != nil {
+		e.stopPush()
+	}
+	e.pushURL = url
+
+	instance, _ := os.Hostname()
+	pusher := push.New(url, metricsJob).Gatherer(e.registry).Grouping("instance", instance)
+	ctx, cancel := context.WithCancel(context.Background())
+	e.stopPush = cancel
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-e.metrics.Updated():
+			}
+			if err := pusher.PushContext(ctx); err != nil && ctx.Err() == nil {
+				log.Warnf("push metrics to %s error: %v", url, err)
+			}
+		}
+	}()
+	log.Infof("pushing metrics to %s", url)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2017, 2019 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	store := NewObjectStore(logrus.New())
+	config := map[string]string{s3TypeKey: "filesystem", rootDirKey: t.TempDir(), metricsAddressKey: "127.0.0.1:0"}
+	if err := store.Init(config); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	listener := exporter.listener
+	if listener == nil {
+		t.Fatal("Init() did not serve metrics")
+	}
+	// the metrics are served once per process
+	if err := store.Init(config); err != nil || exporter.listener != listener {
+		t.Fatalf("Init() again = %v, want the metrics served by the same listener", err)
+	}
+	// the metrics are shared by the whole process, use a bucket of its own
+	bucket := fmt.Sprintf("metrics-%d", time.Now().UnixNano())
+	if err := store.PutObject(bucket, "backups/b1/b1.tar.gz", strings.NewReader("backup")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`velero_os_plugin_operations_total{backend="filesystem",bucket="` + bucket + `",operation="PutObject",result="success"} 1`,
+		`velero_os_plugin_sent_bytes_total{backend="filesystem",bucket="` + bucket + `"} 6`,
+		`velero_os_plugin_operation_duration_seconds_count{backend="filesystem",bucket="` + bucket + `",operation="PutObject",result="success"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("GET /metrics does not contain %q", want)
+		}
+	}
+}
+
+func TestMetricsPushgateway(t *testing.T) {
+	pushed := make(chan string, 10)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		pushed <- r.Method + " " + r.URL.Path + " " + string(body)
+	}))
+	defer gateway.Close()
+
+	store := NewObjectStore(logrus.New())
+	if err := store.Init(map[string]string{s3TypeKey: "filesystem", rootDirKey: t.TempDir(), metricsPushgatewayKey: gateway.URL}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer func() {
+		exporter.mu.Lock()
+		exporter.stopPush()
+		exporter.pushURL, exporter.stopPush = "", nil
+		exporter.mu.Unlock()
+	}()
+	bucket := fmt.Sprintf("metrics-%d", time.Now().UnixNano())
+	if err := store.DeleteObject(bucket, "backups/b1/b1.tar.gz"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+
+	// earlier operations of the process may be pushed first
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-pushed:
+			if !strings.HasPrefix(got, "PUT /metrics/job/velero-os-plugin/instance/") {
+				t.Fatalf("push request = %.60q, want PUT to the velero-os-plugin job", got)
+			}
+			// the protobuf encoded body carries the label values as is
+			if strings.Contains(got, bucket) {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the DeleteObject operation was not pushed")
+		}
+	}
+}
@@ -17,18 +17,26 @@ limitations under the License.
 package plugin
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/noovertime7/velero-os-plugin/internal/ini"
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/audit"
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
 	"github.com/noovertime7/velero-os-plugin/internal/plugin/uploader"
 	"github.com/pkg/errors"
 	veleroplugin "github.com/vmware-tanzu/velero/pkg/plugin/framework"
 	"io"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -40,11 +48,78 @@ const (
 	bucketKey                = "bucket"
 	credentialsFileKey       = "credentialsFile"
 	credentialProfileKey     = "profile"
+	partSizeKey              = "partSize"
+	uploadConcurrencyKey     = "uploadConcurrency"
+	uploadTimeoutKey         = "uploadTimeout"
+	getTimeoutKey            = "getTimeout"
+	listTimeoutKey           = "listTimeout"
+	deleteTimeoutKey         = "deleteTimeout"
+	presignTimeoutKey        = "presignTimeout"
+	multipartThresholdKey    = "multipartThreshold"
+	checkpointDirKey         = "checkpointDir"
+	checkpointMaxAgeKey      = "checkpointMaxAge"
+	appIDKey                 = "appId"
+	rootDirKey               = "rootDir"
+	fileServerAddrKey        = "fileServerAddr"
+	fileServerURLKey         = "fileServerUrl"
+	listPageSizeKey          = "listPageSize"
+	instanceMetadataKey      = "instanceMetadata"
+	metadataRoleKey          = "metadataRole"
+	metadataEndpointKey      = "metadataEndpoint"
+	credentialsReloadKey     = "credentialsReloadInterval"
+	vaultAddressKey          = "vaultAddress"
+	vaultRoleKey             = "vaultRole"
+	vaultPathKey             = "vaultPath"
+	vaultAuthPathKey         = "vaultAuthPath"
+	vaultNamespaceKey        = "vaultNamespace"
+	vaultCACertKey           = "vaultCACert"
+	serverSideEncryptionKey  = "serverSideEncryption"
+	kmsKeyIDKey              = "kmsKeyId"
+	customerKeyFileKey       = "customerKeyEncryptionFile"
+	encryptionKeyFileKey     = "encryptionKeyFile"
+	encryptionKMSKeyIDKey    = "encryptionKmsKeyId"
+	encryptionKMSEndpointKey = "encryptionKmsEndpoint"
+	compressionKey           = "compression"
+	metricsAddressKey        = "metricsAddress"
+	metricsPushgatewayKey    = "metricsPushgateway"
+	tracingEndpointKey       = "tracingEndpoint"
+	auditLogKey              = "auditLog"
+	auditSpoolFileKey        = "auditSpoolFile"
 )
 
+// operationTimeouts holds the deadline of each kind of object store call, zero means no deadline.
+type operationTimeouts struct {
+	put     time.Duration
+	get     time.Duration
+	list    time.Duration
+	delete  time.Duration
+	presign time.Duration
+}
+
+// defaultOperationTimeouts are long enough for large backups over slow links,
+// but keep a hung endpoint from blocking the Velero controllers forever.
+var defaultOperationTimeouts = operationTimeouts{
+	put:     4 * time.Hour,
+	get:     time.Hour,
+	list:    5 * time.Minute,
+	delete:  time.Minute,
+	presign: 30 * time.Second,
+}
+
 type ObjectStore struct {
 	log      logrus.FieldLogger
 	uploader uploader.Uploader
+	// backend is the s3Type of the uploader, recorded on the spans
+	backend  string
+	timeouts operationTimeouts
+	// audit records the mutating calls, nil when auditLog is unset
+	audit *audit.Logger
+	// creds identify the principal of the audit records, unset for the filesystem backend
+	creds *credentials.Credentials
+	// stopWatch stops watching the credentials files of the previous Init
+	stopWatch context.CancelFunc
+	// stopRenew stops renewing the Vault leases of the previous Init
+	stopRenew context.CancelFunc
 }
 
 func NewObjectStore(logger logrus.FieldLogger) *ObjectStore {
@@ -63,9 +138,51 @@ func (f *ObjectStore) Init(config map[string]string) error {
 		credentialsFileKey,
 		credentialProfileKey,
 		insecureSkipTLSVerifyKey,
+		partSizeKey,
+		uploadConcurrencyKey,
+		uploadTimeoutKey,
+		getTimeoutKey,
+		listTimeoutKey,
+		deleteTimeoutKey,
+		presignTimeoutKey,
+		multipartThresholdKey,
+		checkpointDirKey,
+		checkpointMaxAgeKey,
+		appIDKey,
+		rootDirKey,
+		fileServerAddrKey,
+		fileServerURLKey,
+		listPageSizeKey,
+		instanceMetadataKey,
+		metadataRoleKey,
+		metadataEndpointKey,
+		credentialsReloadKey,
+		vaultAddressKey,
+		vaultRoleKey,
+		vaultPathKey,
+		vaultAuthPathKey,
+		vaultNamespaceKey,
+		vaultCACertKey,
+		serverSideEncryptionKey,
+		kmsKeyIDKey,
+		customerKeyFileKey,
+		encryptionKeyFileKey,
+		encryptionKMSKeyIDKey,
+		encryptionKMSEndpointKey,
+		compressionKey,
+		metricsAddressKey,
+		metricsPushgatewayKey,
+		tracingEndpointKey,
+		auditLogKey,
+		auditSpoolFileKey,
 	); err != nil {
 		return err
 	}
+	exporter.serve(config[metricsAddressKey], f.log)
+	exporter.push(config[metricsPushgatewayKey], f.log)
+	if err := enableTracing(config[tracingEndpointKey], f.log); err != nil {
+		return err
+	}
 
 	var (
 		region                   = config[regionKey]
@@ -76,11 +193,20 @@ func (f *ObjectStore) Init(config map[string]string) error {
 		credentialProfile        = config[credentialProfileKey]
 		credentialsFile          = config[credentialsFileKey]
 		bucket                   = config[bucketKey]
+		appID                    = config[appIDKey]
+		listPageSizeVal          = config[listPageSizeKey]
+		listPageSize             int
 		s3ForcePathStyle         bool
 		insecureSkipTLSVerify    bool
 		err                      error
 	)
 	f.log.Info("bucket", bucket)
+	f.backend = s3Type
+
+	f.creds = nil
+	if err := f.enableAudit(config); err != nil {
+		return err
+	}
 
 	if insecureSkipTLSVerifyVal != "" {
 		if insecureSkipTLSVerify, err = strconv.ParseBool(insecureSkipTLSVerifyVal); err != nil {
@@ -94,83 +220,456 @@ func (f *ObjectStore) Init(config map[string]string) error {
 		}
 	}
 
-	access, secret, err := f.getAccessAndSecret(credentialsFile, credentialProfile)
+	if listPageSizeVal != "" {
+		if listPageSize, err = strconv.Atoi(listPageSizeVal); err != nil || listPageSize <= 0 {
+			return errors.Errorf("could not parse %s (expected positive int): %q", listPageSizeKey, listPageSizeVal)
+		}
+	}
+
+	if f.timeouts, err = parseOperationTimeouts(config); err != nil {
+		return err
+	}
+
+	sse, err := parseServerSideEncryption(config)
 	if err != nil {
 		return err
 	}
+	if sse.Mode != "" && s3Type != "minio" && s3Type != "oss" && s3Type != "aws" {
+		return errors.Errorf("%s is not supported by s3Type %s", serverSideEncryptionKey, s3Type)
+	}
+
+	// the filesystem backend stores objects on a mounted volume and needs no credentials
+	if s3Type == "filesystem" {
+		f.uploader, err = uploader.NewFilesystemUploader(config[rootDirKey], config[fileServerAddrKey], config[fileServerURLKey], f.log)
+		if err != nil {
+			return fmt.Errorf("init filesystem uploader error: %w", err)
+		}
+		f.uploader = uploader.NewInstrumentedUploader(f.uploader, s3Type, exporter.metrics)
+		if err := f.enableEncryption(config, nil); err != nil {
+			return err
+		}
+		if err := f.enableCompression(config); err != nil {
+			return err
+		}
+		f.log.Debugf("build os-plugin uploader success,uploader type: [%s]", s3Type)
+		return nil
+	}
+
+	multipart, err := parseMultipartOptions(config)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := metadataProvider(config)
+	if err != nil {
+		return err
+	}
+	vault, err := vaultProvider(config)
+	if err != nil {
+		return err
+	}
+	chain := f.credentialChain(credentialsFile, credentialProfile, stsOptions(s3Type, s3URL, region, insecureSkipTLSVerify), vault, metadata)
+
+	// the SDKs fetch credentials through creds before every request so that temporary
+	// credentials are refreshed before they expire; resolve them once here to fail fast
+	creds := credentials.NewCredentials(chain, f.log)
+	if _, err := creds.Get(context.Background()); err != nil {
+		return fmt.Errorf("resolve credentials error: %w", err)
+	}
+	if err := f.watchCredentials(creds, config[credentialsReloadKey], credentialsFile); err != nil {
+		return err
+	}
+	f.renewCredentials(creds, vault != nil)
+	f.creds = creds
 
 	switch s3Type {
 	case "minio":
-		f.uploader, err = uploader.NewMinioUploader(s3URL, access, secret, insecureSkipTLSVerify, region, f.log)
+		f.uploader, err = uploader.NewMinioUploader(s3URL, creds, insecureSkipTLSVerify, region, multipart, sse, f.log)
 		if err != nil {
 			return fmt.Errorf("init minio uploader error: %w", err)
 		}
 	case "oss":
-		f.uploader, err = uploader.NewOSSUploader(s3URL, access, secret, region, s3ForcePathStyle, f.log)
+		f.uploader, err = uploader.NewOSSUploader(s3URL, creds, region, s3ForcePathStyle, multipart, sse, listPageSize, f.log)
 		if err != nil {
 			return fmt.Errorf("init oss uploader error: %w", err)
 		}
+	case "aws":
+		f.uploader, err = uploader.NewAWSUploader(s3URL, creds, region, s3ForcePathStyle, insecureSkipTLSVerify, multipart, sse, f.log)
+		if err != nil {
+			return fmt.Errorf("init aws uploader error: %w", err)
+		}
+	case "cos":
+		f.uploader, err = uploader.NewCOSUploader(s3URL, creds, region, appID, multipart, f.log)
+		if err != nil {
+			return fmt.Errorf("init cos uploader error: %w", err)
+		}
+	case "obs":
+		f.uploader, err = uploader.NewOBSUploader(s3URL, creds, region, s3ForcePathStyle, insecureSkipTLSVerify, multipart, f.log)
+		if err != nil {
+			return fmt.Errorf("init obs uploader error: %w", err)
+		}
 	default:
 		return fmt.Errorf("unsurport s3 Type")
 	}
+	// instrument the backend itself, so that the metrics count the bytes actually transferred
+	f.uploader = uploader.NewInstrumentedUploader(f.uploader, s3Type, exporter.metrics)
+	if err := f.enableEncryption(config, creds); err != nil {
+		return err
+	}
+	if err := f.enableCompression(config); err != nil {
+		return err
+	}
 
 	f.log.Debugf("build os-plugin uploader success,uploader type: [%s]", s3Type)
 
 	return nil
 }
 
-func (f *ObjectStore) getAccessAndSecret(credentialsFile, profile string) (string, string, error) {
-	if len(profile) == 0 {
-		profile = DefaultSharedConfigProfile
+// parseMultipartOptions reads the multipart upload settings from the BSL config.
+// Unset keys are left zero so the uploader falls back to its defaults.
+func parseMultipartOptions(config map[string]string) (uploader.MultipartOptions, error) {
+	var opts uploader.MultipartOptions
+
+	if val := config[partSizeKey]; val != "" {
+		partSize, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return opts, errors.Wrapf(err, "could not parse %s (expected bytes)", partSizeKey)
+		}
+		if partSize < uploader.MinPartSize {
+			return opts, errors.Errorf("%s must be at least %d bytes", partSizeKey, uploader.MinPartSize)
+		}
+		opts.PartSize = partSize
 	}
 
-	if credentialsFile != "" {
-		if _, err := os.Stat(credentialsFile); err != nil {
-			if os.IsNotExist(err) {
-				return "", "", errors.Wrapf(err, "provided credentialsFile does not exist")
-			}
-			return "", "", errors.Wrapf(err, "could not get credentialsFile info")
+	if val := config[uploadConcurrencyKey]; val != "" {
+		concurrency, err := strconv.ParseUint(val, 10, 32)
+		if err != nil {
+			return opts, errors.Wrapf(err, "could not parse %s (expected positive int)", uploadConcurrencyKey)
 		}
+		if concurrency == 0 {
+			return opts, errors.Errorf("%s must be positive", uploadConcurrencyKey)
+		}
+		opts.Concurrency = uint(concurrency)
+	}
 
-		//	从给出的配置文件路径中读取用户名密码
-		f.log.Infof("read secret from credentialsFile: [%s] profile: [%s]", credentialsFile, profile)
-		access, secret, err := f.readCredentialsFile(credentialsFile, profile)
+	if val := config[multipartThresholdKey]; val != "" {
+		threshold, err := strconv.ParseUint(val, 10, 64)
 		if err != nil {
-			return "", "", err
+			return opts, errors.Wrapf(err, "could not parse %s (expected bytes)", multipartThresholdKey)
+		}
+		opts.Threshold = threshold
+	}
+
+	opts.CheckpointDir = config[checkpointDirKey]
+
+	if val := config[checkpointMaxAgeKey]; val != "" {
+		maxAge, err := time.ParseDuration(val)
+		if err != nil {
+			return opts, errors.Wrapf(err, "could not parse %s (expected duration)", checkpointMaxAgeKey)
+		}
+		if maxAge <= 0 {
+			return opts, errors.Errorf("%s must be positive", checkpointMaxAgeKey)
+		}
+		opts.CheckpointMaxAge = maxAge
+	}
+
+	return opts, nil
+}
+
+// parseServerSideEncryption reads the server-side encryption settings from the BSL config.
+// serverSideEncryption selects AES256 (SSE-S3/SSE-OSS) or aws:kms (KMS on OSS); setting kmsKeyId alone implies KMS,
+// and customerKeyEncryptionFile enables SSE-C with the 32-byte key in the file, raw or base64 encoded.
+func parseServerSideEncryption(config map[string]string) (uploader.ServerSideEncryption, error) {
+	sse := uploader.ServerSideEncryption{KMSKeyID: config[kmsKeyIDKey]}
+	switch mode := config[serverSideEncryptionKey]; strings.ToLower(mode) {
+	case "":
+		if sse.KMSKeyID != "" {
+			sse.Mode = uploader.SSEKMS
+		}
+	case "aes256":
+		sse.Mode = uploader.SSEManaged
+	case "aws:kms", "kms":
+		sse.Mode = uploader.SSEKMS
+	case "sse-c":
+		sse.Mode = uploader.SSECustomer
+	default:
+		return sse, errors.Errorf("invalid %s %q (expected AES256, aws:kms or SSE-C)", serverSideEncryptionKey, mode)
+	}
+
+	if path := config[customerKeyFileKey]; path != "" {
+		if sse.Mode != "" && sse.Mode != uploader.SSECustomer {
+			return sse, errors.Errorf("%s cannot be used with %s encryption", customerKeyFileKey, sse.Mode)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return sse, errors.Wrapf(err, "could not read %s", customerKeyFileKey)
+		}
+		sse.Mode, sse.CustomerKey = uploader.SSECustomer, data
+		if len(data) != uploader.SSECustomerKeySize {
+			if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+				sse.CustomerKey = key
+			}
 		}
-		return access, secret, nil
+	} else if sse.Mode == uploader.SSECustomer {
+		return sse, errors.Errorf("%s is required for SSE-C encryption", customerKeyFileKey)
+	}
+
+	if err := sse.Validate(); err != nil {
+		return sse, errors.Wrapf(err, "invalid server side encryption config")
 	}
-	f.log.Infof("read secret from  default credentialsFile: [%s] profile: [%s]", DefaultCredentialsFile, profile)
-	return f.readCredentialsFile(DefaultCredentialsFile, profile)
+	return sse, nil
 }
 
-func (f *ObjectStore) readCredentialsFile(CredentialsFile, profile string) (string, string, error) {
-	f.log.Infof("read CredentialsFile from: %s", CredentialsFile)
-	config, err := ini.OpenFile(CredentialsFile)
+// enableEncryption wraps the uploader with client-side encryption when a master key is configured.
+// encryptionKeyFile holds a 32-byte master key, raw or base64 encoded; encryptionKmsKeyId wraps the
+// data keys with AWS KMS instead, signed with the plugin credentials, so it needs a credentialed backend.
+func (f *ObjectStore) enableEncryption(config map[string]string, creds *credentials.Credentials) error {
+	var (
+		keys uploader.KeyWrapper
+		err  error
+	)
+	switch keyFile, kmsKeyID := config[encryptionKeyFileKey], config[encryptionKMSKeyIDKey]; {
+	case keyFile != "" && kmsKeyID != "":
+		return errors.Errorf("%s and %s cannot be used together", encryptionKeyFileKey, encryptionKMSKeyIDKey)
+	case keyFile != "":
+		if keys, err = uploader.NewLocalKeyWrapperFromFile(keyFile); err != nil {
+			return errors.Wrapf(err, "could not load %s", encryptionKeyFileKey)
+		}
+	case kmsKeyID != "":
+		if creds == nil {
+			return errors.Errorf("%s is not supported by s3Type %s", encryptionKMSKeyIDKey, config[s3TypeKey])
+		}
+		if keys, err = uploader.NewKMSKeyWrapper(kmsKeyID, config[encryptionKMSEndpointKey], config[regionKey], creds); err != nil {
+			return fmt.Errorf("init kms key wrapper error: %w", err)
+		}
+	default:
+		if config[encryptionKMSEndpointKey] != "" {
+			return errors.Errorf("%s requires %s", encryptionKMSEndpointKey, encryptionKMSKeyIDKey)
+		}
+		return nil
+	}
+	f.uploader = uploader.NewEncryptingUploader(f.uploader, keys, f.log)
+	f.log.Infof("client side encryption enabled with master key %s", keys.KeyID())
+	return nil
+}
+
+// enableCompression wraps the uploader with gzip or zstd compression when configured.
+// It must wrap the encrypting uploader, since ciphertext does not compress.
+func (f *ObjectStore) enableCompression(config map[string]string) error {
+	name := config[compressionKey]
+	if name == "" || name == "none" {
+		return nil
+	}
+	codec, err := uploader.ParseCodec(name)
 	if err != nil {
-		return "", "", fmt.Errorf("read from %s error", DefaultCredentialsFile)
+		return errors.Wrapf(err, "invalid %s", compressionKey)
+	}
+	f.uploader = uploader.NewCompressingUploader(f.uploader, codec, f.log)
+	f.log.Infof("%s compression enabled", codec)
+	return nil
+}
+
+// parseOperationTimeouts reads the per-operation deadlines from the BSL config.
+// Unset keys keep their defaults and "0" disables the deadline.
+func parseOperationTimeouts(config map[string]string) (operationTimeouts, error) {
+	timeouts := defaultOperationTimeouts
+	for key, timeout := range map[string]*time.Duration{
+		uploadTimeoutKey:  &timeouts.put,
+		getTimeoutKey:     &timeouts.get,
+		listTimeoutKey:    &timeouts.list,
+		deleteTimeoutKey:  &timeouts.delete,
+		presignTimeoutKey: &timeouts.presign,
+	} {
+		val := config[key]
+		if val == "" {
+			continue
+		}
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return timeouts, errors.Wrapf(err, "could not parse %s (expected duration)", key)
+		}
+		if d < 0 {
+			return timeouts, errors.Errorf("%s must not be negative", key)
+		}
+		*timeout = d
+	}
+	return timeouts, nil
+}
+
+// context returns a context for a single object store call, bounded by timeout when it is set.
+func (f *ObjectStore) context(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// credentialChain builds the provider chain used to resolve the access keys: environment variables first,
+// then Vault when configured, then a projected service account token (IRSA/RRSA), then the configured
+// credentials file, then the file mounted at the default path.
+// Roles are assumed through the STS described by stsOptions. The instance metadata service,
+// when selected, is tried last.
+func (f *ObjectStore) credentialChain(credentialsFile, profile string, stsOptions credentials.STSOptions,
+	vault, metadata credentials.Provider) *credentials.Chain {
+	providers := []credentials.Provider{credentials.NewEnvProvider()}
+	if vault != nil {
+		providers = append(providers, vault)
+	}
+	providers = append(providers, credentials.NewWebIdentityProvider(stsOptions))
+	if credentialsFile != "" {
+		file := credentials.NewFileProvider(credentialsFile, profile, true)
+		file.STS = stsOptions
+		providers = append(providers, file)
+	}
+	file := credentials.NewFileProvider(credentials.DefaultCredentialsFile, profile, false)
+	file.STS = stsOptions
+	providers = append(providers, file)
+	if metadata != nil {
+		providers = append(providers, metadata)
+	}
+	return credentials.NewChain(f.log, providers...)
+}
+
+// watchCredentials reloads creds whenever a mounted credentials file changes, so that rotating the
+// Kubernetes secret takes effect without restarting Velero. interval is the credentialsReloadInterval config,
+// empty for the default and "0" to disable reloading.
+func (f *ObjectStore) watchCredentials(creds *credentials.Credentials, interval, credentialsFile string) error {
+	if f.stopWatch != nil {
+		f.stopWatch()
+		f.stopWatch = nil
+	}
+
+	d := credentials.DefaultWatchInterval
+	if interval != "" {
+		var err error
+		if d, err = time.ParseDuration(interval); err != nil {
+			return errors.Wrapf(err, "could not parse %s (expected duration)", credentialsReloadKey)
+		}
+		if d < 0 {
+			return errors.Errorf("%s must not be negative", credentialsReloadKey)
+		}
 	}
-	iniProfile, ok := config.GetSection(profile)
-	if !ok {
-		return "", "", awserr.New("SharedCredsLoad", "failed to get profile", nil)
+	if d == 0 {
+		return nil
 	}
 
-	id := iniProfile.String(accessKeyIDKey)
-	if len(id) == 0 {
-		return "", "", fmt.Errorf("get aws_access_key_id empty :%v", id)
+	paths := []string{credentials.DefaultCredentialsFile}
+	if credentialsFile != "" && credentialsFile != credentials.DefaultCredentialsFile {
+		paths = append(paths, credentialsFile)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f.stopWatch = cancel
+	credentials.WatchFiles(ctx, creds, d, f.log, paths...)
+	return nil
+}
+
+// renewCredentials keeps leased credentials such as Vault secrets renewed for the life of the plugin,
+// even while no backup is running. It stops the renewal of the previous Init.
+func (f *ObjectStore) renewCredentials(creds *credentials.Credentials, enabled bool) {
+	if f.stopRenew != nil {
+		f.stopRenew()
+		f.stopRenew = nil
+	}
+	if !enabled {
+		return
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f.stopRenew = cancel
+	credentials.RenewBeforeExpiry(ctx, creds, credentials.DefaultRenewInterval, f.log)
+}
 
-	secret := iniProfile.String(secretAccessKey)
-	if len(secret) == 0 {
-		return "", "", fmt.Errorf("get aws_secret_access_key empty :%v", id)
+// vaultProvider returns the Vault provider configured by the vault* keys, logging in with the
+// Kubernetes auth method. It returns nil when vaultPath is unset.
+func vaultProvider(config map[string]string) (credentials.Provider, error) {
+	path := config[vaultPathKey]
+	if path == "" {
+		return nil, nil
+	}
+	address := config[vaultAddressKey]
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" || config[vaultRoleKey] == "" {
+		return nil, errors.Errorf("%s and %s are required with %s", vaultAddressKey, vaultRoleKey, vaultPathKey)
 	}
 
-	return id, secret, nil
+	provider := credentials.NewVaultProvider(address, config[vaultRoleKey], path)
+	provider.Namespace = config[vaultNamespaceKey]
+	if authPath := config[vaultAuthPathKey]; authPath != "" {
+		provider.AuthPath = authPath
+	}
+	if caCert := config[vaultCACertKey]; caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read %s", vaultCACertKey)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in %s %s", vaultCACertKey, caCert)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		provider.HTTPClient.Transport = transport
+	}
+	return provider, nil
+}
+
+// metadataProvider returns the instance metadata provider selected by the instanceMetadata key:
+// "ecs" for the RAM role of an Alibaba Cloud ECS instance, "ec2" for the IAM role of an EC2 instance.
+// It returns nil when the key is unset.
+func metadataProvider(config map[string]string) (credentials.Provider, error) {
+	var provider *credentials.MetadataProvider
+	switch kind := config[instanceMetadataKey]; kind {
+	case "":
+		return nil, nil
+	case "ecs":
+		provider = credentials.NewECSRAMRoleProvider(config[metadataRoleKey])
+	case "ec2":
+		provider = credentials.NewEC2RoleProvider(config[metadataRoleKey])
+	default:
+		return nil, errors.Errorf("invalid %s %q (expected ecs or ec2)", instanceMetadataKey, kind)
+	}
+	if endpoint := config[metadataEndpointKey]; endpoint != "" {
+		provider.Endpoint = endpoint
+	}
+	return provider, nil
+}
+
+// stsTimeout bounds each STS request, so an unresponsive STS cannot stall the object store operations.
+const stsTimeout = 30 * time.Second
+
+// stsOptions returns the STS defaults for assuming roles: MinIO serves the STS API on its own endpoint,
+// AWS signs STS requests for the configured region and OSS uses the public Alibaba Cloud STS endpoint.
+func stsOptions(s3Type, s3URL, region string, useSSL bool) credentials.STSOptions {
+	options := credentials.STSOptions{Region: region, HTTPClient: uploader.TracingHTTPClient(stsTimeout)}
+	if s3Type == "minio" {
+		options.Endpoint = s3URL
+		if !strings.Contains(s3URL, "://") {
+			scheme := "http://"
+			if useSSL {
+				scheme = "https://"
+			}
+			options.Endpoint = scheme + s3URL
+		}
+	}
+	return options
 }
 
 func (f *ObjectStore) PutObject(bucket string, key string, body io.Reader) error {
 	f.log.WithFields(map[string]interface{}{"key": key, "bucket": bucket}).Infof("put object")
-	err := f.uploader.PutObject(bucket, key, body)
+	ctx, cancel := f.context(f.timeouts.put)
+	defer cancel()
+	ctx, span := f.startSpan(ctx, "PutObject", bucket, attribute.String("key", key))
+	body, sum := f.auditBody(body)
+	counter := &countingReader{Reader: body}
+	err := f.uploader.PutObject(ctx, bucket, key, counter)
+	span.SetAttributes(attribute.Int64("size", counter.n))
+	endSpan(span, err)
+	record := audit.Record{Operation: "PutObject", Bucket: bucket, Key: key, Size: &counter.n}
+	if err == nil && sum != nil {
+		record.Checksum = checksum(sum)
+	}
+	f.auditRecord(record, err)
 	if err != nil {
 		f.log.Errorf("put object error: [%v]", err)
 		return err
@@ -181,27 +680,91 @@ func (f *ObjectStore) PutObject(bucket string, key string, body io.Reader) error
 
 func (f *ObjectStore) ObjectExists(bucket, key string) (bool, error) {
 	f.log.WithFields(map[string]interface{}{"key": key, "bucket": bucket}).Infof("check object exists")
-	return f.uploader.ObjectExists(bucket, key)
+	ctx, cancel := f.context(f.timeouts.list)
+	defer cancel()
+	ctx, span := f.startSpan(ctx, "ObjectExists", bucket, attribute.String("key", key))
+	exists, err := f.uploader.ObjectExists(ctx, bucket, key)
+	span.SetAttributes(attribute.Bool("exists", exists))
+	endSpan(span, err)
+	return exists, err
 }
 
 func (f *ObjectStore) GetObject(bucket, key string) (io.ReadCloser, error) {
 	f.log.WithFields(map[string]interface{}{"key": key, "bucket": bucket}).Infof("get object")
-	return f.uploader.GetObject(bucket, key)
+	// the deadline and the span cover reading the body, so they end when Velero closes it
+	ctx, cancel := f.context(f.timeouts.get)
+	ctx, span := f.startSpan(ctx, "GetObject", bucket, attribute.String("key", key))
+	body, err := f.uploader.GetObject(ctx, bucket, key)
+	if err != nil {
+		endSpan(span, err)
+		cancel()
+		return nil, err
+	}
+	return &cancelOnClose{ReadCloser: body, cancel: cancel, span: span}, nil
+}
+
+// cancelOnClose releases the context and ends the span of a GetObject call once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	span   trace.Span
+	size   int64
+	// err is the first error reading the body
+	err error
+}
+
+func (r *cancelOnClose) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+func (r *cancelOnClose) Close() error {
+	defer r.cancel()
+	err := r.ReadCloser.Close()
+	r.span.SetAttributes(attribute.Int64("size", r.size))
+	if r.err != nil {
+		endSpan(r.span, r.err)
+	} else {
+		endSpan(r.span, err)
+	}
+	return err
 }
 
 func (f *ObjectStore) ListCommonPrefixes(bucket, prefix, delimiter string) ([]string, error) {
 	f.log.WithFields(map[string]interface{}{"prefix": prefix, "bucket": bucket, "delimiter": delimiter}).Infof("list common prefixes")
-	return f.uploader.ListCommonPrefixes(bucket, prefix, delimiter)
+	ctx, cancel := f.context(f.timeouts.list)
+	defer cancel()
+	ctx, span := f.startSpan(ctx, "ListCommonPrefixes", bucket, attribute.String("prefix", prefix))
+	prefixes, err := f.uploader.ListCommonPrefixes(ctx, bucket, prefix, delimiter)
+	span.SetAttributes(attribute.Int("count", len(prefixes)))
+	endSpan(span, err)
+	return prefixes, err
 }
 
 func (f *ObjectStore) ListObjects(bucket, prefix string) ([]string, error) {
 	f.log.WithFields(map[string]interface{}{"prefix": prefix, "bucket": bucket}).Infof("list objects")
-	return f.uploader.ListObjects(bucket, prefix)
+	ctx, cancel := f.context(f.timeouts.list)
+	defer cancel()
+	ctx, span := f.startSpan(ctx, "ListObjects", bucket, attribute.String("prefix", prefix))
+	objects, err := f.uploader.ListObjects(ctx, bucket, prefix)
+	span.SetAttributes(attribute.Int("count", len(objects)))
+	endSpan(span, err)
+	return objects, err
 }
 
 func (f *ObjectStore) DeleteObject(bucket, key string) error {
 	f.log.WithFields(map[string]interface{}{"key": key, "bucket": bucket}).Infof("delete object")
-	return f.uploader.DeleteObject(bucket, key)
+	ctx, cancel := f.context(f.timeouts.delete)
+	defer cancel()
+	ctx, span := f.startSpan(ctx, "DeleteObject", bucket, attribute.String("key", key))
+	err := f.uploader.DeleteObject(ctx, bucket, key)
+	endSpan(span, err)
+	f.auditRecord(audit.Record{Operation: "DeleteObject", Bucket: bucket, Key: key}, err)
+	return err
 }
 
 func (f *ObjectStore) CreateSignedURL(bucket, key string, ttl time.Duration) (string, error) {
@@ -211,5 +774,11 @@ func (f *ObjectStore) CreateSignedURL(bucket, key string, ttl time.Duration) (st
 		"ttl":    ttl,
 	})
 	log.Infof("build signedUrl")
-	return f.uploader.CreateSignedURL(bucket, key, ttl)
+	ctx, cancel := f.context(f.timeouts.presign)
+	defer cancel()
+	ctx, span := f.startSpan(ctx, "CreateSignedURL", bucket, attribute.String("key", key), attribute.String("ttl", ttl.String()))
+	url, err := f.uploader.CreateSignedURL(ctx, bucket, key, ttl)
+	endSpan(span, err)
+	f.auditRecord(audit.Record{Operation: "CreateSignedURL", Bucket: bucket, Key: key, TTL: ttl.String()}, err)
+	return url, err
 }
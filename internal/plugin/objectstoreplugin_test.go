@@ -0,0 +1,657 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/audit"
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/uploader"
+)
+
+func TestParseOperationTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		want    operationTimeouts
+		wantErr bool
+	}{
+		{name: "defaults", config: map[string]string{}, want: defaultOperationTimeouts},
+		{
+			name: "overrides",
+			config: map[string]string{
+				uploadTimeoutKey:  "0",
+				getTimeoutKey:     "10m",
+				listTimeoutKey:    "30s",
+				deleteTimeoutKey:  "5s",
+				presignTimeoutKey: "1s",
+			},
+			want: operationTimeouts{get: 10 * time.Minute, list: 30 * time.Second, delete: 5 * time.Second, presign: time.Second},
+		},
+		{name: "invalid duration", config: map[string]string{listTimeoutKey: "soon"}, wantErr: true},
+		{name: "negative duration", config: map[string]string{getTimeoutKey: "-1s"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOperationTimeouts(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOperationTimeouts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseOperationTimeouts() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMultipartOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		want    uploader.MultipartOptions
+		wantErr bool
+	}{
+		{name: "defaults", config: map[string]string{}},
+		{
+			name: "overrides",
+			config: map[string]string{
+				partSizeKey:           "16777216",
+				uploadConcurrencyKey:  "8",
+				multipartThresholdKey: "33554432",
+				checkpointDirKey:      "/scratch",
+				checkpointMaxAgeKey:   "48h",
+			},
+			want: uploader.MultipartOptions{PartSize: 16 << 20, Concurrency: 8, Threshold: 32 << 20, CheckpointDir: "/scratch", CheckpointMaxAge: 48 * time.Hour},
+		},
+		{name: "small part size", config: map[string]string{partSizeKey: "1024"}, wantErr: true},
+		{name: "zero concurrency", config: map[string]string{uploadConcurrencyKey: "0"}, wantErr: true},
+		{name: "negative concurrency", config: map[string]string{uploadConcurrencyKey: "-1"}, wantErr: true},
+		{name: "invalid threshold", config: map[string]string{multipartThresholdKey: "large"}, wantErr: true},
+		{name: "zero checkpoint max age", config: map[string]string{checkpointMaxAgeKey: "0s"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMultipartOptions(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMultipartOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseMultipartOptions() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// blockingUploader blocks every call until its context is done and records the deadline it was given.
+type blockingUploader struct {
+	deadlines map[string]time.Duration
+}
+
+func (b *blockingUploader) wait(ctx context.Context, op string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		b.deadlines[op] = time.Until(deadline).Round(time.Second)
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *blockingUploader) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	return b.wait(ctx, "put")
+}
+
+func (b *blockingUploader) ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	return false, b.wait(ctx, "exists")
+}
+
+func (b *blockingUploader) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		b.deadlines["get"] = time.Until(deadline).Round(time.Second)
+	}
+	return &contextBody{ctx: ctx}, nil
+}
+
+func (b *blockingUploader) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	return nil, b.wait(ctx, "list")
+}
+
+func (b *blockingUploader) DeleteObject(ctx context.Context, bucket, key string) error {
+	return b.wait(ctx, "delete")
+}
+
+func (b *blockingUploader) ListCommonPrefixes(ctx context.Context, bucket, prefix, delimiter string) ([]string, error) {
+	return nil, b.wait(ctx, "prefixes")
+}
+
+func (b *blockingUploader) CreateSignedURL(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	return "", b.wait(ctx, "presign")
+}
+
+// contextBody fails reads once the context of the GetObject call is done.
+type contextBody struct {
+	ctx context.Context
+}
+
+func (c *contextBody) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return 0, io.EOF
+}
+
+func (c *contextBody) Close() error { return nil }
+
+func TestObjectStoreOperationDeadlines(t *testing.T) {
+	fake := &blockingUploader{deadlines: make(map[string]time.Duration)}
+	store := &ObjectStore{
+		log:      logrus.New(),
+		uploader: fake,
+		timeouts: operationTimeouts{
+			put:     50 * time.Millisecond,
+			get:     time.Hour,
+			list:    50 * time.Millisecond,
+			delete:  50 * time.Millisecond,
+			presign: 50 * time.Millisecond,
+		},
+	}
+
+	if err := store.PutObject("velero", "key", strings.NewReader("x")); err != context.DeadlineExceeded {
+		t.Errorf("PutObject() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := store.ObjectExists("velero", "key"); err != context.DeadlineExceeded {
+		t.Errorf("ObjectExists() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := store.ListObjects("velero", "backups/"); err != context.DeadlineExceeded {
+		t.Errorf("ListObjects() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := store.ListCommonPrefixes("velero", "backups/", "/"); err != context.DeadlineExceeded {
+		t.Errorf("ListCommonPrefixes() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := store.DeleteObject("velero", "key"); err != context.DeadlineExceeded {
+		t.Errorf("DeleteObject() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := store.CreateSignedURL("velero", "key", time.Minute); err != context.DeadlineExceeded {
+		t.Errorf("CreateSignedURL() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the GetObject context must stay valid until Velero closes the body
+	body, err := store.GetObject("velero", "key")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	if got := fake.deadlines["get"]; got != time.Hour {
+		t.Errorf("GetObject() deadline = %v, want %v", got, time.Hour)
+	}
+	if _, err := io.ReadAll(body); err != nil {
+		t.Errorf("read body before close error = %v", err)
+	}
+	body.Close()
+	if _, err := body.Read(make([]byte, 1)); err != context.Canceled {
+		t.Errorf("read body after close error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestObjectStoreWithoutDeadline(t *testing.T) {
+	store := &ObjectStore{log: logrus.New()}
+	ctx, cancel := store.context(0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("context(0) has a deadline, want none")
+	}
+}
+
+func TestSTSOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		s3Type       string
+		s3URL        string
+		useSSL       bool
+		wantEndpoint string
+	}{
+		{name: "minio without scheme", s3Type: "minio", s3URL: "minio.velero:9000", wantEndpoint: "http://minio.velero:9000"},
+		{name: "minio with ssl", s3Type: "minio", s3URL: "minio.velero:9000", useSSL: true, wantEndpoint: "https://minio.velero:9000"},
+		{name: "minio with scheme", s3Type: "minio", s3URL: "https://minio.example.com", wantEndpoint: "https://minio.example.com"},
+		{name: "oss uses the public sts", s3Type: "oss", s3URL: "oss-cn-hangzhou.aliyuncs.com"},
+		{name: "aws uses the public sts", s3Type: "aws", s3URL: "https://s3.amazonaws.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := stsOptions(tt.s3Type, tt.s3URL, "region-1", tt.useSSL)
+			if got.Endpoint != tt.wantEndpoint || got.Region != "region-1" {
+				t.Errorf("stsOptions() = %+v, want endpoint %q and region region-1", got, tt.wantEndpoint)
+			}
+			if got.HTTPClient == nil || got.HTTPClient.Timeout != stsTimeout {
+				t.Errorf("stsOptions() HTTP client = %+v, want timeout %s", got.HTTPClient, stsTimeout)
+			}
+		})
+	}
+}
+
+func TestMetadataProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]string
+		wantName string
+		wantErr  bool
+	}{
+		{name: "disabled", config: map[string]string{}},
+		{name: "ecs", config: map[string]string{instanceMetadataKey: "ecs"}, wantName: "instance metadata (ecs ram role)"},
+		{
+			name:     "ec2 with role and endpoint",
+			config:   map[string]string{instanceMetadataKey: "ec2", metadataRoleKey: "velero", metadataEndpointKey: "http://127.0.0.1:1338"},
+			wantName: "instance metadata (ec2 instance profile)",
+		},
+		{name: "unknown", config: map[string]string{instanceMetadataKey: "gce"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := metadataProvider(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("metadataProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantName == "" {
+				if got != nil {
+					t.Errorf("metadataProvider() = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Name() != tt.wantName {
+				t.Fatalf("metadataProvider() = %v, want %s", got, tt.wantName)
+			}
+			provider := got.(*credentials.MetadataProvider)
+			if endpoint := tt.config[metadataEndpointKey]; endpoint != "" && provider.Endpoint != endpoint {
+				t.Errorf("metadataProvider() endpoint = %s, want %s", provider.Endpoint, endpoint)
+			}
+			if provider.RoleName != tt.config[metadataRoleKey] {
+				t.Errorf("metadataProvider() role = %s, want %s", provider.RoleName, tt.config[metadataRoleKey])
+			}
+		})
+	}
+}
+
+func TestWatchCredentials(t *testing.T) {
+	creds := credentials.NewStaticCredentials("id", "secret", "")
+	store := &ObjectStore{log: logrus.New()}
+
+	if err := store.watchCredentials(creds, "", ""); err != nil || store.stopWatch == nil {
+		t.Fatalf("watchCredentials() with the default interval = %v, want a running watcher", err)
+	}
+	previous := store.stopWatch
+	if err := store.watchCredentials(creds, "0", ""); err != nil || store.stopWatch != nil {
+		t.Errorf("watchCredentials(\"0\") = %v, want no watcher", err)
+	}
+	previous()
+
+	for _, interval := range []string{"soon", "-1s"} {
+		if err := store.watchCredentials(creds, interval, ""); err == nil {
+			t.Errorf("watchCredentials(%q) succeeded, want error", interval)
+		}
+	}
+}
+
+func TestVaultProvider(t *testing.T) {
+	caCert := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caCert, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	vault := map[string]string{vaultAddressKey: "https://vault.vault:8200", vaultRoleKey: "velero", vaultPathKey: "aws/creds/velero"}
+	with := func(key, value string) map[string]string {
+		config := map[string]string{}
+		for k, v := range vault {
+			config[k] = v
+		}
+		config[key] = value
+		return config
+	}
+
+	tests := []struct {
+		name    string
+		config  map[string]string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "disabled", config: map[string]string{vaultAddressKey: "https://vault.vault:8200"}, wantNil: true},
+		{name: "kubernetes auth", config: with(vaultNamespaceKey, "team-a")},
+		{name: "custom auth path", config: with(vaultAuthPathKey, "k8s-prod")},
+		{name: "missing role", config: with(vaultRoleKey, ""), wantErr: true},
+		{name: "invalid ca certificate", config: with(vaultCACertKey, caCert), wantErr: true},
+		{name: "missing ca certificate", config: with(vaultCACertKey, caCert+".missing"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := vaultProvider(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("vaultProvider() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("vaultProvider() = %v, want nil", got)
+				}
+				return
+			}
+			provider := got.(*credentials.VaultProvider)
+			if provider.Address != tt.config[vaultAddressKey] || provider.Role != "velero" || provider.Path != "aws/creds/velero" ||
+				provider.Namespace != tt.config[vaultNamespaceKey] {
+				t.Errorf("vaultProvider() = %+v", provider)
+			}
+			if authPath := tt.config[vaultAuthPathKey]; authPath != "" && provider.AuthPath != authPath {
+				t.Errorf("vaultProvider() auth path = %s, want %s", provider.AuthPath, authPath)
+			}
+		})
+	}
+}
+
+func TestRenewCredentials(t *testing.T) {
+	creds := credentials.NewStaticCredentials("id", "secret", "")
+	store := &ObjectStore{log: logrus.New()}
+
+	store.renewCredentials(creds, true)
+	if store.stopRenew == nil {
+		t.Fatalf("renewCredentials(true) started no renewal")
+	}
+	store.renewCredentials(creds, false)
+	if store.stopRenew != nil {
+		t.Errorf("renewCredentials(false) left the renewal running")
+	}
+}
+
+func TestParseServerSideEncryption(t *testing.T) {
+	dir := t.TempDir()
+	rawKey := filepath.Join(dir, "raw.key")
+	encodedKey := filepath.Join(dir, "encoded.key")
+	shortKey := filepath.Join(dir, "short.key")
+	key := []byte(strings.Repeat("k", uploader.SSECustomerKeySize))
+	for path, data := range map[string][]byte{
+		rawKey:     key,
+		encodedKey: []byte(base64.StdEncoding.EncodeToString(key) + "\n"),
+		shortKey:   []byte("short"),
+	} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		config    map[string]string
+		wantMode  uploader.SSEMode
+		wantKeyID string
+		wantErr   bool
+	}{
+		{name: "none", config: map[string]string{}},
+		{name: "sse-s3", config: map[string]string{serverSideEncryptionKey: "AES256"}, wantMode: uploader.SSEManaged},
+		{name: "aws kms", config: map[string]string{serverSideEncryptionKey: "aws:kms"}, wantMode: uploader.SSEKMS},
+		{name: "oss kms", config: map[string]string{serverSideEncryptionKey: "KMS", kmsKeyIDKey: "key-1"}, wantMode: uploader.SSEKMS, wantKeyID: "key-1"},
+		{name: "kms key implies kms", config: map[string]string{kmsKeyIDKey: "key-1"}, wantMode: uploader.SSEKMS, wantKeyID: "key-1"},
+		{name: "raw customer key", config: map[string]string{customerKeyFileKey: rawKey}, wantMode: uploader.SSECustomer},
+		{name: "base64 customer key", config: map[string]string{serverSideEncryptionKey: "SSE-C", customerKeyFileKey: encodedKey}, wantMode: uploader.SSECustomer},
+		{name: "short customer key", config: map[string]string{customerKeyFileKey: shortKey}, wantErr: true},
+		{name: "missing customer key file", config: map[string]string{customerKeyFileKey: rawKey + ".missing"}, wantErr: true},
+		{name: "sse-c without key", config: map[string]string{serverSideEncryptionKey: "SSE-C"}, wantErr: true},
+		{name: "customer key with kms", config: map[string]string{kmsKeyIDKey: "key-1", customerKeyFileKey: rawKey}, wantErr: true},
+		{name: "kms key with sse-s3", config: map[string]string{serverSideEncryptionKey: "AES256", kmsKeyIDKey: "key-1"}, wantErr: true},
+		{name: "unknown mode", config: map[string]string{serverSideEncryptionKey: "SM4"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseServerSideEncryption(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseServerSideEncryption() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Mode != tt.wantMode || got.KMSKeyID != tt.wantKeyID {
+				t.Errorf("parseServerSideEncryption() = %+v, want mode %q and key id %q", got, tt.wantMode, tt.wantKeyID)
+			}
+			if tt.wantMode == uploader.SSECustomer && string(got.CustomerKey) != string(key) {
+				t.Errorf("parseServerSideEncryption() customer key = %q, want %q", got.CustomerKey, key)
+			}
+		})
+	}
+}
+
+func TestInitRejectsUnsupportedServerSideEncryption(t *testing.T) {
+	store := NewObjectStore(logrus.New())
+	err := store.Init(map[string]string{s3TypeKey: "cos", serverSideEncryptionKey: "AES256"})
+	if err == nil || !strings.Contains(err.Error(), serverSideEncryptionKey) {
+		t.Errorf("Init() error = %v, want %s not supported", err, serverSideEncryptionKey)
+	}
+}
+
+func TestInitEnablesEncryption(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("m", uploader.MasterKeySize)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  map[string]string
+		wantErr string
+	}{
+		{name: "key file", config: map[string]string{encryptionKeyFileKey: keyFile}},
+		{name: "missing key file", config: map[string]string{encryptionKeyFileKey: keyFile + ".missing"}, wantErr: encryptionKeyFileKey},
+		{name: "kms without credentials", config: map[string]string{encryptionKMSKeyIDKey: "alias/velero"}, wantErr: encryptionKMSKeyIDKey},
+		{name: "key file and kms", config: map[string]string{encryptionKeyFileKey: keyFile, encryptionKMSKeyIDKey: "alias/velero"}, wantErr: encryptionKMSKeyIDKey},
+		{name: "kms endpoint alone", config: map[string]string{encryptionKMSEndpointKey: "http://kms.local"}, wantErr: encryptionKMSEndpointKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			tt.config[s3TypeKey] = "filesystem"
+			tt.config[rootDirKey] = root
+			store := NewObjectStore(logrus.New())
+			err := store.Init(tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Init() error = %v, want error about %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Init() error = %v", err)
+			}
+
+			if err := store.PutObject("velero", "backups/b1/b1.tar.gz", strings.NewReader("content")); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			stored, err := os.ReadFile(filepath.Join(root, "velero", "backups", "b1", "b1.tar.gz"))
+			if err != nil || strings.Contains(string(stored), "content") {
+				t.Errorf("stored object = %q, %v, want it encrypted", stored, err)
+			}
+			body, err := store.GetObject("velero", "backups/b1/b1.tar.gz")
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			defer body.Close()
+			if got, err := io.ReadAll(body); err != nil || string(got) != "content" {
+				t.Errorf("GetObject() = %q, %v, want content", got, err)
+			}
+		})
+	}
+}
+
+func TestInitEnablesCompression(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("m", uploader.MasterKeySize)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Repeat(`{"kind":"Backup"}`, 1024)
+
+	tests := []struct {
+		name       string
+		config     map[string]string
+		wantPrefix string
+		wantErr    bool
+	}{
+		{name: "disabled", config: map[string]string{compressionKey: "none"}, wantPrefix: `{"kind"`},
+		{name: "gzip", config: map[string]string{compressionKey: "gzip"}, wantPrefix: "\x1f\x8b"},
+		{name: "zstd", config: map[string]string{compressionKey: "zstd"}, wantPrefix: "\x50\x2a\x4d\x18"},
+		{name: "zstd and encryption", config: map[string]string{compressionKey: "zstd", encryptionKeyFileKey: keyFile}, wantPrefix: "VOSPENC1"},
+		{name: "unknown codec", config: map[string]string{compressionKey: "lz4"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			tt.config[s3TypeKey] = "filesystem"
+			tt.config[rootDirKey] = root
+			store := NewObjectStore(logrus.New())
+			err := store.Init(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Init() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if err := store.PutObject("velero", "backups/b1/velero-backup.json", strings.NewReader(content)); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			stored, err := os.ReadFile(filepath.Join(root, "velero", "backups", "b1", "velero-backup.json"))
+			if err != nil || !strings.HasPrefix(string(stored), tt.wantPrefix) {
+				t.Errorf("stored object = %q, %v, want prefix %q", stored[:8], err, tt.wantPrefix)
+			}
+			body, err := store.GetObject("velero", "backups/b1/velero-backup.json")
+			if err != nil {
+				t.Fatalf("GetObject() error = %v", err)
+			}
+			defer body.Close()
+			if got, err := io.ReadAll(body); err != nil || string(got) != content {
+				t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(content))
+			}
+		})
+	}
+}
+
+func TestInitEnablesAuditLog(t *testing.T) {
+	if err := NewObjectStore(logrus.New()).Init(map[string]string{s3TypeKey: "filesystem", rootDirKey: t.TempDir(), auditLogKey: "audit.log"}); err == nil || !strings.Contains(err.Error(), auditLogKey) {
+		t.Errorf("Init() error = %v, want error about %s", err, auditLogKey)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	store := NewObjectStore(logrus.New())
+	// the checksum is taken before compression, so it matches the backup file
+	if err := store.Init(map[string]string{s3TypeKey: "filesystem", rootDirKey: t.TempDir(), auditLogKey: path, compressionKey: "gzip"}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := store.PutObject("velero", "backups/b1/b1.tar.gz", strings.NewReader("backup")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	// reads are not audited
+	body, err := store.GetObject("velero", "backups/b1/b1.tar.gz")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	body.Close()
+	if err := store.DeleteObject("velero", "backups/b1/b1.tar.gz"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	// the credentialed backends also record the principal and where its credentials came from,
+	// with the profile only when they were read from the credentials file
+	store.creds = credentials.NewStaticCredentials("AKID", "secret", "")
+	store.CreateSignedURL("velero", "backups/b1/b1.tar.gz", 10*time.Minute)
+	credentialsFile := filepath.Join(t.TempDir(), "cloud")
+	if err := os.WriteFile(credentialsFile, []byte("[backup]\naws_access_key_id = BACKUP\naws_secret_access_key = secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store.creds = credentials.NewCredentials(credentials.NewFileProvider(credentialsFile, "backup", true), logrus.New())
+	store.CreateSignedURL("velero", "backups/b1/b1.tar.gz", 10*time.Minute)
+
+	size := int64(6)
+	want := []audit.Record{
+		{
+			Operation: "PutObject", Backend: "filesystem", Bucket: "velero", Key: "backups/b1/b1.tar.gz",
+			Size: &size, Checksum: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("backup"))), Result: audit.ResultSuccess,
+		},
+		{Operation: "DeleteObject", Backend: "filesystem", Bucket: "velero", Key: "backups/b1/b1.tar.gz", Result: audit.ResultSuccess},
+		{
+			Operation: "CreateSignedURL", Backend: "filesystem", Bucket: "velero", Key: "backups/b1/b1.tar.gz", TTL: "10m0s",
+			CredentialSource: "static", AccessKeyID: "AKID", Result: audit.ResultError,
+		},
+		{
+			Operation: "CreateSignedURL", Backend: "filesystem", Bucket: "velero", Key: "backups/b1/b1.tar.gz", TTL: "10m0s",
+			CredentialSource: fmt.Sprintf("credentials file %s [backup]", credentialsFile), Profile: "backup", AccessKeyID: "BACKUP",
+			Result: audit.ResultError,
+		},
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read audit log error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("audit log = %q, want %d records", data, len(want))
+	}
+	for i, line := range lines {
+		var got audit.Record
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("unmarshal %q error = %v", line, err)
+		}
+		if got.Timestamp.IsZero() {
+			t.Errorf("record %d has no timestamp", i)
+		}
+		// the filesystem backend signs no URL without its file server
+		if want[i].Result == audit.ResultError && got.Error == "" {
+			t.Errorf("record %d has no error", i)
+		}
+		got.Timestamp, got.Error = time.Time{}, ""
+		gotJSON, _ := json.Marshal(got)
+		wantJSON, _ := json.Marshal(want[i])
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("record %d = %s, want %s", i, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestShutdownFlushesAuditLog(t *testing.T) {
+	received := make(chan string, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer webhook.Close()
+
+	store := NewObjectStore(logrus.New())
+	config := map[string]string{s3TypeKey: "filesystem", rootDirKey: t.TempDir(), auditLogKey: webhook.URL,
+		auditSpoolFileKey: filepath.Join(t.TempDir(), "undelivered.log")}
+	if err := store.Init(config); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := store.DeleteObject("velero", "backups/b1/b1.tar.gz"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	Shutdown(logrus.New())
+
+	select {
+	case got := <-received:
+		if !strings.Contains(got, `"operation":"DeleteObject"`) {
+			t.Errorf("webhook received %s, want the DeleteObject record", got)
+		}
+	default:
+		t.Fatal("audit record was not sent before Shutdown returned")
+	}
+	auditLoggers.mu.Lock()
+	defer auditLoggers.mu.Unlock()
+	if len(auditLoggers.loggers) != 0 {
+		t.Errorf("%d audit loggers left after Shutdown", len(auditLoggers.loggers))
+	}
+}
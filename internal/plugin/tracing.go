@@ -0,0 +1,156 @@
+/*
+Copyright 2017, 2019 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName is the instrumentation name of the ObjectStore spans.
+	tracerName = "github.com/noovertime7/velero-os-plugin"
+	// tracingServiceName is the service.name of the exported spans.
+	tracingServiceName = "velero-os-plugin"
+	// tracingBatchTimeout is how long spans are batched before being exported. The plugin
+	// process may be stopped by Velero at any time, so it is much shorter than the SDK default.
+	tracingBatchTimeout = time.Second
+)
+
+// tracingSetup installs the process-wide tracer provider once, like the metrics exporter.
+var tracingSetup struct {
+	mu       sync.Mutex
+	endpoint string
+	// provider is shut down by Shutdown to export the spans still batched
+	provider *sdktrace.TracerProvider
+}
+
+// enableTracing exports the spans to the OTLP/HTTP endpoint, such as http://otel-collector:4318.
+// An endpoint without scheme uses https. Velero may call Init for every backup storage location,
+// so only the first endpoint of the process is used.
+func enableTracing(endpoint string, log logrus.FieldLogger) error {
+	tracingSetup.mu.Lock()
+	defer tracingSetup.mu.Unlock()
+	if endpoint == "" || endpoint == tracingSetup.endpoint {
+		return nil
+	}
+	if tracingSetup.endpoint != "" {
+		log.Warnf("spans are already exported to %s, ignore %s %s", tracingSetup.endpoint, tracingEndpointKey, endpoint)
+		return nil
+	}
+
+	options, err := otlpOptions(endpoint)
+	if err != nil {
+		return err
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return errors.Wrapf(err, "could not create OTLP exporter for %s", endpoint)
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", tracingServiceName)))
+	if err != nil {
+		return err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(tracingBatchTimeout)),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	tracingSetup.endpoint, tracingSetup.provider = endpoint, provider
+	log.Infof("exporting spans to %s", endpoint)
+	return nil
+}
+
+// shutdownTracing exports the spans still batched and stops the tracer provider, if any.
+func shutdownTracing(ctx context.Context, log logrus.FieldLogger) {
+	tracingSetup.mu.Lock()
+	provider := tracingSetup.provider
+	tracingSetup.endpoint, tracingSetup.provider = "", nil
+	tracingSetup.mu.Unlock()
+	if provider == nil {
+		return
+	}
+	if err := provider.Shutdown(ctx); err != nil {
+		log.Warnf("export spans error: %v", err)
+	}
+}
+
+// otlpOptions converts the tracingEndpoint URL to the options of the OTLP/HTTP exporter.
+func otlpOptions(endpoint string) ([]otlptracehttp.Option, error) {
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, errors.Errorf("invalid %s %q (expected URL)", tracingEndpointKey, endpoint)
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	switch u.Scheme {
+	case "http":
+		options = append(options, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, errors.Errorf("invalid %s %q (expected http or https)", tracingEndpointKey, endpoint)
+	}
+	if u.Path != "" && u.Path != "/" {
+		options = append(options, otlptracehttp.WithURLPath(u.Path))
+	}
+	return options, nil
+}
+
+// startSpan starts the span of an ObjectStore call. The uploaders record the HTTP requests
+// of the storage SDKs as its children.
+func (f *ObjectStore) startSpan(ctx context.Context, method, bucket string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("backend", f.backend), attribute.String("bucket", bucket))
+	return otel.Tracer(tracerName).Start(ctx, "ObjectStore."+method, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// countingReader counts the bytes of an object read by the uploader.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2017, 2019 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestObjectStoreSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(prev)
+
+	store := NewObjectStore(logrus.New())
+	if err := store.Init(map[string]string{s3TypeKey: "filesystem", rootDirKey: t.TempDir()}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := store.PutObject("velero", "backups/b1/b1.tar.gz", strings.NewReader("backup")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	body, err := store.GetObject("velero", "backups/b1/b1.tar.gz")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	io.ReadAll(body)
+	body.Close()
+	store.ObjectExists("velero", "backups/b1/b1.tar.gz")
+	store.ListObjects("velero", "backups/")
+	store.ListCommonPrefixes("velero", "backups/", "/")
+	store.DeleteObject("velero", "backups/b1/b1.tar.gz")
+	store.CreateSignedURL("velero", "backups/b1/b1.tar.gz", time.Minute)
+
+	tests := []struct {
+		name    string
+		want    map[attribute.Key]attribute.Value
+		wantErr bool
+	}{
+		{
+			name: "ObjectStore.PutObject",
+			want: map[attribute.Key]attribute.Value{
+				"backend": attribute.StringValue("filesystem"),
+				"bucket":  attribute.StringValue("velero"),
+				"key":     attribute.StringValue("backups/b1/b1.tar.gz"),
+				"size":    attribute.Int64Value(6),
+			},
+		},
+		{name: "ObjectStore.GetObject", want: map[attribute.Key]attribute.Value{"size": attribute.Int64Value(6)}},
+		{name: "ObjectStore.ObjectExists", want: map[attribute.Key]attribute.Value{"exists": attribute.BoolValue(true)}},
+		{name: "ObjectStore.ListObjects", want: map[attribute.Key]attribute.Value{"prefix": attribute.StringValue("backups/"), "count": attribute.IntValue(1)}},
+		{name: "ObjectStore.ListCommonPrefixes", want: map[attribute.Key]attribute.Value{"count": attribute.IntValue(1)}},
+		{name: "ObjectStore.DeleteObject", want: map[attribute.Key]attribute.Value{"key": attribute.StringValue("backups/b1/b1.tar.gz")}},
+		// the filesystem backend signs no URL without its file server
+		{name: "ObjectStore.CreateSignedURL", wantErr: true},
+	}
+	spans := exporter.GetSpans()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var span *tracetest.SpanStub
+			for i := range spans {
+				if spans[i].Name == tt.name {
+					span = &spans[i]
+				}
+			}
+			if span == nil {
+				t.Fatalf("span %s not exported", tt.name)
+			}
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, attr := range span.Attributes {
+				attrs[attr.Key] = attr.Value
+			}
+			for key, want := range tt.want {
+				if got := attrs[key]; got != want {
+					t.Errorf("attribute %s = %v, want %v", key, got.Emit(), want.Emit())
+				}
+			}
+			if got := span.Status.Code == codes.Error; got != tt.wantErr {
+				t.Errorf("status = %v, wantErr %v", span.Status, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOTLPOptions(t *testing.T) {
+	tests := []struct {
+		endpoint    string
+		wantOptions int
+		wantErr     bool
+	}{
+		{endpoint: "otel-collector:4318", wantOptions: 1},
+		{endpoint: "http://otel-collector:4318", wantOptions: 2},
+		{endpoint: "https://collector.example.com/custom/v1/traces", wantOptions: 2},
+		{endpoint: "grpc://otel-collector:4317", wantErr: true},
+		{endpoint: "http://", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			options, err := otlpOptions(tt.endpoint)
+			if (err != nil) != tt.wantErr || len(options) != tt.wantOptions {
+				t.Errorf("otlpOptions() = %d options, %v, want %d options, wantErr %v", len(options), err, tt.wantOptions, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnableTracingExportsSpans(t *testing.T) {
+	received := make(chan string, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r.Method + " " + r.URL.Path + " " + string(body)
+	}))
+	defer collector.Close()
+	prev := otel.GetTracerProvider()
+	defer func() {
+		otel.SetTracerProvider(prev)
+		shutdownTracing(context.Background(), logrus.New())
+	}()
+
+	store := NewObjectStore(logrus.New())
+	if err := store.Init(map[string]string{s3TypeKey: "filesystem", rootDirKey: t.TempDir(), tracingEndpointKey: collector.URL}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := store.DeleteObject("velero", "backups/b1/b1.tar.gz"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if !strings.HasPrefix(got, "POST /v1/traces") {
+			t.Errorf("export request = %.40q, want POST /v1/traces", got)
+		}
+		// the protobuf encoded spans carry their names as is
+		if !strings.Contains(got, "ObjectStore.DeleteObject") {
+			t.Errorf("exported spans do not contain ObjectStore.DeleteObject")
+		}
+	case <-time.After(5 * tracingBatchTimeout):
+		t.Fatal("spans were not exported")
+	}
+}
+
+func TestShutdownExportsSpans(t *testing.T) {
+	received := make(chan string, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer collector.Close()
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+
+	store := NewObjectStore(logrus.New())
+	if err := store.Init(map[string]string{s3TypeKey: "filesystem", rootDirKey: t.TempDir(), tracingEndpointKey: collector.URL}); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if err := store.DeleteObject("velero", "backups/b1/b1.tar.gz"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	// the span is exported by Shutdown, before the batch timeout
+	Shutdown(logrus.New())
+
+	select {
+	case got := <-received:
+		if !strings.Contains(got, "ObjectStore.DeleteObject") {
+			t.Errorf("exported spans do not contain ObjectStore.DeleteObject")
+		}
+	default:
+		t.Fatal("spans were not exported before Shutdown returned")
+	}
+	if tracingSetup.provider != nil || tracingSetup.endpoint != "" {
+		t.Errorf("tracer provider left after Shutdown")
+	}
+}
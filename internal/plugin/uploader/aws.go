@@ -0,0 +1,245 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awscreds "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/sirupsen/logrus"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
+)
+
+// defaultAWSRegion 未配置 region 时使用的区域
+const defaultAWSRegion = "us-east-1"
+
+// AWSUploader 基于 aws-sdk-go 实现了 Uploader 接口
+type AWSUploader struct {
+	s3        *s3.S3
+	uploader  *s3manager.Uploader
+	multipart MultipartOptions
+	sse       ServerSideEncryption
+	log       logrus.FieldLogger
+}
+
+// NewAWSUploader 创建一个 AWSUploader 实例，endpoint 为空时使用 AWS 官方地址。
+// SSE-C 加密时 aws-sdk-go 只允许通过 https 发送密钥
+func NewAWSUploader(endpoint string, creds *credentials.Credentials, region string, s3ForcePathStyle, insecureSkipTLSVerify bool,
+	multipart MultipartOptions, sse ServerSideEncryption, log logrus.FieldLogger) (Uploader, error) {
+	if err := sse.Validate(); err != nil {
+		return nil, err
+	}
+	if region == "" {
+		region = defaultAWSRegion
+	}
+
+	awsConfig := aws.NewConfig().
+		WithRegion(region).
+		WithCredentials(awscreds.NewCredentials(&awsProvider{creds: creds})).
+		WithS3ForcePathStyle(s3ForcePathStyle)
+	if endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(endpoint)
+	}
+	// 关闭对 Content-Encoding: gzip 响应的自动解压，读取到的内容与上传时一致才能校验
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+	if insecureSkipTLSVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	awsConfig = awsConfig.WithHTTPClient(&http.Client{Transport: transport})
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	// session 加载 AWS_CA_BUNDLE 时要求 Transport 为 *http.Transport，因此创建 session 之后再记录请求
+	sess.Config.HTTPClient.Transport = tracingTransport(transport)
+
+	multipart = multipart.withDefaults()
+	client := s3.New(sess)
+	uploader := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		u.PartSize = int64(multipart.PartSize)
+		u.Concurrency = int(multipart.Concurrency)
+	})
+
+	log.Info("build aws uploader success")
+	return &AWSUploader{
+		s3:        client,
+		uploader:  uploader,
+		multipart: multipart,
+		sse:       sse,
+		log:       log,
+	}, nil
+}
+
+// PutObject 将数据以分片方式上传到指定的桶和键中，失败时 s3manager 会中止分片上传。
+// aws-sdk-go 为每个请求计算 Content-MD5 由服务端校验，整个对象的 SHA-256 记录在元数据或对象标签中，读取时校验
+func (a *AWSUploader) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	input := &s3manager.UploadInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		ServerSideEncryption: a.sse.awsMode(),
+	}
+	if a.sse.Mode == SSEKMS && a.sse.KMSKeyID != "" {
+		input.SSEKMSKeyId = aws.String(a.sse.KMSKeyID)
+	}
+	if encoding := contentEncoding(ctx); encoding != "" {
+		input.ContentEncoding = aws.String(encoding)
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = a.sse.awsCustomerKey()
+
+	// 预读一个分片，数据读完说明对象以单个请求上传，可以在元数据中记录校验和
+	head, complete, err := readHead(body, a.multipart.PartSize)
+	if err != nil {
+		return err
+	}
+	if complete {
+		input.Body = bytes.NewReader(head.Bytes())
+		input.Metadata = aws.StringMap(map[string]string{checksumMetadataKey: checksumSHA256.checksum(head.Bytes())})
+		_, err = a.uploader.UploadWithContext(ctx, input)
+		return err
+	}
+
+	hr := newHashingReader(io.MultiReader(head, body), checksumSHA256)
+	input.Body = hr
+	if _, err := a.uploader.UploadWithContext(ctx, input); err != nil {
+		return err
+	}
+	a.tagChecksum(ctx, bucket, key, hr.sum())
+	return nil
+}
+
+// tagChecksum 将分片上传对象的 SHA-256 记录在对象标签中，失败时对象仍然可用，只是读取时无法校验
+func (a *AWSUploader) tagChecksum(ctx context.Context, bucket, key, sum string) {
+	_, err := a.s3.PutObjectTaggingWithContext(ctx, &s3.PutObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Tagging: &s3.Tagging{TagSet: []*s3.Tag{
+			{Key: aws.String(checksumMetadataKey), Value: aws.String(sum)},
+		}},
+	})
+	if err != nil {
+		a.log.Warnf("record checksum of [%s/%s] error, it will not be verified on read: %v", bucket, key, err)
+	}
+}
+
+// ObjectExists 检查指定的桶和键是否存在对象
+func (a *AWSUploader) ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = a.sse.awsCustomerKey()
+	_, err := a.s3.HeadObjectWithContext(ctx, input)
+	if err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetObject 获取指定桶和键的对象内容，SSE-C 加密的对象需要提供密钥才能读取。
+// 对象记录了 SHA-256 时边读取边校验，不一致时返回 ErrChecksumMismatch
+func (a *AWSUploader) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	output, err := a.s3.GetObjectWithContext(ctx, a.getObjectInput(bucket, key))
+	if err != nil {
+		return nil, err
+	}
+	want := metadataValue(aws.StringValueMap(output.Metadata), checksumMetadataKey)
+	if want == "" && aws.Int64Value(output.TagCount) > 0 {
+		tagging, err := a.s3.GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			a.log.Warnf("get checksum of [%s/%s] error, it will not be verified: %v", bucket, key, err)
+		} else {
+			for _, tag := range tagging.TagSet {
+				if aws.StringValue(tag.Key) == checksumMetadataKey {
+					want = aws.StringValue(tag.Value)
+				}
+			}
+		}
+	}
+	return verifyChecksum(output.Body, checksumSHA256, want, key), nil
+}
+
+// ListObjects 列出指定桶和前缀下的所有对象键
+func (a *AWSUploader) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var objects []string
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	err := a.s3.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// DeleteObject 删除指定桶和键的对象
+func (a *AWSUploader) DeleteObject(ctx context.Context, bucket, key string) error {
+	_, err := a.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// ListCommonPrefixes 按分隔符列出指定前缀下的公共前缀
+func (a *AWSUploader) ListCommonPrefixes(ctx context.Context, bucket, prefix, delimiter string) ([]string, error) {
+	prefixes := make([]string, 0)
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String(delimiter),
+	}
+	err := a.s3.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, commonPrefix := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.StringValue(commonPrefix.Prefix))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return prefixes, nil
+}
+
+// CreateSignedURL 在本地生成对象的预签名下载地址，不访问服务端。
+// SSE-C 加密时密钥请求头也参与签名，下载时需要携带同样的请求头
+func (a *AWSUploader) CreateSignedURL(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	req, _ := a.s3.GetObjectRequest(a.getObjectInput(bucket, key))
+	return req.Presign(ttl)
+}
+
+// getObjectInput 返回读取对象的参数，SSE-C 加密时带上密钥
+func (a *AWSUploader) getObjectInput(bucket, key string) *s3.GetObjectInput {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey = a.sse.awsCustomerKey()
+	return input
+}
@@ -0,0 +1,123 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// requestRecorder 记录经过的请求地址，再交给 next 发送
+type requestRecorder struct {
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	hosts []string
+	paths []string
+}
+
+func (r *requestRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	r.hosts = append(r.hosts, req.URL.Host)
+	r.paths = append(r.paths, req.URL.Path)
+	r.mu.Unlock()
+	return r.next.RoundTrip(req)
+}
+
+// newTestAWSUploader 创建一个连接到 server 的 AWSUploader，所有存储桶域名都连接到测试服务，
+// 返回的 requestRecorder 记录请求的地址
+func newTestAWSUploader(t *testing.T, server *fakeServer, region string, pathStyle bool, multipart MultipartOptions) (Uploader, *requestRecorder) {
+	t.Helper()
+	u, err := NewAWSUploader(server.URL, testCredentials(), region, pathStyle, false, multipart, ServerSideEncryption{}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewAWSUploader() error = %v", err)
+	}
+	recorder := &requestRecorder{next: server.virtualHostTransport()}
+	u.(*AWSUploader).s3.Config.HTTPClient.Transport = tracingTransport(recorder)
+	return u, recorder
+}
+
+func TestAWSUploaderAddressing(t *testing.T) {
+	tests := []struct {
+		name       string
+		region     string
+		pathStyle  bool
+		wantRegion string
+		// wantHost 请求的主机名，addr 为测试服务的地址
+		wantHost   func(addr string) string
+		wantPrefix string
+	}{
+		{
+			name: "path style", region: "eu-central-1", pathStyle: true, wantRegion: "eu-central-1",
+			wantHost: func(addr string) string { return addr }, wantPrefix: "/velero",
+		},
+		{
+			name: "virtual hosted", region: "ap-southeast-1", wantRegion: "ap-southeast-1",
+			wantHost: func(addr string) string { return "velero." + addr }, wantPrefix: "/",
+		},
+		{
+			name: "default region", pathStyle: true, wantRegion: defaultAWSRegion,
+			wantHost: func(addr string) string { return addr }, wantPrefix: "/velero",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			u, recorder := newTestAWSUploader(t, server, tt.region, tt.pathStyle, MultipartOptions{})
+
+			if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", strings.NewReader("content")); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			if got, err := readObject(u, "backups/b1/b1.tar.gz"); err != nil || string(got) != "content" {
+				t.Fatalf("GetObject() = %q, %v, want content", got, err)
+			}
+			if keys, err := u.ListObjects(context.Background(), "velero", "backups/"); err != nil || len(keys) != 1 {
+				t.Fatalf("ListObjects() = %v, %v, want the uploaded object", keys, err)
+			}
+
+			// 所有请求都发送到自定义 endpoint，按寻址方式携带存储桶
+			wantHost := tt.wantHost(server.Listener.Addr().String())
+			for i, host := range recorder.hosts {
+				if host != wantHost || !strings.HasPrefix(recorder.paths[i], tt.wantPrefix) {
+					t.Errorf("request %d = %s%s, want host %s and path prefix %s", i, host, recorder.paths[i], wantHost, tt.wantPrefix)
+				}
+			}
+			// 请求按配置的区域签名
+			if auth := server.header("PUT object").Get("Authorization"); !strings.Contains(auth, "/"+tt.wantRegion+"/s3/aws4_request") {
+				t.Errorf("Authorization = %q, want signed for region %s", auth, tt.wantRegion)
+			}
+		})
+	}
+}
+
+func TestAWSUploaderMultipartChecksum(t *testing.T) {
+	server := newFakeServer(t)
+	u, _ := newTestAWSUploader(t, server, "", true, MultipartOptions{PartSize: MinPartSize, Concurrency: 2})
+
+	content := make([]byte, int(MinPartSize)*2+1)
+	rand.Read(content)
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if server.count("POST initiate") != 1 || server.count("PUT part") != 3 || server.count("POST complete") != 1 {
+		t.Errorf("initiate = %d, parts = %d, complete = %d requests, want a multipart upload of 3 parts",
+			server.count("POST initiate"), server.count("PUT part"), server.count("POST complete"))
+	}
+	// 分片上传对象的 SHA-256 记录在对象标签中，读取时据此校验
+	if server.count("PUT tagging") != 1 {
+		t.Fatalf("PutObject() tagged the object %d times, want 1", server.count("PUT tagging"))
+	}
+	if got, err := readObject(u, "backups/b1/b1.tar.gz"); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(content))
+	}
+	server.corrupt("velero", "backups/b1/b1.tar.gz")
+	if _, err := readObject(u, "backups/b1/b1.tar.gz"); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("GetObject() of a corrupted object error = %v, want %v", err, ErrChecksumMismatch)
+	}
+}
@@ -0,0 +1,123 @@
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// checksumMetadataKey 记录对象 SHA-256 的用户元数据键（x-amz-meta-velero-sha256）。
+// 分片上传需要在初始化时设置元数据，此时还不知道整个对象的校验和，因此上传完成后记录在同名的对象标签中
+const checksumMetadataKey = "velero-sha256"
+
+// ErrChecksumMismatch 读取到的对象内容与上传时记录的校验和不一致
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumAlgorithm 校验和算法及其编码方式
+type checksumAlgorithm struct {
+	name   string
+	new    func() hash.Hash
+	encode func(h hash.Hash) string
+}
+
+var (
+	// checksumSHA256 MinIO 与 S3 使用，以十六进制记录
+	checksumSHA256 = checksumAlgorithm{
+		name:   "sha256",
+		new:    sha256.New,
+		encode: func(h hash.Hash) string { return hex.EncodeToString(h.Sum(nil)) },
+	}
+	// checksumCRC64 OSS 使用，与服务端 x-oss-hash-crc64ecma 一致，以十进制记录
+	checksumCRC64 = checksumAlgorithm{
+		name:   "crc64ecma",
+		new:    func() hash.Hash { return crc64.New(crc64.MakeTable(crc64.ECMA)) },
+		encode: func(h hash.Hash) string { return strconv.FormatUint(h.(hash.Hash64).Sum64(), 10) },
+	}
+)
+
+// checksum 返回 data 的校验和
+func (a checksumAlgorithm) checksum(data []byte) string {
+	h := a.new()
+	h.Write(data)
+	return a.encode(h)
+}
+
+// hashingReader 在上传读取 body 的同时计算校验和
+type hashingReader struct {
+	r         io.Reader
+	h         hash.Hash
+	algorithm checksumAlgorithm
+}
+
+func newHashingReader(r io.Reader, algorithm checksumAlgorithm) *hashingReader {
+	return &hashingReader{r: r, h: algorithm.new(), algorithm: algorithm}
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.h.Write(p[:n])
+	return n, err
+}
+
+// sum 返回已读取内容的校验和
+func (h *hashingReader) sum() string {
+	return h.algorithm.encode(h.h)
+}
+
+// verifyingReader 在读取对象的同时计算校验和，读完时与上传时记录的值比较，
+// 不一致时 Read 与 Close 都返回 ErrChecksumMismatch
+type verifyingReader struct {
+	body      io.ReadCloser
+	h         hash.Hash
+	algorithm checksumAlgorithm
+	want      string
+	key       string
+	err       error
+}
+
+// verifyChecksum 返回校验 body 内容的 Reader，want 为空（对象没有记录校验和）时原样返回 body
+func verifyChecksum(body io.ReadCloser, algorithm checksumAlgorithm, want, key string) io.ReadCloser {
+	if want == "" {
+		return body
+	}
+	return &verifyingReader{body: body, h: algorithm.new(), algorithm: algorithm, want: want, key: key}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err := v.body.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF {
+		if got := v.algorithm.encode(v.h); got != v.want {
+			v.err = fmt.Errorf("%w: %s of %s is %s, want %s", ErrChecksumMismatch, v.algorithm.name, v.key, got, v.want)
+			return n, v.err
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	err := v.body.Close()
+	if v.err != nil {
+		return v.err
+	}
+	return err
+}
+
+// metadataValue 不区分大小写地查找用户元数据，各 SDK 返回的键大小写不一致
+func metadataValue(metadata map[string]string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
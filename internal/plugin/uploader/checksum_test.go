@@ -0,0 +1,80 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestChecksumVerification(t *testing.T) {
+	large := make([]byte, int(MinPartSize)+1)
+	rand.Read(large)
+	objects := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "small", content: []byte("small object")},
+		{name: "multipart", content: large},
+	}
+	for _, backend := range sseBackends {
+		for _, object := range objects {
+			t.Run(backend.name+" "+object.name, func(t *testing.T) {
+				server := newFakeServer(t)
+				u := backend.newUploader(t, server, sseMultipart, ServerSideEncryption{})
+				key := "backups/b1/b1.tar.gz"
+
+				if err := u.PutObject(context.Background(), "velero", key, bytes.NewReader(object.content)); err != nil {
+					t.Fatalf("PutObject() error = %v", err)
+				}
+				// MinIO 与 S3 为每个请求携带 Content-MD5，OSS 由 SDK 校验每个请求的 CRC64
+				if backend.name != "oss" {
+					op := "PUT object"
+					if object.name == "multipart" {
+						op = "PUT part"
+					}
+					if server.header(op).Get("Content-Md5") == "" {
+						t.Errorf("%s without Content-Md5", op)
+					}
+					if object.name == "multipart" && server.count("PUT tagging") != 1 {
+						t.Errorf("PUT tagging = %d requests, want 1", server.count("PUT tagging"))
+					}
+				}
+
+				if got, err := readObject(u, key); err != nil || !bytes.Equal(got, object.content) {
+					t.Fatalf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(object.content))
+				}
+
+				server.corrupt("velero", key)
+				if _, err := readObject(u, key); !errors.Is(err, ErrChecksumMismatch) {
+					t.Errorf("GetObject() of a corrupted object error = %v, want %v", err, ErrChecksumMismatch)
+				}
+			})
+		}
+	}
+}
+
+func TestChecksumLegacyObjects(t *testing.T) {
+	for _, backend := range sseBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			u := backend.newUploader(t, server, sseMultipart, ServerSideEncryption{})
+			// 没有记录校验和的对象照常读取
+			server.put("velero", "backups/b1/legacy", []byte("legacy"))
+			if got, err := readObject(u, "backups/b1/legacy"); err != nil || string(got) != "legacy" {
+				t.Errorf("GetObject() = %q, %v, want %q", got, err, "legacy")
+			}
+		})
+	}
+}
+
+func TestVerifyingReaderClose(t *testing.T) {
+	body := verifyChecksum(io.NopCloser(bytes.NewReader([]byte("corrupted"))), checksumSHA256, checksumSHA256.checksum([]byte("original")), "key")
+	// 调用方不检查 Read 的错误时，Close 同样返回校验失败
+	io.Copy(io.Discard, body)
+	if err := body.Close(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Close() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+}
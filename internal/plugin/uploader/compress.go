@@ -0,0 +1,183 @@
+package uploader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// Codec 对象的压缩格式，取值同时作为对象的 Content-Encoding
+type Codec string
+
+const (
+	CodecGzip Codec = "gzip"
+	CodecZstd Codec = "zstd"
+)
+
+// 压缩后的对象仍然是标准的 gzip 或 zstd 数据，通过预签名地址下载后可以直接解压。
+// 为了与启用压缩之前写入的对象（其中不少本身就是 gzip 文件）区分，对象开头带有插件的标记：
+// gzip 写在头部的扩展字段（FEXTRA）中，zstd 写在解压时会被忽略的 skippable frame 中
+var (
+	compressMarker = []byte("velero-os-plugin")
+	// gzipMarker 扩展字段中的子字段：SI1 SI2 | 长度（2 字节，小端）| 标记
+	gzipMarker = append([]byte{'V', 'O', byte(len(compressMarker)), 0}, compressMarker...)
+	// zstdMarker skippable frame：magic（4 字节，小端）| 长度（4 字节，小端）| 标记
+	zstdMarker = append([]byte{0x50, 0x2a, 0x4d, 0x18, byte(len(compressMarker)), 0, 0, 0}, compressMarker...)
+
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// gzipHeaderSize gzip 固定头部的长度，扩展字段的长度紧随其后
+const gzipHeaderSize = 10
+
+type contentEncodingKey struct{}
+
+// WithContentEncoding 返回携带 Content-Encoding 的 ctx，PutObject 写入对象时设置该请求头，
+// 通过预签名地址下载对象的客户端据此解压
+func WithContentEncoding(ctx context.Context, encoding string) context.Context {
+	return context.WithValue(ctx, contentEncodingKey{}, encoding)
+}
+
+// contentEncoding 返回 ctx 中写入对象时使用的 Content-Encoding
+func contentEncoding(ctx context.Context) string {
+	encoding, _ := ctx.Value(contentEncodingKey{}).(string)
+	return encoding
+}
+
+// ParseCodec 解析压缩格式，支持 gzip 与 zstd
+func ParseCodec(name string) (Codec, error) {
+	switch codec := Codec(name); codec {
+	case CodecGzip, CodecZstd:
+		return codec, nil
+	}
+	return "", fmt.Errorf("unknown compression codec %q (expected gzip or zstd)", name)
+}
+
+// CompressingUploader 在上传前压缩对象、读取时解压对象，可以包装任意 Uploader。
+// 已经是 gzip 或 zstd 格式的数据（如 Velero 的备份 tar 包与日志）不再重复压缩。
+// 预签名地址指向压缩后的对象，对象上传时已设置 Content-Encoding，下载的客户端据此解压
+type CompressingUploader struct {
+	Uploader
+	codec Codec
+	log   logrus.FieldLogger
+}
+
+// NewCompressingUploader 创建一个 CompressingUploader
+func NewCompressingUploader(inner Uploader, codec Codec, log logrus.FieldLogger) *CompressingUploader {
+	return &CompressingUploader{Uploader: inner, codec: codec, log: log}
+}
+
+// PutObject 以流的方式压缩 body 并上传，并将对象的 Content-Encoding 设置为压缩格式
+func (c *CompressingUploader) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	reader := bufio.NewReader(body)
+	head, err := reader.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if bytes.HasPrefix(head, gzipMagic) || bytes.HasPrefix(head, zstdMagic) {
+		c.log.Debugf("object [%s/%s] is already compressed, upload it as is", bucket, key)
+		return c.Uploader.PutObject(ctx, bucket, key, reader)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(c.compress(pw, reader))
+	}()
+	err = c.Uploader.PutObject(WithContentEncoding(ctx, string(c.codec)), bucket, key, pr)
+	// 上传提前失败时让压缩的 goroutine 退出
+	pr.CloseWithError(err)
+	return err
+}
+
+// compress 将 body 以带标记的压缩格式写入 w
+func (c *CompressingUploader) compress(w io.Writer, body io.Reader) error {
+	var encoder io.WriteCloser
+	switch c.codec {
+	case CodecGzip:
+		gz := gzip.NewWriter(w)
+		gz.Extra = gzipMarker
+		encoder = gz
+	case CodecZstd:
+		if _, err := w.Write(zstdMarker); err != nil {
+			return err
+		}
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		encoder = zw
+	default:
+		return fmt.Errorf("unknown compression codec %q", c.codec)
+	}
+	if _, err := io.Copy(encoder, body); err != nil {
+		encoder.Close()
+		return err
+	}
+	return encoder.Close()
+}
+
+// GetObject 读取并解压对象。没有插件标记的对象是启用压缩之前写入的，或者写入时已经是压缩格式，原样返回
+func (c *CompressingUploader) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	body, err := c.Uploader.GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(body)
+	head, err := reader.Peek(gzipHeaderSize + 2 + len(gzipMarker))
+	if err != nil && err != io.EOF {
+		body.Close()
+		return nil, err
+	}
+
+	switch detectCodec(head) {
+	case CodecGzip:
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			body.Close()
+			return nil, fmt.Errorf("read gzip header of %s error: %w", key, err)
+		}
+		return readCloser{Reader: gz, Closer: body}, nil
+	case CodecZstd:
+		zr, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		return &zstdReadCloser{Decoder: zr, body: body}, nil
+	}
+	return readCloser{Reader: reader, Closer: body}, nil
+}
+
+// detectCodec 根据对象开头的插件标记判断压缩格式，没有标记时返回空
+func detectCodec(head []byte) Codec {
+	if bytes.HasPrefix(head, zstdMarker) {
+		return CodecZstd
+	}
+	if len(head) < gzipHeaderSize+2 || !bytes.HasPrefix(head, gzipMagic) || head[3]&0x04 == 0 {
+		return ""
+	}
+	if extra := head[gzipHeaderSize+2:]; int(binary.LittleEndian.Uint16(head[gzipHeaderSize:])) >= len(gzipMarker) &&
+		bytes.HasPrefix(extra, gzipMarker) {
+		return CodecGzip
+	}
+	return ""
+}
+
+// zstdReadCloser 关闭时同时释放解码器与对象内容
+type zstdReadCloser struct {
+	*zstd.Decoder
+	body io.Closer
+}
+
+func (z *zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return z.body.Close()
+}
@@ -0,0 +1,263 @@
+package uploader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// compressible 返回容易压缩的 JSON 内容
+func compressible(size int) []byte {
+	item := []byte(`{"kind":"Backup","apiVersion":"velero.io/v1"}`)
+	return bytes.Repeat(item, size/len(item)+1)[:size]
+}
+
+// decodeRaw 使用标准解码器解压对象，模拟通过预签名地址下载的客户端
+func decodeRaw(t *testing.T, codec Codec, data []byte) []byte {
+	t.Helper()
+	var (
+		r   io.Reader
+		err error
+	)
+	switch codec {
+	case CodecGzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case CodecZstd:
+		var zr *zstd.Decoder
+		zr, err = zstd.NewReader(bytes.NewReader(data))
+		if err == nil {
+			defer zr.Close()
+		}
+		r = zr
+	}
+	if err != nil {
+		t.Fatalf("decode %s error = %v", codec, err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decode %s error = %v", codec, err)
+	}
+	return plain
+}
+
+func TestCompressingUploader(t *testing.T) {
+	for _, codec := range []Codec{CodecGzip, CodecZstd} {
+		t.Run(string(codec), func(t *testing.T) {
+			server := newFakeServer(t)
+			inner, err := NewMinioUploader(server.URL, testCredentials(), false, "us-east-1", sseMultipart, ServerSideEncryption{}, logrus.New())
+			if err != nil {
+				t.Fatalf("NewMinioUploader() error = %v", err)
+			}
+			u := NewCompressingUploader(inner, codec, logrus.New())
+
+			for _, size := range []int{0, 1, 64 << 10} {
+				content := compressible(size)
+				if err := u.PutObject(context.Background(), "velero", "backups/b1/velero-backup.json", bytes.NewReader(content)); err != nil {
+					t.Fatalf("PutObject(%d bytes) error = %v", size, err)
+				}
+				if got := server.header("PUT object").Get("Content-Encoding"); got != string(codec) {
+					t.Errorf("PUT object Content-Encoding = %q, want %q", got, codec)
+				}
+				stored, _ := server.get("velero", "backups/b1/velero-backup.json")
+				if size > 1<<10 && len(stored) > size/10 {
+					t.Errorf("stored object = %d bytes, want compressed from %d bytes", len(stored), size)
+				}
+				// 存储的对象是标准格式，预签名地址下载后可以直接解压
+				if plain := decodeRaw(t, codec, stored); !bytes.Equal(plain, content) {
+					t.Errorf("decoded stored object = %d bytes, want %d bytes", len(plain), size)
+				}
+				got, err := readObject(u, "backups/b1/velero-backup.json")
+				if err != nil || !bytes.Equal(got, content) {
+					t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, size)
+				}
+			}
+
+			// 分片上传同样设置 Content-Encoding，随机数据压缩后仍然超过分片阈值
+			large := make([]byte, int(MinPartSize)*2)
+			rand.Read(large)
+			if err := u.PutObject(context.Background(), "velero", "backups/b1/large", bytes.NewReader(large)); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			if got := server.header("POST initiate").Get("Content-Encoding"); got != string(codec) {
+				t.Errorf("POST initiate Content-Encoding = %q, want %q", got, codec)
+			}
+			if got, err := readObject(u, "backups/b1/large"); err != nil || !bytes.Equal(got, large) {
+				t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(large))
+			}
+
+			// 预签名地址指向压缩后的原始对象
+			checkSignedURLDownload(t, u, "backups/b1/velero-backup.json", codec, compressible(64<<10))
+		})
+	}
+}
+
+// checkSignedURLDownload 通过预签名地址下载 key，检查响应的 Content-Encoding 以及按其解压后的内容
+func checkSignedURLDownload(t *testing.T, u Uploader, key string, codec Codec, want []byte) {
+	t.Helper()
+	url, err := u.CreateSignedURL(context.Background(), "velero", key, time.Minute)
+	if err != nil {
+		t.Fatalf("CreateSignedURL() error = %v", err)
+	}
+	// 关闭自动解压，读取存储的原始内容
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET signed url error = %v", err)
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got := resp.Header.Get("Content-Encoding"); got != string(codec) {
+		t.Errorf("signed url Content-Encoding = %q, want %q", got, codec)
+	}
+	if plain := decodeRaw(t, codec, raw); !bytes.Equal(plain, want) {
+		t.Errorf("decoded signed url download = %d bytes, want %d bytes", len(plain), len(want))
+	}
+}
+
+func TestCompressingUploaderOBSMultipart(t *testing.T) {
+	for _, codec := range []Codec{CodecGzip, CodecZstd} {
+		t.Run(string(codec), func(t *testing.T) {
+			server := newFakeServer(t)
+			u := NewCompressingUploader(newTestOBSUploader(t, server, MultipartOptions{PartSize: MinPartSize}), codec, logrus.New())
+
+			// 随机数据压缩后仍然超过分片阈值
+			large := make([]byte, int(MinPartSize)*2)
+			rand.Read(large)
+			if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(large)); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			if server.count("POST complete") != 1 || server.count("PUT metadata") != 1 {
+				t.Fatalf("complete = %d, set metadata = %d requests, want a multipart upload labeled afterwards",
+					server.count("POST complete"), server.count("PUT metadata"))
+			}
+			if got, err := readObject(u, "backups/b1/b1.tar.gz"); err != nil || !bytes.Equal(got, large) {
+				t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(large))
+			}
+			checkSignedURLDownload(t, u, "backups/b1/b1.tar.gz", codec, large)
+		})
+	}
+}
+
+func TestCompressingUploaderKeepsCompressedObjects(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(compressible(1 << 10))
+	w.Close()
+
+	for _, codec := range []Codec{CodecGzip, CodecZstd} {
+		t.Run(string(codec), func(t *testing.T) {
+			root := t.TempDir()
+			inner, err := NewFilesystemUploader(root, "", "", logrus.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := NewCompressingUploader(inner, codec, logrus.New())
+
+			// 已经压缩的备份文件原样保存，读取时也不会被解压
+			if err := u.PutObject(context.Background(), "velero", "backups/b1/b1-logs.gz", bytes.NewReader(gz.Bytes())); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			stored, err := os.ReadFile(filepath.Join(root, "velero", "backups", "b1", "b1-logs.gz"))
+			if err != nil || !bytes.Equal(stored, gz.Bytes()) {
+				t.Errorf("stored object = %d bytes, %v, want the gzip file as is", len(stored), err)
+			}
+			if got, err := readObject(u, "backups/b1/b1-logs.gz"); err != nil || !bytes.Equal(got, gz.Bytes()) {
+				t.Errorf("GetObject() = %d bytes, %v, want the gzip file as is", len(got), err)
+			}
+
+			// 启用压缩之前写入的对象原样返回
+			for _, legacy := range [][]byte{nil, []byte("{}"), gz.Bytes()} {
+				if err := inner.PutObject(context.Background(), "velero", "backups/b1/legacy", bytes.NewReader(legacy)); err != nil {
+					t.Fatalf("PutObject() error = %v", err)
+				}
+				if got, err := readObject(u, "backups/b1/legacy"); err != nil || !bytes.Equal(got, legacy) {
+					t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(legacy))
+				}
+			}
+		})
+	}
+}
+
+func TestCompressingUploaderDetectsCorruption(t *testing.T) {
+	for _, codec := range []Codec{CodecGzip, CodecZstd} {
+		t.Run(string(codec), func(t *testing.T) {
+			root := t.TempDir()
+			inner, err := NewFilesystemUploader(root, "", "", logrus.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := NewCompressingUploader(inner, codec, logrus.New())
+			if err := u.PutObject(context.Background(), "velero", "backups/b1/velero-backup.json", bytes.NewReader(compressible(1<<20))); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			path := filepath.Join(root, "velero", "backups", "b1", "velero-backup.json")
+			stored, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, stored[:len(stored)-8], 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readObject(u, "backups/b1/velero-backup.json"); err == nil {
+				t.Errorf("GetObject() of a truncated object succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestCompressingEncryptedObjects(t *testing.T) {
+	server := newFakeServer(t)
+	inner, err := NewMinioUploader(server.URL, testCredentials(), false, "us-east-1", MultipartOptions{}, ServerSideEncryption{}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewMinioUploader() error = %v", err)
+	}
+	encrypted := NewEncryptingUploader(inner, newTestKeyWrapper(t, 'm'), logrus.New())
+	u := NewCompressingUploader(encrypted, CodecZstd, logrus.New())
+
+	content := compressible(256 << 10)
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/velero-backup.json", bytes.NewReader(content)); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	// 密文不能按 Content-Encoding 解压
+	if got := server.header("PUT object").Get("Content-Encoding"); got != "" {
+		t.Errorf("PUT object Content-Encoding = %q, want none", got)
+	}
+	stored, _ := server.get("velero", "backups/b1/velero-backup.json")
+	if !strings.HasPrefix(string(stored), encryptMagic) || len(stored) > len(content)/10 {
+		t.Errorf("stored object = %d bytes, want compressed before encryption", len(stored))
+	}
+	if got, err := readObject(u, "backups/b1/velero-backup.json"); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(content))
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Codec
+		wantErr bool
+	}{
+		{name: "gzip", want: CodecGzip},
+		{name: "zstd", want: CodecZstd},
+		{name: "lz4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCodec(tt.name)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseCodec() = %q, %v, want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// conformanceBucket 是一致性测试使用的存储桶名，满足 OSS、COS 等对桶名长度的要求
+const conformanceBucket = "velero"
+
+// conformanceTarget 是一致性测试的被测对象，client 用于访问 CreateSignedURL 生成的地址
+type conformanceTarget struct {
+	uploader Uploader
+	client   *http.Client
+}
+
+// conformanceKeys 模拟 Velero 在存储桶中的目录结构
+var conformanceKeys = []string{
+	"backups/b1/b1.tar.gz",
+	"backups/b1/velero-backup.json",
+	"backups/b10/velero-backup.json",
+	"backups/b2/velero-backup.json",
+	"metadata/revision",
+	"restores/r1/restore-r1-logs.gz",
+}
+
+// runUploaderConformance 校验 Uploader 的实现是否满足 Velero 对七个方法的语义要求，
+// newTarget 每次调用都需要返回一个基于空存储的新实例
+func runUploaderConformance(t *testing.T, newTarget func(t *testing.T) conformanceTarget) {
+	seed := func(t *testing.T, u Uploader) {
+		for _, key := range conformanceKeys {
+			if err := u.PutObject(context.Background(), conformanceBucket, key, strings.NewReader(key)); err != nil {
+				t.Fatalf("PutObject(%q) error = %v", key, err)
+			}
+		}
+	}
+
+	t.Run("PutObject then GetObject returns the same content", func(t *testing.T) {
+		u := newTarget(t).uploader
+		for _, content := range [][]byte{[]byte("content"), {}, bytes.Repeat([]byte{0, 1, 2, 255}, 64<<10)} {
+			if err := u.PutObject(context.Background(), conformanceBucket, "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			if got := readConformanceObject(t, u, "backups/b1/b1.tar.gz"); !bytes.Equal(got, content) {
+				t.Errorf("GetObject() returned %d bytes, want %d", len(got), len(content))
+			}
+		}
+	})
+
+	t.Run("GetObject reports missing objects", func(t *testing.T) {
+		u := newTarget(t).uploader
+		body, err := u.GetObject(context.Background(), conformanceBucket, "backups/missing/velero-backup.json")
+		if err == nil {
+			// 部分 SDK 在首次读取时才发出请求，错误在读取时返回也可以接受
+			_, err = io.ReadAll(body)
+			body.Close()
+		}
+		if err == nil {
+			t.Errorf("GetObject() on missing object succeeded, want error")
+		}
+	})
+
+	t.Run("ObjectExists maps not found to false without error", func(t *testing.T) {
+		u := newTarget(t).uploader
+		seed(t, u)
+
+		exists, err := u.ObjectExists(context.Background(), conformanceBucket, "metadata/revision")
+		if err != nil || !exists {
+			t.Errorf("ObjectExists(existing) = %v, %v, want true, nil", exists, err)
+		}
+		for _, key := range []string{"metadata/missing", "metadata", "backups/b1"} {
+			exists, err := u.ObjectExists(context.Background(), conformanceBucket, key)
+			if err != nil || exists {
+				t.Errorf("ObjectExists(%q) = %v, %v, want false, nil", key, exists, err)
+			}
+		}
+	})
+
+	t.Run("ListObjects returns full keys under the prefix", func(t *testing.T) {
+		u := newTarget(t).uploader
+		seed(t, u)
+
+		tests := []struct {
+			prefix string
+			want   []string
+		}{
+			{prefix: "", want: conformanceKeys},
+			{prefix: "backups/", want: conformanceKeys[:4]},
+			{prefix: "backups/b1", want: conformanceKeys[:3]},
+			{prefix: "backups/b1/", want: conformanceKeys[:2]},
+			{prefix: "metadata/revision", want: conformanceKeys[4:5]},
+			{prefix: "missing/", want: nil},
+		}
+		for _, tt := range tests {
+			got, err := u.ListObjects(context.Background(), conformanceBucket, tt.prefix)
+			if err != nil {
+				t.Errorf("ListObjects(%q) error = %v", tt.prefix, err)
+				continue
+			}
+			if len(got) != 0 || len(tt.want) != 0 {
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ListObjects(%q) = %v, want %v", tt.prefix, got, tt.want)
+				}
+			}
+		}
+	})
+
+	t.Run("ListCommonPrefixes groups keys by delimiter", func(t *testing.T) {
+		u := newTarget(t).uploader
+		seed(t, u)
+
+		tests := []struct {
+			prefix string
+			want   []string
+		}{
+			{prefix: "", want: []string{"backups/", "metadata/", "restores/"}},
+			{prefix: "backups/", want: []string{"backups/b1/", "backups/b10/", "backups/b2/"}},
+			{prefix: "backups/b1", want: []string{"backups/b1/", "backups/b10/"}},
+			{prefix: "backups/b1/", want: []string{}},
+			{prefix: "missing/", want: []string{}},
+		}
+		for _, tt := range tests {
+			got, err := u.ListCommonPrefixes(context.Background(), conformanceBucket, tt.prefix, "/")
+			if err != nil {
+				t.Errorf("ListCommonPrefixes(%q) error = %v", tt.prefix, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListCommonPrefixes(%q) = %#v, want %#v", tt.prefix, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("DeleteObject removes objects and ignores missing ones", func(t *testing.T) {
+		u := newTarget(t).uploader
+		seed(t, u)
+
+		if err := u.DeleteObject(context.Background(), conformanceBucket, "backups/b2/velero-backup.json"); err != nil {
+			t.Fatalf("DeleteObject() error = %v", err)
+		}
+		exists, err := u.ObjectExists(context.Background(), conformanceBucket, "backups/b2/velero-backup.json")
+		if err != nil || exists {
+			t.Errorf("ObjectExists() after delete = %v, %v, want false, nil", exists, err)
+		}
+		prefixes, err := u.ListCommonPrefixes(context.Background(), conformanceBucket, "backups/", "/")
+		if err != nil {
+			t.Fatalf("ListCommonPrefixes() error = %v", err)
+		}
+		if want := []string{"backups/b1/", "backups/b10/"}; !reflect.DeepEqual(prefixes, want) {
+			t.Errorf("ListCommonPrefixes() after delete = %v, want %v", prefixes, want)
+		}
+
+		if err := u.DeleteObject(context.Background(), conformanceBucket, "backups/missing/velero-backup.json"); err != nil {
+			t.Errorf("DeleteObject() on missing object error = %v, want nil", err)
+		}
+	})
+
+	t.Run("CreateSignedURL allows downloading the object", func(t *testing.T) {
+		target := newTarget(t)
+		seed(t, target.uploader)
+
+		signed, err := target.uploader.CreateSignedURL(context.Background(), conformanceBucket, "backups/b1/b1.tar.gz", time.Minute)
+		if err != nil {
+			t.Fatalf("CreateSignedURL() error = %v", err)
+		}
+		resp, err := target.client.Get(signed)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", signed, err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("read signed url body error = %v", err)
+		}
+		if resp.StatusCode != http.StatusOK || string(data) != "backups/b1/b1.tar.gz" {
+			t.Errorf("GET signed url = %d %q, want 200 %q", resp.StatusCode, data, "backups/b1/b1.tar.gz")
+		}
+	})
+
+	t.Run("cancelled context stops every operation", func(t *testing.T) {
+		u := newTarget(t).uploader
+		seed(t, u)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := u.PutObject(ctx, conformanceBucket, "backups/b3/velero-backup.json", strings.NewReader("{}")); err == nil {
+			t.Errorf("PutObject() with cancelled context succeeded, want error")
+		}
+		if exists, _ := u.ObjectExists(context.Background(), conformanceBucket, "backups/b3/velero-backup.json"); exists {
+			t.Errorf("PutObject() with cancelled context stored the object")
+		}
+		if _, err := u.ObjectExists(ctx, conformanceBucket, "metadata/revision"); err == nil {
+			t.Errorf("ObjectExists() with cancelled context succeeded, want error")
+		}
+		if body, err := u.GetObject(ctx, conformanceBucket, "metadata/revision"); err == nil {
+			_, err = io.ReadAll(body)
+			body.Close()
+			if err == nil {
+				t.Errorf("GetObject() with cancelled context succeeded, want error")
+			}
+		}
+		if _, err := u.ListObjects(ctx, conformanceBucket, "backups/"); err == nil {
+			t.Errorf("ListObjects() with cancelled context succeeded, want error")
+		}
+		if _, err := u.ListCommonPrefixes(ctx, conformanceBucket, "backups/", "/"); err == nil {
+			t.Errorf("ListCommonPrefixes() with cancelled context succeeded, want error")
+		}
+		if err := u.DeleteObject(ctx, conformanceBucket, "metadata/revision"); err == nil {
+			t.Errorf("DeleteObject() with cancelled context succeeded, want error")
+		}
+		if _, err := u.CreateSignedURL(ctx, conformanceBucket, "metadata/revision", time.Minute); err == nil {
+			t.Errorf("CreateSignedURL() with cancelled context succeeded, want error")
+		}
+	})
+}
+
+func readConformanceObject(t *testing.T, u Uploader, key string) []byte {
+	t.Helper()
+	body, err := u.GetObject(context.Background(), conformanceBucket, key)
+	if err != nil {
+		t.Fatalf("GetObject(%q) error = %v", key, err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("read object %q error = %v", key, err)
+	}
+	return data
+}
+
+func TestUploaderConformance(t *testing.T) {
+	backends := []struct {
+		name      string
+		newTarget func(t *testing.T) conformanceTarget
+	}{
+		{
+			name: "minio",
+			newTarget: func(t *testing.T) conformanceTarget {
+				return conformanceTarget{uploader: newTestMinioUploader(t, newFakeServer(t)), client: http.DefaultClient}
+			},
+		},
+		{
+			name: "oss",
+			newTarget: func(t *testing.T) conformanceTarget {
+				return conformanceTarget{uploader: newTestOSSUploader(t, newFakeServer(t), 0), client: http.DefaultClient}
+			},
+		},
+		{
+			name: "aws",
+			newTarget: func(t *testing.T) conformanceTarget {
+				server := newFakeServer(t)
+				u, err := NewAWSUploader(server.URL, testCredentials(), "", true, false, MultipartOptions{}, ServerSideEncryption{}, logrus.New())
+				if err != nil {
+					t.Fatalf("NewAWSUploader() error = %v", err)
+				}
+				return conformanceTarget{uploader: u, client: http.DefaultClient}
+			},
+		},
+		{
+			name: "cos",
+			newTarget: func(t *testing.T) conformanceTarget {
+				server := newFakeServer(t)
+				return conformanceTarget{
+					uploader: newTestCOSUploader(t, server, MultipartOptions{}),
+					client:   &http.Client{Transport: server.virtualHostTransport()},
+				}
+			},
+		},
+		{
+			name: "obs",
+			newTarget: func(t *testing.T) conformanceTarget {
+				return conformanceTarget{uploader: newTestOBSUploader(t, newFakeServer(t), MultipartOptions{}), client: http.DefaultClient}
+			},
+		},
+		{
+			name: "filesystem",
+			newTarget: func(t *testing.T) conformanceTarget {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				addr := listener.Addr().String()
+				listener.Close()
+
+				u, err := NewFilesystemUploader(t.TempDir(), addr, "", logrus.New())
+				if err != nil {
+					t.Fatalf("NewFilesystemUploader() error = %v", err)
+				}
+				return conformanceTarget{uploader: u, client: http.DefaultClient}
+			},
+		},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			runUploaderConformance(t, backend.newTarget)
+		})
+	}
+}
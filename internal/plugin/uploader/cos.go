@@ -0,0 +1,279 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/tencentyun/cos-go-sdk-v5"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
+)
+
+// cosMaxKeys 单次列举请求返回的最大对象数
+const cosMaxKeys = 1000
+
+// COSUploader 基于腾讯云 COS SDK 实现了 Uploader 接口
+type COSUploader struct {
+	// endpoint 自定义服务地址，为空时使用 COS 官方地址
+	endpoint   *url.URL
+	region     string
+	appID      string
+	creds      *credentials.Credentials
+	httpClient *http.Client
+	// clients COS 客户端与存储桶绑定，按存储桶缓存
+	clients   sync.Map
+	multipart MultipartOptions
+	log       logrus.FieldLogger
+}
+
+// NewCOSUploader 创建一个 COSUploader 实例，appID 不为空时自动为存储桶补全 -appid 后缀。
+// COS 只支持 virtual-hosted 方式访问，自定义 endpoint 时存储桶名称会作为域名前缀
+func NewCOSUploader(endpoint string, creds *credentials.Credentials, region, appID string,
+	multipart MultipartOptions, log logrus.FieldLogger) (Uploader, error) {
+	var endpointURL *url.URL
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("parse cos endpoint %s error: %w", endpoint, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid cos endpoint %s", endpoint)
+		}
+		endpointURL = u
+	} else if region == "" {
+		return nil, fmt.Errorf("region is required when endpoint is not set")
+	}
+
+	log.Info("build cos uploader success")
+	return &COSUploader{
+		endpoint: endpointURL,
+		region:   region,
+		appID:    appID,
+		creds:    creds,
+		httpClient: &http.Client{
+			// 在签名之后记录请求
+			Transport: &cosAuthTransport{creds: creds, Transport: tracingTransport(nil)},
+		},
+		multipart: multipart.withDefaults(),
+		log:       log,
+	}, nil
+}
+
+// bucketName 返回 COS 要求的 {name}-{appid} 格式的存储桶名称
+func (c *COSUploader) bucketName(bucket string) string {
+	if c.appID == "" || strings.HasSuffix(bucket, "-"+c.appID) {
+		return bucket
+	}
+	return bucket + "-" + c.appID
+}
+
+// bucketURL 生成存储桶的访问地址
+func (c *COSUploader) bucketURL(bucket string) (*url.URL, error) {
+	name := c.bucketName(bucket)
+	if c.endpoint == nil {
+		return cos.NewBucketURL(name, c.region, true)
+	}
+
+	u := *c.endpoint
+	u.Host = name + "." + u.Host
+	return &u, nil
+}
+
+// client 获取存储桶对应的 COS 客户端
+func (c *COSUploader) client(bucket string) (*cos.Client, error) {
+	if client, ok := c.clients.Load(bucket); ok {
+		return client.(*cos.Client), nil
+	}
+
+	u, err := c.bucketURL(bucket)
+	if err != nil {
+		return nil, err
+	}
+	client, _ := c.clients.LoadOrStore(bucket, cos.NewClient(&cos.BaseURL{BucketURL: u}, c.httpClient))
+	return client.(*cos.Client), nil
+}
+
+// PutObject 将数据上传到指定的桶和键中，超过 Threshold 的对象使用分片上传
+func (c *COSUploader) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	client, err := c.client(bucket)
+	if err != nil {
+		return err
+	}
+
+	// 预读 Threshold 大小的数据，数据读完说明对象较小，直接上传
+	head, complete, err := readHead(body, c.multipart.Threshold)
+	if err != nil {
+		return err
+	}
+	if complete {
+		_, err = client.Object.Put(ctx, key, bytes.NewReader(head.Bytes()), &cos.ObjectPutOptions{ObjectPutHeaderOptions: putHeaderOptions(ctx)})
+		return err
+	}
+
+	return c.putObjectMultipart(ctx, client, bucket, key, io.MultiReader(head, body))
+}
+
+// putObjectMultipart 并行上传各分片，失败时中止分片上传
+func (c *COSUploader) putObjectMultipart(ctx context.Context, client *cos.Client, bucket, key string, body io.Reader) error {
+	imur, _, err := client.Object.InitiateMultipartUpload(ctx, key, &cos.InitiateMultipartUploadOptions{ObjectPutHeaderOptions: putHeaderOptions(ctx)})
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu    sync.Mutex
+		parts []cos.Object
+	)
+	log := c.log.WithFields(logrus.Fields{"bucket": bucket, "key": key})
+	err = uploadPartsConcurrently(ctx, body, c.multipart, log, func(ctx context.Context, number int, data []byte) error {
+		resp, err := client.Object.UploadPart(ctx, key, imur.UploadID, number, bytes.NewReader(data), nil)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		parts = append(parts, cos.Object{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		mu.Unlock()
+		return nil
+	})
+	if err == nil {
+		sort.Sort(cos.ObjectList(parts))
+		_, _, err = client.Object.CompleteMultipartUpload(ctx, key, imur.UploadID, &cos.CompleteMultipartUploadOptions{Parts: parts})
+	}
+	if err != nil {
+		// 上传超时时原 context 已失效，因此这里使用独立的 context
+		abortCtx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+		defer cancel()
+		if _, abortErr := client.Object.AbortMultipartUpload(abortCtx, key, imur.UploadID); abortErr != nil {
+			log.Warnf("abort multipart upload error: %v", abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// putHeaderOptions 返回写入对象（包括初始化分片上传）时的请求头
+func putHeaderOptions(ctx context.Context) *cos.ObjectPutHeaderOptions {
+	return &cos.ObjectPutHeaderOptions{ContentEncoding: contentEncoding(ctx)}
+}
+
+// ObjectExists 检查指定的桶和键是否存在对象
+func (c *COSUploader) ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	client, err := c.client(bucket)
+	if err != nil {
+		return false, err
+	}
+	return client.Object.IsExist(ctx, key)
+}
+
+// GetObject 获取指定桶和键的对象内容
+func (c *COSUploader) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	client, err := c.client(bucket)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Object.Get(ctx, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// ListObjects 分页列出指定桶和前缀下的所有对象键
+func (c *COSUploader) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var objects []string
+	err := c.listPages(ctx, bucket, &cos.BucketGetOptions{Prefix: prefix}, func(result *cos.BucketGetResult) {
+		for _, object := range result.Contents {
+			objects = append(objects, object.Key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// DeleteObject 删除指定桶和键的对象
+func (c *COSUploader) DeleteObject(ctx context.Context, bucket, key string) error {
+	client, err := c.client(bucket)
+	if err != nil {
+		return err
+	}
+	_, err = client.Object.Delete(ctx, key)
+	return err
+}
+
+// ListCommonPrefixes 按分隔符分页列出指定前缀下的公共前缀
+func (c *COSUploader) ListCommonPrefixes(ctx context.Context, bucket, prefix, delimiter string) ([]string, error) {
+	prefixes := make([]string, 0)
+	err := c.listPages(ctx, bucket, &cos.BucketGetOptions{Prefix: prefix, Delimiter: delimiter}, func(result *cos.BucketGetResult) {
+		prefixes = append(prefixes, result.CommonPrefixes...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// listPages 按 marker 翻页列举存储桶，每页结果交给 fn 处理
+func (c *COSUploader) listPages(ctx context.Context, bucket string, opt *cos.BucketGetOptions, fn func(result *cos.BucketGetResult)) error {
+	client, err := c.client(bucket)
+	if err != nil {
+		return err
+	}
+
+	opt.MaxKeys = cosMaxKeys
+	for {
+		result, _, err := client.Bucket.Get(ctx, opt)
+		if err != nil {
+			return err
+		}
+		fn(result)
+
+		if !result.IsTruncated {
+			return nil
+		}
+		// 未指定 delimiter 时 COS 可能不返回 NextMarker，此时以最后一个对象键作为 marker
+		marker := result.NextMarker
+		if marker == "" && len(result.Contents) > 0 {
+			marker = result.Contents[len(result.Contents)-1].Key
+		}
+		if marker == "" || marker == opt.Marker {
+			return fmt.Errorf("list bucket %s is truncated without next marker", bucket)
+		}
+		opt.Marker = marker
+	}
+}
+
+// CreateSignedURL 生成对象的预签名下载地址
+func (c *COSUploader) CreateSignedURL(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	client, err := c.client(bucket)
+	if err != nil {
+		return "", err
+	}
+	v, err := c.creds.Get(ctx)
+	if err != nil {
+		return "", err
+	}
+	// 临时凭证的安全令牌需要作为查询参数随地址一起提供
+	var opt interface{}
+	if v.SessionToken != "" {
+		opt = &cos.PresignedURLOptions{Query: &url.Values{"x-cos-security-token": {v.SessionToken}}}
+	}
+	u, err := client.Object.GetPresignedURL(ctx, http.MethodGet, key, v.AccessKeyID, v.SecretAccessKey, ttl, opt)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
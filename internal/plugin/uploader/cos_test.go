@@ -0,0 +1,176 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+const testCOSAppID = "1250000000"
+
+func newTestCOSUploader(t *testing.T, server *fakeServer, multipart MultipartOptions) Uploader {
+	u, err := NewCOSUploader(server.URL, testCredentials(), "ap-guangzhou", testCOSAppID, multipart, logrus.New())
+	if err != nil {
+		t.Fatalf("NewCOSUploader() error = %v", err)
+	}
+	// COS 只支持 virtual-hosted 方式，所有存储桶域名都需要连接到测试服务
+	u.(*COSUploader).httpClient.Transport.(*cosAuthTransport).Transport = tracingTransport(server.virtualHostTransport())
+	return u
+}
+
+func TestCOSUploaderObjectLifecycle(t *testing.T) {
+	server := newFakeServer(t)
+	u := newTestCOSUploader(t, server, MultipartOptions{})
+
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", strings.NewReader("content")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if _, ok := server.get("velero-"+testCOSAppID, "backups/b1/b1.tar.gz"); !ok {
+		t.Fatalf("object not stored under bucket with appid suffix")
+	}
+
+	exists, err := u.ObjectExists(context.Background(), "velero", "backups/b1/b1.tar.gz")
+	if err != nil || !exists {
+		t.Fatalf("ObjectExists() = %v, %v, want true, nil", exists, err)
+	}
+
+	body, err := u.GetObject(context.Background(), "velero", "backups/b1/b1.tar.gz")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "content" {
+		t.Fatalf("GetObject() content = %q, %v, want %q", data, err, "content")
+	}
+
+	if err := u.DeleteObject(context.Background(), "velero", "backups/b1/b1.tar.gz"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	exists, err = u.ObjectExists(context.Background(), "velero", "backups/b1/b1.tar.gz")
+	if err != nil || exists {
+		t.Fatalf("ObjectExists() after delete = %v, %v, want false, nil", exists, err)
+	}
+}
+
+func TestCOSUploaderMultipart(t *testing.T) {
+	server := newFakeServer(t)
+	u := newTestCOSUploader(t, server, MultipartOptions{PartSize: MinPartSize, Concurrency: 2})
+
+	data := bytes.Repeat([]byte("0123456789"), int(MinPartSize)*5/20)
+	if err := u.PutObject(context.Background(), "velero", "big", bytes.NewReader(data)); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	stored, _ := server.get("velero-"+testCOSAppID, "big")
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes, want %d", len(stored), len(data))
+	}
+	if got := server.count("PUT part"); got != 3 {
+		t.Errorf("uploaded %d parts, want 3", got)
+	}
+}
+
+func TestCOSUploaderListPagination(t *testing.T) {
+	server := newFakeServer(t)
+	u := newTestCOSUploader(t, server, MultipartOptions{})
+
+	const backups = 1500
+	for i := 0; i < backups; i++ {
+		server.put("velero-"+testCOSAppID, fmt.Sprintf("backups/b%04d/velero-backup.json", i), []byte("{}"))
+		server.put("velero-"+testCOSAppID, fmt.Sprintf("backups/b%04d/b%04d.tar.gz", i, i), []byte("tar"))
+	}
+
+	objects, err := u.ListObjects(context.Background(), "velero", "backups/")
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	if len(objects) != 2*backups {
+		t.Errorf("ListObjects() returned %d keys, want %d", len(objects), 2*backups)
+	}
+
+	prefixes, err := u.ListCommonPrefixes(context.Background(), "velero", "backups/", "/")
+	if err != nil {
+		t.Fatalf("ListCommonPrefixes() error = %v", err)
+	}
+	if len(prefixes) != backups {
+		t.Fatalf("ListCommonPrefixes() returned %d prefixes, want %d", len(prefixes), backups)
+	}
+	if prefixes[0] != "backups/b0000/" || prefixes[backups-1] != fmt.Sprintf("backups/b%04d/", backups-1) {
+		t.Errorf("ListCommonPrefixes() = [%s ... %s]", prefixes[0], prefixes[backups-1])
+	}
+}
+
+func TestCOSUploaderBucketURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		appID    string
+		bucket   string
+		want     string
+	}{
+		{
+			name:   "official endpoint",
+			appID:  testCOSAppID,
+			bucket: "velero",
+			want:   "https://velero-1250000000.cos.ap-guangzhou.myqcloud.com",
+		},
+		{
+			name:   "bucket already has appid",
+			appID:  testCOSAppID,
+			bucket: "velero-1250000000",
+			want:   "https://velero-1250000000.cos.ap-guangzhou.myqcloud.com",
+		},
+		{
+			name:     "custom endpoint",
+			endpoint: "https://cos.example.com",
+			bucket:   "velero-1250000000",
+			want:     "https://velero-1250000000.cos.example.com",
+		},
+		{
+			name:     "custom endpoint with appid",
+			endpoint: "http://cos.example.com:8080",
+			appID:    testCOSAppID,
+			bucket:   "velero",
+			want:     "http://velero-1250000000.cos.example.com:8080",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := NewCOSUploader(tt.endpoint, testCredentials(), "ap-guangzhou", tt.appID, MultipartOptions{}, logrus.New())
+			if err != nil {
+				t.Fatalf("NewCOSUploader() error = %v", err)
+			}
+			got, err := u.(*COSUploader).bucketURL(tt.bucket)
+			if err != nil {
+				t.Fatalf("bucketURL() error = %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("bucketURL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCOSUploaderCreateSignedURL(t *testing.T) {
+	server := newFakeServer(t)
+	u := newTestCOSUploader(t, server, MultipartOptions{})
+
+	signed, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/b1.tar.gz", 0)
+	if err != nil {
+		t.Fatalf("CreateSignedURL() error = %v", err)
+	}
+	want := "http://velero-" + testCOSAppID + "." + server.Listener.Addr().String() + "/backups/b1/b1.tar.gz?"
+	if !strings.HasPrefix(signed, want) {
+		t.Errorf("CreateSignedURL() = %s, unexpected object url", signed)
+	}
+	if !strings.Contains(signed, "q-sign-algorithm=sha1") {
+		t.Errorf("CreateSignedURL() = %s, missing cos signature", signed)
+	}
+}
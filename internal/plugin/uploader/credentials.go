@@ -0,0 +1,151 @@
+package uploader
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	awscreds "github.com/aws/aws-sdk-go/aws/credentials"
+	miniocreds "github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/sirupsen/logrus"
+	"github.com/tencentyun/cos-go-sdk-v5"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
+)
+
+// 以下类型将 credentials.Credentials 适配为各对象存储 SDK 的凭证接口，
+// SDK 在每次请求前获取凭证，临时凭证即将过期时自动重新获取
+
+// credentialsTimeout SDK 的凭证接口不传递 ctx 时获取凭证的超时时间，
+// 凭证链可能依次访问 Vault、STS 与元数据服务，STS 等服务无响应时不能无限期阻塞对象存储操作
+var credentialsTimeout = 2 * time.Minute
+
+// getCredentials 以 credentialsTimeout 为限获取凭证，用于不传递 ctx 的 SDK 凭证接口
+func getCredentials(get func(ctx context.Context) (credentials.Value, error)) (credentials.Value, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialsTimeout)
+	defer cancel()
+	return get(ctx)
+}
+
+// generationTracker 记录 SDK 缓存的凭证版本。minio 与 aws SDK 会缓存凭证直到 IsExpired 返回 true，
+// 凭证文件更新后版本变化，需要让 SDK 重新获取
+type generationTracker struct {
+	generation atomic.Uint64
+}
+
+// retrieve 获取凭证并记录获取前的版本，获取过程中凭证发生变化时下一次 IsExpired 返回 true
+func (g *generationTracker) retrieve(ctx context.Context, creds *credentials.Credentials) (credentials.Value, error) {
+	generation := creds.Generation()
+	v, err := creds.Get(ctx)
+	if err == nil {
+		g.generation.Store(generation)
+	}
+	return v, err
+}
+
+func (g *generationTracker) isExpired(creds *credentials.Credentials) bool {
+	return creds.IsExpired() || creds.Generation() != g.generation.Load()
+}
+
+// minioProvider 实现了 minio SDK 的 credentials.Provider 接口
+type minioProvider struct {
+	creds   *credentials.Credentials
+	tracker generationTracker
+}
+
+func (p *minioProvider) Retrieve() (miniocreds.Value, error) {
+	v, err := getCredentials(func(ctx context.Context) (credentials.Value, error) {
+		return p.tracker.retrieve(ctx, p.creds)
+	})
+	if err != nil {
+		return miniocreds.Value{}, err
+	}
+	return miniocreds.Value{
+		AccessKeyID:     v.AccessKeyID,
+		SecretAccessKey: v.SecretAccessKey,
+		SessionToken:    v.SessionToken,
+		SignerType:      miniocreds.SignatureV4,
+	}, nil
+}
+
+func (p *minioProvider) IsExpired() bool {
+	return p.tracker.isExpired(p.creds)
+}
+
+// ossCredentialsProvider 实现了 OSS SDK 的 CredentialsProvider 接口，
+// 该接口无法返回错误，获取失败时记录日志并返回空凭证，由服务端拒绝请求
+type ossCredentialsProvider struct {
+	creds *credentials.Credentials
+	log   logrus.FieldLogger
+}
+
+type ossCredentials credentials.Value
+
+func (c ossCredentials) GetAccessKeyID() string     { return c.AccessKeyID }
+func (c ossCredentials) GetAccessKeySecret() string { return c.SecretAccessKey }
+func (c ossCredentials) GetSecurityToken() string   { return c.SessionToken }
+
+func (p ossCredentialsProvider) GetCredentials() oss.Credentials {
+	v, err := getCredentials(p.creds.Get)
+	if err != nil {
+		p.log.Errorf("get oss credentials error: %v", err)
+	}
+	return ossCredentials(v)
+}
+
+// awsProvider 实现了 aws-sdk-go 的 credentials.ProviderWithContext 接口
+type awsProvider struct {
+	creds   *credentials.Credentials
+	tracker generationTracker
+}
+
+func (p *awsProvider) Retrieve() (awscreds.Value, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialsTimeout)
+	defer cancel()
+	return p.RetrieveWithContext(ctx)
+}
+
+func (p *awsProvider) RetrieveWithContext(ctx awscreds.Context) (awscreds.Value, error) {
+	v, err := p.tracker.retrieve(ctx, p.creds)
+	if err != nil {
+		return awscreds.Value{}, err
+	}
+	return awscreds.Value{
+		AccessKeyID:     v.AccessKeyID,
+		SecretAccessKey: v.SecretAccessKey,
+		SessionToken:    v.SessionToken,
+		ProviderName:    v.Source,
+	}, nil
+}
+
+func (p *awsProvider) IsExpired() bool {
+	return p.tracker.isExpired(p.creds)
+}
+
+// cosAuthTransport 在每个请求发出前使用当前凭证签名，作用与 cos.AuthorizationTransport 相同，
+// 但可以使用请求的 ctx 获取凭证并返回获取凭证的错误
+type cosAuthTransport struct {
+	creds     *credentials.Credentials
+	Transport http.RoundTripper
+}
+
+// cosAuthExpire COS 请求签名的有效期
+const cosAuthExpire = time.Hour
+
+func (t *cosAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	v, err := t.creds.Get(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	// RoundTripper 不能修改传入的请求
+	req = req.Clone(req.Context())
+	cos.AddAuthorizationHeader(v.AccessKeyID, v.SecretAccessKey, v.SessionToken, req, cos.NewAuthTime(cosAuthExpire))
+
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(req)
+}
@@ -0,0 +1,197 @@
+package uploader
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
+)
+
+// rotatingProvider 模拟 STS 临时凭证，每次 rotate 后返回新的安全令牌
+type rotatingProvider struct {
+	mu    sync.Mutex
+	token string
+}
+
+func (p *rotatingProvider) Name() string { return "rotating" }
+
+func (p *rotatingProvider) Retrieve(ctx context.Context) (credentials.Value, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return credentials.Value{
+		AccessKeyID:     "sts-ak",
+		SecretAccessKey: "sts-sk",
+		SessionToken:    p.token,
+		Expires:         time.Now().Add(time.Hour),
+		Source:          "rotating",
+	}, nil
+}
+
+func (p *rotatingProvider) rotate(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = token
+}
+
+func TestUploaderSessionTokenRefresh(t *testing.T) {
+	backends := []struct {
+		name        string
+		newUploader func(t *testing.T, server *fakeServer, creds *credentials.Credentials) (Uploader, error)
+	}{
+		{
+			name: "minio",
+			newUploader: func(t *testing.T, server *fakeServer, creds *credentials.Credentials) (Uploader, error) {
+				return NewMinioUploader(server.URL, creds, false, "us-east-1", MultipartOptions{}, ServerSideEncryption{}, logrus.New())
+			},
+		},
+		{
+			name: "oss",
+			newUploader: func(t *testing.T, server *fakeServer, creds *credentials.Credentials) (Uploader, error) {
+				return NewOSSUploader(server.URL, creds, "cn-hangzhou", true, MultipartOptions{}, ServerSideEncryption{}, 0, logrus.New())
+			},
+		},
+		{
+			name: "aws",
+			newUploader: func(t *testing.T, server *fakeServer, creds *credentials.Credentials) (Uploader, error) {
+				return NewAWSUploader(server.URL, creds, "", true, false, MultipartOptions{}, ServerSideEncryption{}, logrus.New())
+			},
+		},
+		{
+			name: "cos",
+			newUploader: func(t *testing.T, server *fakeServer, creds *credentials.Credentials) (Uploader, error) {
+				u, err := NewCOSUploader(server.URL, creds, "ap-guangzhou", testCOSAppID, MultipartOptions{}, logrus.New())
+				if err == nil {
+					u.(*COSUploader).httpClient.Transport.(*cosAuthTransport).Transport = server.virtualHostTransport()
+				}
+				return u, err
+			},
+		},
+		{
+			name: "obs",
+			newUploader: func(t *testing.T, server *fakeServer, creds *credentials.Credentials) (Uploader, error) {
+				return NewOBSUploader(server.URL, creds, "cn-north-4", true, false, MultipartOptions{}, logrus.New())
+			},
+		},
+	}
+	rotations := []struct {
+		name   string
+		rotate func(t *testing.T, creds *credentials.Credentials)
+	}{
+		{
+			// 凭证进入过期窗口后，下一个请求应当使用新的安全令牌
+			name: "expiry",
+			rotate: func(t *testing.T, creds *credentials.Credentials) {
+				creds.ExpiryWindow = 2 * time.Hour
+			},
+		},
+		{
+			// 凭证文件更新后立即替换凭证，SDK 缓存的凭证也应当失效
+			name: "reload",
+			rotate: func(t *testing.T, creds *credentials.Credentials) {
+				if err := creds.Refresh(context.Background()); err != nil {
+					t.Fatalf("Refresh() error = %v", err)
+				}
+			},
+		},
+	}
+	for _, tt := range backends {
+		for _, rotation := range rotations {
+			t.Run(tt.name+"/"+rotation.name, func(t *testing.T) {
+				server := newFakeServer(t)
+				provider := &rotatingProvider{token: "token-1"}
+				creds := credentials.NewCredentials(provider, logrus.New())
+				u, err := tt.newUploader(t, server, creds)
+				if err != nil {
+					t.Fatalf("create uploader error = %v", err)
+				}
+				ctx := context.Background()
+
+				if err := u.PutObject(ctx, "velero", "backups/b1/first", strings.NewReader("first")); err != nil {
+					t.Fatalf("PutObject() error = %v", err)
+				}
+
+				provider.rotate("token-2")
+				rotation.rotate(t, creds)
+				if err := u.PutObject(ctx, "velero", "backups/b1/second", strings.NewReader("second")); err != nil {
+					t.Fatalf("PutObject() after rotation error = %v", err)
+				}
+
+				tokens := server.securityTokens()
+				if len(tokens) < 2 {
+					t.Fatalf("server received %d requests, want at least 2", len(tokens))
+				}
+				if tokens[0] != "token-1" {
+					t.Errorf("first request security token = %q, want token-1", tokens[0])
+				}
+				if last := tokens[len(tokens)-1]; last != "token-2" {
+					t.Errorf("last request security token = %q, want token-2", last)
+				}
+			})
+		}
+	}
+}
+
+// hangingProvider 模拟无响应的凭证服务，直到 ctx 结束才返回
+type hangingProvider struct{}
+
+func (hangingProvider) Name() string { return "hanging" }
+
+func (hangingProvider) Retrieve(ctx context.Context) (credentials.Value, error) {
+	<-ctx.Done()
+	return credentials.Value{}, ctx.Err()
+}
+
+func TestCredentialAdaptersTimeout(t *testing.T) {
+	prev := credentialsTimeout
+	credentialsTimeout = 50 * time.Millisecond
+	defer func() { credentialsTimeout = prev }()
+
+	tests := []struct {
+		name     string
+		retrieve func(creds *credentials.Credentials) error
+	}{
+		{
+			name: "minio",
+			retrieve: func(creds *credentials.Credentials) error {
+				_, err := (&minioProvider{creds: creds}).Retrieve()
+				return err
+			},
+		},
+		{
+			name: "oss",
+			retrieve: func(creds *credentials.Credentials) error {
+				if v := (ossCredentialsProvider{creds: creds, log: logrus.New()}).GetCredentials(); v.GetAccessKeyID() != "" {
+					return nil
+				}
+				return context.DeadlineExceeded
+			},
+		},
+		{
+			name: "aws",
+			retrieve: func(creds *credentials.Credentials) error {
+				_, err := (&awsProvider{creds: creds}).Retrieve()
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := credentials.NewCredentials(hangingProvider{}, logrus.New())
+			done := make(chan error, 1)
+			go func() { done <- tt.retrieve(creds) }()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Errorf("retrieve from a hanging provider succeeded, want error")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("retrieve from a hanging provider did not time out")
+			}
+		})
+	}
+}
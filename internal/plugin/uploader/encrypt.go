@@ -0,0 +1,276 @@
+package uploader
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 客户端加密对象的格式：
+//
+//	magic（8 字节）| 头部长度（4 字节，大端）| 头部 JSON | 密文块...
+//
+// 明文按 encryptChunkSize 切块，每块使用 AES-256-GCM 单独加密，nonce 由头部中的随机前缀、
+// 块序号与是否为最后一块组成，头部作为附加数据参与每一块的认证，因此块被篡改、调换或截断都能发现。
+// 最后一块的明文总是短于 encryptChunkSize（可以为空），以此区分最后一块
+const (
+	encryptMagic         = "VOSPENC1"
+	encryptAlgorithm     = "AES-256-GCM-CHUNKED"
+	encryptChunkSize     = 64 << 10
+	encryptNoncePrefix   = 7
+	encryptMaxHeaderSize = 64 << 10
+	dataKeySize          = 32
+)
+
+// ErrSignedURLEncrypted 客户端加密的对象无法通过预签名地址直接下载
+var ErrSignedURLEncrypted = errors.New("signed urls are not supported with client side encryption, objects are stored encrypted")
+
+// encryptHeader 记录解密对象需要的信息，数据密钥由主密钥加密后保存
+type encryptHeader struct {
+	Version     int    `json:"version"`
+	Algorithm   string `json:"algorithm"`
+	ChunkSize   int    `json:"chunkSize"`
+	KeyID       string `json:"keyId"`
+	WrappedKey  []byte `json:"wrappedKey"`
+	NoncePrefix []byte `json:"noncePrefix"`
+}
+
+// EncryptingUploader 在上传前加密对象、读取时解密对象，可以包装任意 Uploader。
+// 每个对象使用随机生成的数据密钥加密，数据密钥由 KeyWrapper 管理的主密钥加密后保存在对象头部
+type EncryptingUploader struct {
+	Uploader
+	keys KeyWrapper
+	log  logrus.FieldLogger
+}
+
+// NewEncryptingUploader 创建一个 EncryptingUploader
+func NewEncryptingUploader(inner Uploader, keys KeyWrapper, log logrus.FieldLogger) *EncryptingUploader {
+	return &EncryptingUploader{Uploader: inner, keys: keys, log: log}
+}
+
+// PutObject 以流的方式加密 body 并上传，内存占用与对象大小无关
+func (e *EncryptingUploader) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	dataKey := make([]byte, dataKeySize)
+	header := encryptHeader{Version: 1, Algorithm: encryptAlgorithm, ChunkSize: encryptChunkSize, KeyID: e.keys.KeyID(),
+		NoncePrefix: make([]byte, encryptNoncePrefix)}
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	if _, err := rand.Read(header.NoncePrefix); err != nil {
+		return err
+	}
+	wrapped, err := e.keys.WrapKey(ctx, dataKey)
+	if err != nil {
+		return fmt.Errorf("wrap data key of %s error: %w", key, err)
+	}
+	header.WrappedKey = wrapped
+
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	aead, err := newChunkAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(encryptChunks(pw, body, aead, header.NoncePrefix, headerData))
+	}()
+	// 密文无法按外层设置的 Content-Encoding 解压
+	err = e.Uploader.PutObject(WithContentEncoding(ctx, ""), bucket, key, pr)
+	// 上传提前失败时让加密的 goroutine 退出
+	pr.CloseWithError(err)
+	return err
+}
+
+// encryptChunks 写入对象头部，再逐块加密 body
+func encryptChunks(w io.Writer, body io.Reader, aead cipher.AEAD, noncePrefix, headerData []byte) error {
+	prefix := make([]byte, len(encryptMagic)+4)
+	copy(prefix, encryptMagic)
+	binary.BigEndian.PutUint32(prefix[len(encryptMagic):], uint32(len(headerData)))
+	if _, err := w.Write(append(prefix, headerData...)); err != nil {
+		return err
+	}
+
+	plain := make([]byte, encryptChunkSize)
+	sealed := make([]byte, 0, encryptChunkSize+aead.Overhead())
+	for index := uint32(0); ; index++ {
+		n, err := io.ReadFull(body, plain)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		sealed = aead.Seal(sealed[:0], chunkNonce(noncePrefix, index, last), plain[:n], headerData)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if index == ^uint32(0) {
+			return fmt.Errorf("object is too large to encrypt")
+		}
+	}
+}
+
+// GetObject 读取并解密对象。没有加密头部的对象是启用加密之前写入的，原样返回
+func (e *EncryptingUploader) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	body, err := e.Uploader.GetObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReaderSize(body, encryptChunkSize)
+	magic, err := reader.Peek(len(encryptMagic))
+	if err != nil && err != io.EOF {
+		body.Close()
+		return nil, err
+	}
+	if string(magic) != encryptMagic {
+		e.log.Warnf("object [%s/%s] is not encrypted, return it as is", bucket, key)
+		return readCloser{Reader: reader, Closer: body}, nil
+	}
+
+	header, headerData, err := readEncryptHeader(reader)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("read encryption header of %s error: %w", key, err)
+	}
+	dataKey, err := e.keys.UnwrapKey(ctx, header.KeyID, header.WrappedKey)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("unwrap data key of %s error: %w", key, err)
+	}
+	aead, err := newChunkAEAD(dataKey)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return &decryptReader{
+		src:         reader,
+		closer:      body,
+		aead:        aead,
+		noncePrefix: header.NoncePrefix,
+		headerData:  headerData,
+		sealed:      make([]byte, header.ChunkSize+aead.Overhead()),
+	}, nil
+}
+
+// CreateSignedURL 加密的对象只能通过 GetObject 解密，预签名地址下载到的是密文，因此返回 ErrSignedURLEncrypted
+func (e *EncryptingUploader) CreateSignedURL(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	return "", ErrSignedURLEncrypted
+}
+
+// readEncryptHeader 读取 magic 之后的对象头部，返回解析后的头部与原始内容
+func readEncryptHeader(r io.Reader) (encryptHeader, []byte, error) {
+	var header encryptHeader
+	prefix := make([]byte, len(encryptMagic)+4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return header, nil, err
+	}
+	size := binary.BigEndian.Uint32(prefix[len(encryptMagic):])
+	if size > encryptMaxHeaderSize {
+		return header, nil, fmt.Errorf("header size %d exceeds %d", size, encryptMaxHeaderSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return header, nil, err
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return header, nil, err
+	}
+	if header.Version != 1 || header.Algorithm != encryptAlgorithm {
+		return header, nil, fmt.Errorf("unsupported encryption %s version %d", header.Algorithm, header.Version)
+	}
+	if header.ChunkSize <= 0 || header.ChunkSize > encryptChunkSize<<4 || len(header.NoncePrefix) != encryptNoncePrefix {
+		return header, nil, fmt.Errorf("invalid encryption header")
+	}
+	return header, data, nil
+}
+
+// decryptReader 逐块解密对象，读到被篡改或截断的数据时返回错误
+type decryptReader struct {
+	src         io.Reader
+	closer      io.Closer
+	aead        cipher.AEAD
+	noncePrefix []byte
+	headerData  []byte
+
+	sealed []byte
+	plain  []byte
+	index  uint32
+	done   bool
+	err    error
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.nextChunk()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// nextChunk 读取并解密下一块，不满一块的是最后一块，最后一块之后还有数据说明对象被篡改
+func (d *decryptReader) nextChunk() error {
+	n, err := io.ReadFull(d.src, d.sealed)
+	last := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !last {
+		return err
+	}
+	plain, err := d.aead.Open(d.sealed[:0], chunkNonce(d.noncePrefix, d.index, last), d.sealed[:n], d.headerData)
+	if err != nil {
+		// 对象在块边界处被截断时，读到的最后一块为空，同样无法通过认证
+		return fmt.Errorf("decrypt chunk %d error: object is corrupted or truncated", d.index)
+	}
+	d.plain, d.done = plain, last
+	d.index++
+	return nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.closer.Close()
+}
+
+// chunkNonce 返回第 index 块的 nonce：随机前缀 | 块序号 | 最后一块标记
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptNoncePrefix:], index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newChunkAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readCloser 组合 Reader 与 Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
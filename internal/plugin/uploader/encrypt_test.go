@@ -0,0 +1,280 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestKeyWrapper(t *testing.T, b byte) *LocalKeyWrapper {
+	t.Helper()
+	keys, err := NewLocalKeyWrapper(bytes.Repeat([]byte{b}, MasterKeySize))
+	if err != nil {
+		t.Fatalf("NewLocalKeyWrapper() error = %v", err)
+	}
+	return keys
+}
+
+// newTestEncryptingUploader 返回包装了本地目录的 EncryptingUploader 及目录路径，便于检查落盘的密文
+func newTestEncryptingUploader(t *testing.T, keys KeyWrapper) (*EncryptingUploader, string) {
+	t.Helper()
+	root := t.TempDir()
+	inner, err := NewFilesystemUploader(root, "", "", logrus.New())
+	if err != nil {
+		t.Fatalf("NewFilesystemUploader() error = %v", err)
+	}
+	return NewEncryptingUploader(inner, keys, logrus.New()), root
+}
+
+func readObject(u Uploader, key string) ([]byte, error) {
+	body, err := u.GetObject(context.Background(), "velero", key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func TestEncryptingUploaderRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, encryptChunkSize - 1, encryptChunkSize, encryptChunkSize + 1, 3 * encryptChunkSize}
+	u, root := newTestEncryptingUploader(t, newTestKeyWrapper(t, 'm'))
+	for _, size := range sizes {
+		content := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+		if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+			t.Fatalf("PutObject(%d bytes) error = %v", size, err)
+		}
+		stored, err := os.ReadFile(filepath.Join(root, "velero", "backups", "b1", "b1.tar.gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(stored, []byte(encryptMagic)) {
+			t.Errorf("stored object of %d bytes does not start with %q", size, encryptMagic)
+		}
+		if size > 16 && bytes.Contains(stored, content) {
+			t.Errorf("stored object of %d bytes contains the plaintext", size)
+		}
+		got, err := readObject(u, "backups/b1/b1.tar.gz")
+		if err != nil || !bytes.Equal(got, content) {
+			t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, size)
+		}
+	}
+}
+
+func TestEncryptingUploaderMultipart(t *testing.T) {
+	server := newFakeServer(t)
+	inner, err := NewMinioUploader(server.URL, testCredentials(), false, "us-east-1", sseMultipart, ServerSideEncryption{}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewMinioUploader() error = %v", err)
+	}
+	u := NewEncryptingUploader(inner, newTestKeyWrapper(t, 'm'), logrus.New())
+
+	content := bytes.Repeat([]byte("x"), int(MinPartSize)*2+1)
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if server.count("POST initiate") == 0 {
+		t.Errorf("encrypted object was not uploaded in parts")
+	}
+	got, err := readObject(u, "backups/b1/b1.tar.gz")
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(content))
+	}
+}
+
+func TestEncryptingUploaderDetectsTampering(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 2*encryptChunkSize+10)
+	tests := []struct {
+		name   string
+		modify func(stored []byte) []byte
+	}{
+		{
+			name: "flipped byte",
+			modify: func(stored []byte) []byte {
+				stored[len(stored)-encryptChunkSize] ^= 1
+				return stored
+			},
+		},
+		{
+			name: "truncated at chunk boundary",
+			modify: func(stored []byte) []byte {
+				// 去掉最后一块，剩下的内容恰好在块边界结束
+				return stored[:len(stored)-(10+16)]
+			},
+		},
+		{
+			name: "truncated in chunk",
+			modify: func(stored []byte) []byte {
+				return stored[:len(stored)-100]
+			},
+		},
+		{
+			name: "appended data",
+			modify: func(stored []byte) []byte {
+				return append(stored, 0)
+			},
+		},
+		{
+			name: "modified header",
+			modify: func(stored []byte) []byte {
+				return bytes.Replace(stored, []byte(`"chunkSize":65536`), []byte(`"chunkSize":65535`), 1)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, root := newTestEncryptingUploader(t, newTestKeyWrapper(t, 'm'))
+			if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			path := filepath.Join(root, "velero", "backups", "b1", "b1.tar.gz")
+			stored, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.modify(stored), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := readObject(u, "backups/b1/b1.tar.gz"); err == nil {
+				t.Errorf("GetObject() = %d bytes, want error", len(got))
+			}
+		})
+	}
+}
+
+func TestEncryptingUploaderReadsLegacyObjects(t *testing.T) {
+	u, root := newTestEncryptingUploader(t, newTestKeyWrapper(t, 'm'))
+	for _, content := range []string{"", "VOSP", "legacy backup written before encryption was enabled"} {
+		if err := u.Uploader.PutObject(context.Background(), "velero", "backups/b1/legacy", strings.NewReader(content)); err != nil {
+			t.Fatalf("PutObject() error = %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(root, "velero", "backups", "b1", "legacy")); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readObject(u, "backups/b1/legacy")
+		if err != nil || string(got) != content {
+			t.Errorf("GetObject() = %q, %v, want %q", got, err, content)
+		}
+	}
+}
+
+func TestEncryptingUploaderWrongMasterKey(t *testing.T) {
+	u, root := newTestEncryptingUploader(t, newTestKeyWrapper(t, 'm'))
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", strings.NewReader("content")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	inner, err := NewFilesystemUploader(root, "", "", logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := NewEncryptingUploader(inner, newTestKeyWrapper(t, 'o'), logrus.New())
+	if _, err := readObject(other, "backups/b1/b1.tar.gz"); err == nil {
+		t.Errorf("GetObject() with another master key succeeded, want error")
+	}
+}
+
+func TestEncryptingUploaderSignedURL(t *testing.T) {
+	u, _ := newTestEncryptingUploader(t, newTestKeyWrapper(t, 'm'))
+	if _, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/b1.tar.gz", time.Minute); !errors.Is(err, ErrSignedURLEncrypted) {
+		t.Errorf("CreateSignedURL() error = %v, want %v", err, ErrSignedURLEncrypted)
+	}
+}
+
+func TestNewLocalKeyWrapperFromFile(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), MasterKeySize)
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "raw", content: string(key)},
+		{name: "base64", content: base64.StdEncoding.EncodeToString(key) + "\n"},
+		{name: "short", content: "short", wantErr: true},
+	}
+	want, _ := NewLocalKeyWrapper(key)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "key")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			keys, err := NewLocalKeyWrapperFromFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewLocalKeyWrapperFromFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && keys.KeyID() != want.KeyID() {
+				t.Errorf("KeyID() = %s, want %s", keys.KeyID(), want.KeyID())
+			}
+		})
+	}
+}
+
+// fakeKMS 模拟 KMS 的 Encrypt 与 Decrypt 接口，密文为 "keyId|明文"
+func fakeKMS(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			KeyId          string
+			Plaintext      []byte
+			CiphertextBlob []byte
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"KeyId":          input.KeyId,
+				"CiphertextBlob": append([]byte(input.KeyId+"|"), input.Plaintext...),
+			})
+		case "TrentService.Decrypt":
+			keyID, plain, ok := bytes.Cut(input.CiphertextBlob, []byte("|"))
+			if !ok || string(keyID) != input.KeyId {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"InvalidCiphertextException","message":"invalid ciphertext"}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"KeyId": input.KeyId, "Plaintext": plain})
+		default:
+			http.Error(w, "unknown target", http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestKMSKeyWrapper(t *testing.T) {
+	server := fakeKMS(t)
+	keys, err := NewKMSKeyWrapper("alias/velero", server.URL, "us-east-1", testCredentials())
+	if err != nil {
+		t.Fatalf("NewKMSKeyWrapper() error = %v", err)
+	}
+	u, _ := newTestEncryptingUploader(t, keys)
+	content := bytes.Repeat([]byte("x"), encryptChunkSize+1)
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	got, err := readObject(u, "backups/b1/b1.tar.gz")
+	if err != nil || !bytes.Equal(got, content) {
+		t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(content))
+	}
+
+	if _, err := keys.UnwrapKey(context.Background(), "kms:alias/other", []byte("alias/velero|key")); err == nil {
+		t.Errorf("UnwrapKey() with another kms key succeeded, want error")
+	}
+	if _, err := keys.UnwrapKey(context.Background(), newTestKeyWrapper(t, 'm').KeyID(), nil); err == nil {
+		t.Errorf("UnwrapKey() of a local key succeeded, want error")
+	}
+}
@@ -0,0 +1,704 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
+)
+
+// fakeServer 是一个内存中的 S3 协议存储服务，同时支持 path style 与 virtual-hosted 寻址，
+// COS、OSS 与 S3 的对象、列举和分片接口格式基本一致，可以共用
+type fakeServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]map[string][]byte
+	uploads map[string]*fakeUpload
+	nextID  int
+	// requests 记录每种请求的次数，key 为 "METHOD 操作"
+	requests map[string]int
+	// tokens 按顺序记录每个请求携带的临时凭证安全令牌
+	tokens []string
+	// headers 记录每种请求最近一次的请求头，key 与 requests 相同
+	headers map[string]http.Header
+	// customerKeys 记录 SSE-C 加密对象的密钥 MD5，读取时需要提供同一个密钥
+	customerKeys map[string]string
+	// metadata 记录对象的用户元数据请求头，读取时原样返回
+	metadata map[string]http.Header
+	// tags 记录对象标签的 XML
+	tags map[string][]byte
+	// crc64 记录写入对象时的 CRC64，读取时与 OSS 一样返回写入时的值
+	crc64 map[string]string
+	// encodings 记录对象的 Content-Encoding，读取时原样返回
+	encodings map[string]string
+	// failParts 上传这些编号的分片时返回对应的状态码，模拟上传中途失败
+	failParts map[int]int
+}
+
+type fakeUpload struct {
+	bucket      string
+	key         string
+	parts       map[int][]byte
+	customerKey string
+	metadata    http.Header
+	encoding    string
+}
+
+type fakeError struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+type fakeContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type fakeCommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type fakeListResult struct {
+	XMLName               xml.Name           `xml:"ListBucketResult"`
+	Name                  string             `xml:"Name"`
+	Prefix                string             `xml:"Prefix"`
+	Delimiter             string             `xml:"Delimiter,omitempty"`
+	Marker                string             `xml:"Marker,omitempty"`
+	NextMarker            string             `xml:"NextMarker,omitempty"`
+	ContinuationToken     string             `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string             `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int                `xml:"KeyCount"`
+	MaxKeys               int                `xml:"MaxKeys"`
+	IsTruncated           bool               `xml:"IsTruncated"`
+	Contents              []fakeContent      `xml:"Contents"`
+	CommonPrefixes        []fakeCommonPrefix `xml:"CommonPrefixes"`
+}
+
+// testCredentials 返回测试使用的固定凭证
+func testCredentials() *credentials.Credentials {
+	return credentials.NewStaticCredentials("ak", "sk", "")
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	f := newUnstartedFakeServer()
+	f.Start()
+	t.Cleanup(f.Close)
+	return f
+}
+
+// newFakeTLSServer 返回使用 https 的 fakeServer，aws-sdk-go 只允许通过 https 发送 SSE-C 密钥
+func newFakeTLSServer(t *testing.T) *fakeServer {
+	f := newUnstartedFakeServer()
+	f.StartTLS()
+	t.Cleanup(f.Close)
+	return f
+}
+
+func newUnstartedFakeServer() *fakeServer {
+	f := &fakeServer{
+		objects:      make(map[string]map[string][]byte),
+		uploads:      make(map[string]*fakeUpload),
+		requests:     make(map[string]int),
+		headers:      make(map[string]http.Header),
+		customerKeys: make(map[string]string),
+		metadata:     make(map[string]http.Header),
+		tags:         make(map[string][]byte),
+		crc64:        make(map[string]string),
+		encodings:    make(map[string]string),
+		failParts:    make(map[int]int),
+	}
+	f.Server = httptest.NewUnstartedServer(http.HandlerFunc(f.handle))
+	return f
+}
+
+// put 直接写入对象，用于准备测试数据
+func (f *fakeServer) put(bucket, key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.objects[bucket] == nil {
+		f.objects[bucket] = make(map[string][]byte)
+	}
+	f.objects[bucket][key] = data
+	delete(f.crc64, bucket+"/"+key)
+	delete(f.encodings, bucket+"/"+key)
+}
+
+// corrupt 修改对象内容但保留写入时记录的元数据、标签与 CRC64，模拟存储中损坏的对象
+func (f *fakeServer) corrupt(bucket, key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data := append([]byte(nil), f.objects[bucket][key]...)
+	data[len(data)/2] ^= 0xff
+	f.objects[bucket][key] = data
+}
+
+// failPart 使编号为 number 的分片上传返回 status 错误，status 为 0 时恢复正常
+func (f *fakeServer) failPart(number int, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failParts[number] = status
+}
+
+// pendingUploads 返回未完成也未中止的分片上传 ID
+func (f *fakeServer) pendingUploads() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(f.uploads))
+	for id := range f.uploads {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// startUpload 直接创建一个分片上传，模拟其他客户端正在上传同一个对象
+func (f *fakeServer) startUpload(bucket, key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	id := fmt.Sprintf("upload-%d", f.nextID)
+	f.uploads[id] = &fakeUpload{bucket: bucket, key: key, parts: make(map[int][]byte), metadata: make(http.Header)}
+	return id
+}
+
+// dropUpload 删除分片上传，模拟服务端已经清理的上传
+func (f *fakeServer) dropUpload(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.uploads, id)
+}
+
+// get 直接读取对象，用于校验测试结果
+func (f *fakeServer) get(bucket, key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[bucket][key]
+	return data, ok
+}
+
+// count 返回某种请求的次数
+func (f *fakeServer) count(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[op]
+}
+
+// securityTokens 返回各请求携带的安全令牌
+func (f *fakeServer) securityTokens() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.tokens...)
+}
+
+// record 记录一次请求及其请求头，调用方需持有 mu
+func (f *fakeServer) record(op string, r *http.Request) {
+	f.requests[op]++
+	f.headers[op] = r.Header.Clone()
+}
+
+// header 返回某种请求最近一次的请求头
+func (f *fakeServer) header(op string) http.Header {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.headers[op]
+}
+
+// sseCustomerKeyMD5 返回请求携带的 SSE-C 密钥 MD5
+func sseCustomerKeyMD5(h http.Header) string {
+	if md5 := h.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"); md5 != "" {
+		return md5
+	}
+	return h.Get("X-Oss-Server-Side-Encryption-Customer-Key-Md5")
+}
+
+// userMetadata 返回请求中的用户元数据请求头
+func userMetadata(h http.Header) http.Header {
+	metadata := make(http.Header)
+	for k, v := range h {
+		if lower := strings.ToLower(k); strings.HasPrefix(lower, "x-amz-meta-") || strings.HasPrefix(lower, "x-oss-meta-") {
+			metadata[k] = v
+		}
+	}
+	return metadata
+}
+
+// validContentMD5 检查请求体与 Content-MD5 是否一致，未携带 Content-MD5 时不检查
+func validContentMD5(h http.Header, body []byte) bool {
+	want := h.Get("Content-Md5")
+	sum := md5.Sum(body)
+	return want == "" || want == base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// writeObjectHeaders 写入读取对象时返回的请求头
+func (f *fakeServer) writeObjectHeaders(w http.ResponseWriter, bucket, key string, data []byte) {
+	for k, v := range f.metadata[bucket+"/"+key] {
+		w.Header()[k] = v
+	}
+	if tags := f.tags[bucket+"/"+key]; tags != nil {
+		w.Header().Set("X-Amz-Tagging-Count", strconv.Itoa(bytes.Count(tags, []byte("<Tag>"))))
+	}
+	if encoding := f.encodings[bucket+"/"+key]; encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	setFakeChecksums(w, data)
+	if crc, ok := f.crc64[bucket+"/"+key]; ok {
+		w.Header().Set("x-oss-hash-crc64ecma", crc)
+	}
+}
+
+// securityTokenHeaders 各对象存储传递安全令牌使用的请求头
+var securityTokenHeaders = []string{"X-Amz-Security-Token", "X-Oss-Security-Token", "X-Cos-Security-Token", "X-Obs-Security-Token"}
+
+func (f *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil && strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body, err = decodeAWSChunked(body)
+	}
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if host := strings.TrimSuffix(r.Host, "."+f.Listener.Addr().String()); host != r.Host {
+		bucket, key = host, path
+	}
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	token := ""
+	for _, header := range securityTokenHeaders {
+		if token = r.Header.Get(header); token != "" {
+			break
+		}
+	}
+	f.tokens = append(f.tokens, token)
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && query.Has("location"):
+		f.record("GET location", r)
+		writeFakeXML(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+		}{})
+	case key == "" && r.Method == http.MethodGet && query.Has("uploads"):
+		f.record("GET uploads", r)
+		f.listUploads(w, bucket, query.Get("prefix"))
+	case key == "" && r.Method == http.MethodGet:
+		f.record("GET list", r)
+		f.list(w, bucket, query)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.record("POST initiate", r)
+		f.nextID++
+		id := fmt.Sprintf("upload-%d", f.nextID)
+		f.uploads[id] = &fakeUpload{bucket: bucket, key: key, parts: make(map[int][]byte), customerKey: sseCustomerKeyMD5(r.Header),
+			metadata: userMetadata(r.Header), encoding: r.Header.Get("Content-Encoding")}
+		writeFakeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string   `xml:"Bucket"`
+			Key      string   `xml:"Key"`
+			UploadID string   `xml:"UploadId"`
+		}{Bucket: bucket, Key: key, UploadID: id})
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.record("PUT part", r)
+		upload, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		if upload.customerKey != sseCustomerKeyMD5(r.Header) {
+			writeFakeError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		if !validContentMD5(r.Header, body) {
+			writeFakeError(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		if status := f.failParts[number]; status != 0 {
+			writeFakeError(w, status, http.StatusText(status))
+			return
+		}
+		upload.parts[number] = body
+		setFakeChecksums(w, body)
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		f.record("GET parts", r)
+		f.listParts(w, query)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.record("POST complete", r)
+		f.complete(w, bucket, key, query.Get("uploadId"), body)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.record("DELETE abort", r)
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && query.Has("tagging"):
+		f.record("PUT tagging", r)
+		if _, ok := f.objects[bucket][key]; !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.tags[bucket+"/"+key] = body
+	case r.Method == http.MethodGet && query.Has("tagging"):
+		f.record("GET tagging", r)
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write(f.tags[bucket+"/"+key])
+	case r.Method == http.MethodPut && query.Has("metadata"):
+		// OBS 修改对象元数据的接口，这里只支持替换 Content-Encoding
+		f.record("PUT metadata", r)
+		if _, ok := f.objects[bucket][key]; !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.encodings[bucket+"/"+key] = r.Header.Get("Content-Encoding")
+	case r.Method == http.MethodPut:
+		f.record("PUT object", r)
+		if !validContentMD5(r.Header, body) {
+			writeFakeError(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		if f.objects[bucket] == nil {
+			f.objects[bucket] = make(map[string][]byte)
+		}
+		f.objects[bucket][key] = body
+		f.customerKeys[bucket+"/"+key] = sseCustomerKeyMD5(r.Header)
+		f.metadata[bucket+"/"+key] = userMetadata(r.Header)
+		f.encodings[bucket+"/"+key] = r.Header.Get("Content-Encoding")
+		f.crc64[bucket+"/"+key] = fakeCRC64(body)
+		delete(f.tags, bucket+"/"+key)
+		setFakeChecksums(w, body)
+	case r.Method == http.MethodHead:
+		f.record("HEAD object", r)
+		data, ok := f.objects[bucket][key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if f.customerKeys[bucket+"/"+key] != sseCustomerKeyMD5(r.Header) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.writeObjectHeaders(w, bucket, key, data)
+	case r.Method == http.MethodGet:
+		f.record("GET object", r)
+		data, ok := f.objects[bucket][key]
+		if !ok {
+			writeFakeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if f.customerKeys[bucket+"/"+key] != sseCustomerKeyMD5(r.Header) {
+			writeFakeError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		f.writeObjectHeaders(w, bucket, key, data)
+		// 写入对象内容时释放锁，客户端在读完对象之前可能发起其他请求（如读取对象标签）
+		f.mu.Unlock()
+		_, _ = w.Write(data)
+		f.mu.Lock()
+	case r.Method == http.MethodDelete:
+		f.record("DELETE object", r)
+		delete(f.objects[bucket], key)
+		delete(f.customerKeys, bucket+"/"+key)
+		delete(f.metadata, bucket+"/"+key)
+		delete(f.tags, bucket+"/"+key)
+		delete(f.crc64, bucket+"/"+key)
+		delete(f.encodings, bucket+"/"+key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// list 同时支持 V1（marker）与 V2（list-type=2 + continuation-token）两种列举协议
+func (f *fakeServer) list(w http.ResponseWriter, bucket string, query map[string][]string) {
+	get := func(name string) string {
+		if v := query[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	prefix, delimiter := get("prefix"), get("delimiter")
+	v2 := get("list-type") == "2"
+
+	maxKeys := 1000
+	if v := get("max-keys"); v != "" {
+		maxKeys, _ = strconv.Atoi(v)
+	}
+	marker := get("marker")
+	if v2 {
+		marker = get("continuation-token")
+		if marker == "" {
+			marker = get("start-after")
+		}
+	}
+
+	keys := make([]string, 0, len(f.objects[bucket]))
+	for key := range f.objects[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := fakeListResult{Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys}
+	seen := make(map[string]bool)
+	last := ""
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= marker {
+			continue
+		}
+		entry := key
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if seen[entry] || (entry != key && entry <= marker) {
+			continue
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		seen[entry] = true
+		result.KeyCount++
+		if entry != key {
+			result.CommonPrefixes = append(result.CommonPrefixes, fakeCommonPrefix{Prefix: entry})
+		} else {
+			data := f.objects[bucket][key]
+			result.Contents = append(result.Contents, fakeContent{
+				Key:          key,
+				LastModified: time.Now().UTC().Format(time.RFC3339),
+				ETag:         fakeETag(data),
+				Size:         len(data),
+				StorageClass: "STANDARD",
+			})
+		}
+		last = entry
+	}
+
+	if result.IsTruncated {
+		if v2 {
+			result.ContinuationToken = get("continuation-token")
+			result.NextContinuationToken = last
+		} else if delimiter != "" {
+			result.Marker = marker
+			result.NextMarker = last
+		}
+	}
+	writeFakeXML(w, result)
+}
+
+// listUploads 返回存储桶中键以 prefix 开头的未完成分片上传，不分页
+func (f *fakeServer) listUploads(w http.ResponseWriter, bucket, prefix string) {
+	type fakeMultipartUpload struct {
+		Key      string `xml:"Key"`
+		UploadID string `xml:"UploadId"`
+	}
+	result := struct {
+		XMLName xml.Name              `xml:"ListMultipartUploadsResult"`
+		Bucket  string                `xml:"Bucket"`
+		Prefix  string                `xml:"Prefix"`
+		Uploads []fakeMultipartUpload `xml:"Upload"`
+	}{Bucket: bucket, Prefix: prefix}
+	for id, upload := range f.uploads {
+		if upload.bucket == bucket && strings.HasPrefix(upload.key, prefix) {
+			result.Uploads = append(result.Uploads, fakeMultipartUpload{Key: upload.key, UploadID: id})
+		}
+	}
+	sort.Slice(result.Uploads, func(i, j int) bool { return result.Uploads[i].UploadID < result.Uploads[j].UploadID })
+	writeFakeXML(w, result)
+}
+
+// listParts 按 part-number-marker 与 max-parts 分页列出已上传的分片
+func (f *fakeServer) listParts(w http.ResponseWriter, query map[string][]string) {
+	get := func(name string) string {
+		if v := query[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	upload, ok := f.uploads[get("uploadId")]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	marker, _ := strconv.Atoi(get("part-number-marker"))
+	maxParts := 1000
+	if v := get("max-parts"); v != "" {
+		maxParts, _ = strconv.Atoi(v)
+	}
+
+	numbers := make([]int, 0, len(upload.parts))
+	for number := range upload.parts {
+		if number > marker {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+
+	type fakePart struct {
+		PartNumber   int    `xml:"PartNumber"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int    `xml:"Size"`
+	}
+	result := struct {
+		XMLName              xml.Name   `xml:"ListPartsResult"`
+		Bucket               string     `xml:"Bucket"`
+		Key                  string     `xml:"Key"`
+		UploadID             string     `xml:"UploadId"`
+		NextPartNumberMarker int        `xml:"NextPartNumberMarker"`
+		MaxParts             int        `xml:"MaxParts"`
+		IsTruncated          bool       `xml:"IsTruncated"`
+		Parts                []fakePart `xml:"Part"`
+	}{Bucket: upload.bucket, Key: upload.key, UploadID: get("uploadId"), MaxParts: maxParts}
+	for _, number := range numbers {
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			break
+		}
+		data := upload.parts[number]
+		result.Parts = append(result.Parts, fakePart{
+			PartNumber:   number,
+			LastModified: time.Now().UTC().Format(time.RFC3339),
+			ETag:         fakeETag(data),
+			Size:         len(data),
+		})
+		result.NextPartNumberMarker = number
+	}
+	writeFakeXML(w, result)
+}
+
+func (f *fakeServer) complete(w http.ResponseWriter, bucket, key, id string, body []byte) {
+	upload, ok := f.uploads[id]
+	if !ok {
+		writeFakeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+
+	var req struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &req); err != nil {
+		writeFakeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+
+	var data []byte
+	for i, part := range req.Parts {
+		content, ok := upload.parts[part.PartNumber]
+		if !ok || (i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber) ||
+			!strings.EqualFold(strings.Trim(part.ETag, `"`), strings.Trim(fakeETag(content), `"`)) {
+			writeFakeError(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data = append(data, content...)
+	}
+
+	if f.objects[bucket] == nil {
+		f.objects[bucket] = make(map[string][]byte)
+	}
+	f.objects[bucket][key] = data
+	f.customerKeys[bucket+"/"+key] = upload.customerKey
+	f.metadata[bucket+"/"+key] = upload.metadata
+	f.encodings[bucket+"/"+key] = upload.encoding
+	f.crc64[bucket+"/"+key] = fakeCRC64(data)
+	delete(f.tags, bucket+"/"+key)
+	delete(f.uploads, id)
+
+	setFakeChecksums(w, data)
+	writeFakeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}{Bucket: bucket, Key: key, ETag: fakeETag(data)})
+}
+
+// virtualHostTransport 将所有请求都发送到测试服务，用于 virtual-hosted 方式的存储桶域名
+func (f *fakeServer) virtualHostTransport() http.RoundTripper {
+	addr := f.Listener.Addr().String()
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// decodeAWSChunked 解析 minio 在 http 下使用的 aws-chunked 流式签名请求体，
+// 格式为若干个 "<十六进制长度>;chunk-signature=<签名>\r\n<数据>\r\n"，以长度为 0 的块结束
+func decodeAWSChunked(body []byte) ([]byte, error) {
+	var data []byte
+	for {
+		header, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			return nil, fmt.Errorf("malformed aws-chunked body")
+		}
+		sizeHex, _, _ := bytes.Cut(header, []byte(";"))
+		size, err := strconv.ParseInt(string(sizeHex), 16, 64)
+		if err != nil || int64(len(rest)) < size+2 {
+			return nil, fmt.Errorf("malformed aws-chunked body")
+		}
+		if size == 0 {
+			return data, nil
+		}
+		data = append(data, rest[:size]...)
+		body = rest[size+2:]
+	}
+}
+
+// setFakeChecksums 返回 ETag 以及 COS、OSS 用于校验上传内容的 CRC64 头
+func setFakeChecksums(w http.ResponseWriter, data []byte) {
+	crc := fakeCRC64(data)
+	w.Header().Set("ETag", fakeETag(data))
+	w.Header().Set("x-cos-hash-crc64ecma", crc)
+	w.Header().Set("x-oss-hash-crc64ecma", crc)
+}
+
+func fakeCRC64(data []byte) string {
+	return strconv.FormatUint(crc64.Checksum(data, crc64.MakeTable(crc64.ECMA)), 10)
+}
+
+func fakeETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeFakeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeFakeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(fakeError{Code: code, Message: code})
+}
@@ -0,0 +1,469 @@
+package uploader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// fsTempPrefix 上传过程中临时文件的前缀，列举时会被忽略
+	fsTempPrefix = ".velero-tmp-"
+	// fsSigningKeyFile 签名密钥文件，保存在根目录下，挂载同一目录的插件进程共用
+	fsSigningKeyFile = ".velero-signing-key"
+)
+
+// FilesystemUploader 将存储桶和对象键映射为根目录下的目录和文件，实现了 Uploader 接口，
+// 适用于只有 NFS 等共享存储的离线环境
+type FilesystemUploader struct {
+	root string
+	// serverURL 内置文件服务对外的访问地址，为空时不支持预签名地址
+	serverURL  string
+	signingKey []byte
+	log        logrus.FieldLogger
+}
+
+// fileServers 同一进程内按监听地址只启动一次文件服务，值为 *fileServer
+var fileServers sync.Map
+
+// fileServer 内置文件服务，使用最近一次在该地址创建的 FilesystemUploader 处理请求，
+// 重新初始化后根目录或签名密钥变化时，文件服务随之使用新的配置
+type fileServer struct {
+	uploader atomic.Pointer[FilesystemUploader]
+}
+
+// ServeHTTP 交给当前的 FilesystemUploader 处理
+func (s *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.uploader.Load().ServeHTTP(w, r)
+}
+
+// NewFilesystemUploader 创建一个 FilesystemUploader 实例。serverAddr 不为空时在该地址启动内置文件服务，
+// 用于下载预签名地址，serverURL 为该服务对外的访问地址，为空时使用 http://serverAddr
+func NewFilesystemUploader(root, serverAddr, serverURL string, log logrus.FieldLogger) (Uploader, error) {
+	if root == "" {
+		return nil, errors.New("root directory is required")
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	f := &FilesystemUploader{root: root, log: log}
+	if serverAddr != "" {
+		if f.signingKey, err = loadOrCreateSigningKey(filepath.Join(root, fsSigningKeyFile)); err != nil {
+			return nil, fmt.Errorf("load signing key error: %w", err)
+		}
+		if serverURL == "" {
+			serverURL = "http://" + serverAddr
+		}
+		f.serverURL = strings.TrimSuffix(serverURL, "/")
+		if err := f.startFileServer(serverAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info("build filesystem uploader success")
+	return f, nil
+}
+
+// objectPath 返回对象在本地的文件路径，拒绝会逃逸出存储桶目录的对象键
+func (f *FilesystemUploader) objectPath(bucket, key string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return "", fmt.Errorf("invalid bucket name %q", bucket)
+	}
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	for _, elem := range strings.Split(key, "/") {
+		if elem == "" || elem == "." || elem == ".." || strings.HasPrefix(elem, fsTempPrefix) {
+			return "", fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return filepath.Join(f.root, bucket, filepath.FromSlash(key)), nil
+}
+
+// PutObject 先写入同目录下的临时文件并 fsync，再重命名为目标文件，保证读者不会看到不完整的对象
+func (f *FilesystemUploader) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, fsTempPrefix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tmp != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tmp, contextReader{ctx: ctx, r: body}); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return err
+	}
+	tmp = nil
+
+	return syncDir(dir)
+}
+
+// syncDir 持久化目录项，保证重命名在掉电后仍然生效
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// ObjectExists 检查指定的桶和键是否存在对象
+func (f *FilesystemUploader) ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return info.Mode().IsRegular(), nil
+}
+
+// GetObject 获取指定桶和键的对象内容，ctx 取消后读取返回错误
+func (f *FilesystemUploader) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{contextReader{ctx: ctx, r: file}, file}, nil
+}
+
+// contextReader 在 ctx 取消后让读取返回 ctx.Err()，用于不支持 context 的本地文件读写
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ListObjects 列出指定桶和前缀下的所有对象键
+func (f *FilesystemUploader) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var objects []string
+	err := f.walk(ctx, bucket, prefix, func(key string, d fs.DirEntry) error {
+		if !d.IsDir() && strings.HasPrefix(key, prefix) {
+			objects = append(objects, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// DeleteObject 删除指定桶和键的对象，并清理因此变空的上级目录，对象不存在时不报错
+func (f *FilesystemUploader) DeleteObject(ctx context.Context, bucket, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	bucketDir := filepath.Join(f.root, bucket)
+	for dir := filepath.Dir(p); dir != bucketDir && strings.HasPrefix(dir, bucketDir); dir = filepath.Dir(dir) {
+		// 目录非空时 Remove 失败，停止清理
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// ListCommonPrefixes 按分隔符列出指定前缀下的公共前缀，语义与 S3 一致
+func (f *FilesystemUploader) ListCommonPrefixes(ctx context.Context, bucket, prefix, delimiter string) ([]string, error) {
+	prefixes := make([]string, 0)
+	seen := make(map[string]bool)
+	err := f.walk(ctx, bucket, prefix, func(key string, d fs.DirEntry) error {
+		name := key
+		if d.IsDir() {
+			name += "/"
+		}
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		i := strings.Index(name[len(prefix):], delimiter)
+		if delimiter == "" || i < 0 {
+			return nil
+		}
+
+		commonPrefix := name[:len(prefix)+i+len(delimiter)]
+		if !seen[commonPrefix] {
+			seen[commonPrefix] = true
+			prefixes = append(prefixes, commonPrefix)
+		}
+		// 以 / 为分隔符时目录即公共前缀，无需继续遍历其内容
+		if d.IsDir() && commonPrefix == name {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// walk 按字典序遍历存储桶中可能匹配 prefix 的文件和目录，key 为相对存储桶的路径。
+// 只有包含对象的目录才会被访问，空目录和临时文件会被忽略，ctx 取消后停止遍历
+func (f *FilesystemUploader) walk(ctx context.Context, bucket, prefix string, fn func(key string, d fs.DirEntry) error) error {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || bucket == "." || bucket == ".." {
+		return fmt.Errorf("invalid bucket name %q", bucket)
+	}
+	bucketDir := filepath.Join(f.root, bucket)
+
+	// 从前缀中最后一个 / 之前的目录开始遍历，避免扫描整个存储桶
+	start := bucketDir
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		start = filepath.Join(bucketDir, filepath.FromSlash(prefix[:i]))
+	}
+
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == start {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == bucketDir {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), fsTempPrefix) {
+			return nil
+		}
+		if d.IsDir() && isEmptyDir(p) {
+			return fs.SkipDir
+		}
+
+		rel, err := filepath.Rel(bucketDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		// 与前缀不可能匹配的目录直接跳过
+		if d.IsDir() && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+			return fs.SkipDir
+		}
+		return fn(key, d)
+	})
+	return err
+}
+
+func isEmptyDir(dir string) bool {
+	d, err := os.Open(dir)
+	if err != nil {
+		return false
+	}
+	defer d.Close()
+	_, err = d.Readdirnames(1)
+	return err == io.EOF
+}
+
+// CreateSignedURL 生成内置文件服务的下载地址，未启用文件服务时返回错误
+func (f *FilesystemUploader) CreateSignedURL(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if f.serverURL == "" {
+		return "", errors.New("signed url is not supported by filesystem backend without file server")
+	}
+	if _, err := f.objectPath(bucket, key); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", f.sign(bucket+"/"+key, expires))
+	return f.serverURL + "/" + bucket + "/" + (&url.URL{Path: key}).EscapedPath() + "?" + query.Encode(), nil
+}
+
+// sign 计算对象路径与过期时间的 HMAC-SHA256 签名
+func (f *FilesystemUploader) sign(objectPath, expires string) string {
+	mac := hmac.New(sha256.New, f.signingKey)
+	mac.Write([]byte(objectPath + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loadOrCreateSigningKey 读取签名密钥，不存在时生成随机密钥并写入
+func loadOrCreateSigningKey(keyPath string) ([]byte, error) {
+	key, err := os.ReadFile(keyPath)
+	if err == nil && len(key) > 0 {
+		return key, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	// 多个插件进程可能同时创建，O_EXCL 保证只有一个写入成功，其余进程读取已写入的密钥
+	file, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if os.IsExist(err) {
+			time.Sleep(100 * time.Millisecond)
+			return os.ReadFile(keyPath)
+		}
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Write(key); err != nil {
+		return nil, err
+	}
+	return key, file.Sync()
+}
+
+// startFileServer 在 addr 上启动文件服务，同一进程内已经在该地址启动过服务时改为由 f 处理请求。
+// 监听失败时返回错误且不记录该地址，下一次创建 FilesystemUploader 时重新尝试
+func (f *FilesystemUploader) startFileServer(addr string) error {
+	if server, ok := fileServers.Load(addr); ok {
+		server.(*fileServer).uploader.Store(f)
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("start file server on %s error: %w", addr, err)
+	}
+	server := &fileServer{}
+	server.uploader.Store(f)
+	// 并发创建时只保留先记录的服务
+	if actual, loaded := fileServers.LoadOrStore(addr, server); loaded {
+		listener.Close()
+		actual.(*fileServer).uploader.Store(f)
+		return nil
+	}
+
+	f.log.Infof("file server listening on %s", listener.Addr())
+	go func() {
+		httpServer := &http.Server{Handler: server, ReadHeaderTimeout: 10 * time.Second}
+		if err := httpServer.Serve(listener); err != nil {
+			f.log.Errorf("file server on %s stopped: %v", addr, err)
+		}
+	}()
+	return nil
+}
+
+// ServeHTTP 校验预签名地址并返回对象内容
+func (f *FilesystemUploader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(path.Clean(r.URL.Path), "/"), "/")
+	expires := r.URL.Query().Get("expires")
+	signature := r.URL.Query().Get("signature")
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		http.Error(w, "request has expired", http.StatusForbidden)
+		return
+	}
+	if !hmac.Equal([]byte(signature), []byte(f.sign(bucket+"/"+key, expires))) {
+		http.Error(w, "signature does not match", http.StatusForbidden)
+		return
+	}
+
+	p, err := f.objectPath(bucket, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, path.Base(key), info.ModTime(), file)
+}
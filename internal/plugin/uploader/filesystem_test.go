@@ -0,0 +1,258 @@
+package uploader
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFilesystemUploaderObjectLifecycle(t *testing.T) {
+	root := t.TempDir()
+	u, err := NewFilesystemUploader(root, "", "", logrus.New())
+	if err != nil {
+		t.Fatalf("NewFilesystemUploader() error = %v", err)
+	}
+
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", strings.NewReader("content")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "velero", "backups", "b1", "b1.tar.gz"))
+	if err != nil || string(data) != "content" {
+		t.Fatalf("stored file = %q, %v", data, err)
+	}
+
+	exists, err := u.ObjectExists(context.Background(), "velero", "backups/b1/b1.tar.gz")
+	if err != nil || !exists {
+		t.Fatalf("ObjectExists() = %v, %v, want true, nil", exists, err)
+	}
+
+	body, err := u.GetObject(context.Background(), "velero", "backups/b1/b1.tar.gz")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	data, err = io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "content" {
+		t.Fatalf("GetObject() content = %q, %v", data, err)
+	}
+
+	if err := u.DeleteObject(context.Background(), "velero", "backups/b1/b1.tar.gz"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "velero", "backups")); !os.IsNotExist(err) {
+		t.Errorf("empty parent directories were not removed: %v", err)
+	}
+	exists, err = u.ObjectExists(context.Background(), "velero", "backups/b1/b1.tar.gz")
+	if err != nil || exists {
+		t.Fatalf("ObjectExists() after delete = %v, %v, want false, nil", exists, err)
+	}
+}
+
+func TestFilesystemUploaderRejectsEscapingKeys(t *testing.T) {
+	u, err := NewFilesystemUploader(t.TempDir(), "", "", logrus.New())
+	if err != nil {
+		t.Fatalf("NewFilesystemUploader() error = %v", err)
+	}
+
+	for _, key := range []string{"../outside", "a/../../outside", "a//b", "dir/"} {
+		if err := u.PutObject(context.Background(), "velero", key, strings.NewReader("x")); err == nil {
+			t.Errorf("PutObject(%q) succeeded, want error", key)
+		}
+	}
+	if err := u.PutObject(context.Background(), "../velero", "key", strings.NewReader("x")); err == nil {
+		t.Errorf("PutObject() with escaping bucket succeeded, want error")
+	}
+}
+
+func TestFilesystemUploaderListing(t *testing.T) {
+	u, err := NewFilesystemUploader(t.TempDir(), "", "", logrus.New())
+	if err != nil {
+		t.Fatalf("NewFilesystemUploader() error = %v", err)
+	}
+
+	for _, key := range []string{
+		"backups/b1/velero-backup.json",
+		"backups/b1/b1.tar.gz",
+		"backups/b2/velero-backup.json",
+		"restores/r1/restore-r1-logs.gz",
+		"metadata/revision",
+	} {
+		if err := u.PutObject(context.Background(), "velero", key, strings.NewReader("x")); err != nil {
+			t.Fatalf("PutObject(%q) error = %v", key, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		prefix    string
+		delimiter string
+		want      []string
+	}{
+		{name: "root", prefix: "", delimiter: "/", want: []string{"backups/", "metadata/", "restores/"}},
+		{name: "backups", prefix: "backups/", delimiter: "/", want: []string{"backups/b1/", "backups/b2/"}},
+		{name: "partial name", prefix: "backups/b", delimiter: "/", want: []string{"backups/b1/", "backups/b2/"}},
+		{name: "no match", prefix: "missing/", delimiter: "/", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := u.ListCommonPrefixes(context.Background(), "velero", tt.prefix, tt.delimiter)
+			if err != nil {
+				t.Fatalf("ListCommonPrefixes() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListCommonPrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	got, err := u.ListObjects(context.Background(), "velero", "backups/b1/")
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	want := []string{"backups/b1/b1.tar.gz", "backups/b1/velero-backup.json"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListObjects() = %v, want %v", got, want)
+	}
+}
+
+func TestFilesystemUploaderCreateSignedURL(t *testing.T) {
+	root := t.TempDir()
+	u, err := NewFilesystemUploader(root, "", "", logrus.New())
+	if err != nil {
+		t.Fatalf("NewFilesystemUploader() error = %v", err)
+	}
+	if _, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/b1.tar.gz", time.Minute); err == nil {
+		t.Errorf("CreateSignedURL() without file server succeeded, want error")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	u, err = NewFilesystemUploader(root, addr, "", logrus.New())
+	if err != nil {
+		t.Fatalf("NewFilesystemUploader() error = %v", err)
+	}
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", strings.NewReader("content")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	signed, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/b1.tar.gz", time.Minute)
+	if err != nil {
+		t.Fatalf("CreateSignedURL() error = %v", err)
+	}
+	resp, err := http.Get(signed)
+	if err != nil {
+		t.Fatalf("GET signed url error = %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "content" {
+		t.Errorf("GET signed url = %d %q, want 200 %q", resp.StatusCode, data, "content")
+	}
+
+	resp, err = http.Get(strings.Replace(signed, "b1.tar.gz", "other", 1))
+	if err != nil {
+		t.Fatalf("GET tampered url error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET tampered url = %d, want 403", resp.StatusCode)
+	}
+
+	expired, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/b1.tar.gz", -time.Minute)
+	if err != nil {
+		t.Fatalf("CreateSignedURL() error = %v", err)
+	}
+	resp, err = http.Get(expired)
+	if err != nil {
+		t.Fatalf("GET expired url error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET expired url = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestFilesystemUploaderRetriesFileServer(t *testing.T) {
+	root := t.TempDir()
+	// 地址被占用时返回错误，不记录该地址
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := busy.Addr().String()
+	if _, err := NewFilesystemUploader(root, addr, "", logrus.New()); err == nil {
+		t.Fatalf("NewFilesystemUploader() on a busy address succeeded, want error")
+	}
+	if _, ok := fileServers.Load(addr); ok {
+		t.Fatalf("file server on %s recorded after a failed listen", addr)
+	}
+
+	// 地址释放后重新启动文件服务
+	busy.Close()
+	u, err := NewFilesystemUploader(root, addr, "", logrus.New())
+	if err != nil {
+		t.Fatalf("NewFilesystemUploader() after the address was released error = %v", err)
+	}
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", strings.NewReader("content")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	signed, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/b1.tar.gz", time.Minute)
+	if err != nil {
+		t.Fatalf("CreateSignedURL() error = %v", err)
+	}
+	resp, err := http.Get(signed)
+	if err != nil {
+		t.Fatalf("GET signed url error = %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "content" {
+		t.Errorf("GET signed url = %d %q, want 200 %q", resp.StatusCode, data, "content")
+	}
+
+	// 同一进程内使用其他根目录重新创建时复用已经启动的服务，改为提供新根目录中的文件
+	other := t.TempDir()
+	u, err = NewFilesystemUploader(other, addr, "", logrus.New())
+	if err != nil {
+		t.Fatalf("NewFilesystemUploader() with a running file server error = %v", err)
+	}
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", strings.NewReader("other")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	resigned, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/b1.tar.gz", time.Minute)
+	if err != nil {
+		t.Fatalf("CreateSignedURL() error = %v", err)
+	}
+	resp, err = http.Get(resigned)
+	if err != nil {
+		t.Fatalf("GET signed url error = %v", err)
+	}
+	data, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != "other" {
+		t.Errorf("GET signed url after re-creating = %d %q, want 200 %q", resp.StatusCode, data, "other")
+	}
+	// 旧根目录的签名密钥不再有效
+	resp, err = http.Get(signed)
+	if err != nil {
+		t.Fatalf("GET signed url error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("GET url signed for the previous root = %d, want 403", resp.StatusCode)
+	}
+}
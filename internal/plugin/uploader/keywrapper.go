@@ -0,0 +1,146 @@
+package uploader
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awscreds "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
+)
+
+// KeyWrapper 使用主密钥加密与解密每个对象的数据密钥
+type KeyWrapper interface {
+	// KeyID 返回主密钥的标识，记录在对象头部，解密时用于确认主密钥
+	KeyID() string
+	// WrapKey 使用主密钥加密数据密钥
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, error)
+	// UnwrapKey 使用 keyID 对应的主密钥解密数据密钥
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// MasterKeySize 本地主密钥的长度（字节）
+const MasterKeySize = 32
+
+// LocalKeyWrapper 使用本地文件中的主密钥通过 AES-256-GCM 加密数据密钥
+type LocalKeyWrapper struct {
+	key []byte
+	id  string
+}
+
+// NewLocalKeyWrapper 创建一个 LocalKeyWrapper，key 为 32 字节的主密钥
+func NewLocalKeyWrapper(key []byte) (*LocalKeyWrapper, error) {
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", MasterKeySize, len(key))
+	}
+	// 主密钥的标识取自其摘要，不会泄露密钥本身
+	sum := sha256.Sum256(append([]byte("velero-os-plugin master key "), key...))
+	return &LocalKeyWrapper{key: key, id: "local:" + hex.EncodeToString(sum[:8])}, nil
+}
+
+// NewLocalKeyWrapperFromFile 从文件读取主密钥，文件内容为 32 字节的密钥或其 base64 编码
+func NewLocalKeyWrapperFromFile(path string) (*LocalKeyWrapper, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != MasterKeySize {
+		if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+			data = key
+		}
+	}
+	return NewLocalKeyWrapper(data)
+}
+
+// KeyID 实现 KeyWrapper 接口
+func (l *LocalKeyWrapper) KeyID() string {
+	return l.id
+}
+
+// WrapKey 实现 KeyWrapper 接口，结果为 nonce 与密文
+func (l *LocalKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	aead, err := newChunkAEAD(l.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(l.id)), nil
+}
+
+// UnwrapKey 实现 KeyWrapper 接口
+func (l *LocalKeyWrapper) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if keyID != l.id {
+		return nil, fmt.Errorf("object is encrypted with master key %s, but %s is configured", keyID, l.id)
+	}
+	aead, err := newChunkAEAD(l.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(l.id))
+}
+
+// KMSKeyWrapper 使用 AWS KMS 的密钥加密数据密钥，数据密钥的密文中已经包含了 KMS 密钥信息
+type KMSKeyWrapper struct {
+	client *kms.KMS
+	keyID  string
+}
+
+// NewKMSKeyWrapper 创建一个 KMSKeyWrapper，endpoint 为空时使用 AWS 官方地址
+func NewKMSKeyWrapper(keyID, endpoint, region string, creds *credentials.Credentials) (*KMSKeyWrapper, error) {
+	if region == "" {
+		region = defaultAWSRegion
+	}
+	config := aws.NewConfig().WithRegion(region).WithCredentials(awscreds.NewCredentials(&awsProvider{creds: creds}))
+	if endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	return &KMSKeyWrapper{client: kms.New(sess), keyID: keyID}, nil
+}
+
+// KeyID 实现 KeyWrapper 接口
+func (k *KMSKeyWrapper) KeyID() string {
+	return "kms:" + k.keyID
+}
+
+// WrapKey 实现 KeyWrapper 接口
+func (k *KMSKeyWrapper) WrapKey(ctx context.Context, dataKey []byte) ([]byte, error) {
+	output, err := k.client.EncryptWithContext(ctx, &kms.EncryptInput{KeyId: aws.String(k.keyID), Plaintext: dataKey})
+	if err != nil {
+		return nil, err
+	}
+	return output.CiphertextBlob, nil
+}
+
+// UnwrapKey 实现 KeyWrapper 接口，对象使用其他 KMS 密钥加密时只要有权限同样可以解密
+func (k *KMSKeyWrapper) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	if !strings.HasPrefix(keyID, "kms:") {
+		return nil, fmt.Errorf("object is encrypted with master key %s, but kms key %s is configured", keyID, k.keyID)
+	}
+	output, err := k.client.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(strings.TrimPrefix(keyID, "kms:")),
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Plaintext, nil
+}
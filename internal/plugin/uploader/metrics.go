@@ -0,0 +1,188 @@
+package uploader
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "velero_os_plugin"
+
+// 操作结果，作为指标的 result 标签
+const (
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+// Metrics 对象存储操作的 Prometheus 指标，同一个进程中的所有 InstrumentedUploader 共用
+type Metrics struct {
+	operations *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	sent       *prometheus.CounterVec
+	received   *prometheus.CounterVec
+	updated    chan struct{}
+}
+
+// NewMetrics 创建指标并注册到 reg，重复注册同名指标时 panic
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	labels := []string{"operation", "backend", "bucket", "result"}
+	m := &Metrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operations_total",
+			Help:      "Number of object store operations.",
+		}, labels),
+		// 分片上传大的备份可能持续数小时，最大的桶约为 3 小时
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of object store operations, including reading the object for GetObject.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 11),
+		}, labels),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sent_bytes_total",
+			Help:      "Bytes uploaded to the object store.",
+		}, []string{"backend", "bucket"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "received_bytes_total",
+			Help:      "Bytes downloaded from the object store.",
+		}, []string{"backend", "bucket"}),
+		updated: make(chan struct{}, 1),
+	}
+	reg.MustRegister(m.operations, m.duration, m.sent, m.received)
+	return m
+}
+
+// Updated 返回指标更新时收到通知的 channel，多次更新可能合并为一次通知，用于推送指标
+func (m *Metrics) Updated() <-chan struct{} {
+	return m.updated
+}
+
+// observe 记录一次操作的次数与耗时
+func (m *Metrics) observe(operation, backend, bucket string, start time.Time, err error) {
+	result := resultSuccess
+	if err != nil {
+		result = resultError
+	}
+	m.operations.WithLabelValues(operation, backend, bucket, result).Inc()
+	m.duration.WithLabelValues(operation, backend, bucket, result).Observe(time.Since(start).Seconds())
+	select {
+	case m.updated <- struct{}{}:
+	default:
+	}
+}
+
+// InstrumentedUploader 记录被包装的 Uploader 各操作的次数、耗时与传输的字节数。
+// 包装在具体存储的 Uploader 外层时，字节数是压缩、加密之后实际传输的大小
+type InstrumentedUploader struct {
+	Uploader
+	backend string
+	metrics *Metrics
+}
+
+// NewInstrumentedUploader 创建一个 InstrumentedUploader，backend 为指标的 backend 标签，如 minio、oss
+func NewInstrumentedUploader(inner Uploader, backend string, metrics *Metrics) *InstrumentedUploader {
+	return &InstrumentedUploader{Uploader: inner, backend: backend, metrics: metrics}
+}
+
+func (i *InstrumentedUploader) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	start := time.Now()
+	counter := &countingReader{r: body}
+	err := i.Uploader.PutObject(ctx, bucket, key, counter)
+	i.metrics.sent.WithLabelValues(i.backend, bucket).Add(float64(counter.n))
+	i.metrics.observe("PutObject", i.backend, bucket, start, err)
+	return err
+}
+
+func (i *InstrumentedUploader) ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	start := time.Now()
+	exists, err := i.Uploader.ObjectExists(ctx, bucket, key)
+	i.metrics.observe("ObjectExists", i.backend, bucket, start, err)
+	return exists, err
+}
+
+// GetObject 在对象内容关闭时记录操作，耗时包括读取对象内容的时间，读取失败时记为失败
+func (i *InstrumentedUploader) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	start := time.Now()
+	body, err := i.Uploader.GetObject(ctx, bucket, key)
+	if err != nil {
+		i.metrics.observe("GetObject", i.backend, bucket, start, err)
+		return nil, err
+	}
+	return &instrumentedBody{ReadCloser: body, uploader: i, bucket: bucket, start: start}, nil
+}
+
+func (i *InstrumentedUploader) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	start := time.Now()
+	objects, err := i.Uploader.ListObjects(ctx, bucket, prefix)
+	i.metrics.observe("ListObjects", i.backend, bucket, start, err)
+	return objects, err
+}
+
+func (i *InstrumentedUploader) DeleteObject(ctx context.Context, bucket, key string) error {
+	start := time.Now()
+	err := i.Uploader.DeleteObject(ctx, bucket, key)
+	i.metrics.observe("DeleteObject", i.backend, bucket, start, err)
+	return err
+}
+
+func (i *InstrumentedUploader) ListCommonPrefixes(ctx context.Context, bucket, prefix, delimiter string) ([]string, error) {
+	start := time.Now()
+	prefixes, err := i.Uploader.ListCommonPrefixes(ctx, bucket, prefix, delimiter)
+	i.metrics.observe("ListCommonPrefixes", i.backend, bucket, start, err)
+	return prefixes, err
+}
+
+func (i *InstrumentedUploader) CreateSignedURL(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	start := time.Now()
+	url, err := i.Uploader.CreateSignedURL(ctx, bucket, key, ttl)
+	i.metrics.observe("CreateSignedURL", i.backend, bucket, start, err)
+	return url, err
+}
+
+// countingReader 记录读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// instrumentedBody 统计读取的字节数，关闭时记录 GetObject 操作
+type instrumentedBody struct {
+	io.ReadCloser
+	uploader *InstrumentedUploader
+	bucket   string
+	start    time.Time
+	err      error
+	closed   bool
+}
+
+func (b *instrumentedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.uploader.metrics.received.WithLabelValues(b.uploader.backend, b.bucket).Add(float64(n))
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *instrumentedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if !b.closed {
+		b.closed = true
+		if b.err == nil {
+			b.err = err
+		}
+		b.uploader.metrics.observe("GetObject", b.uploader.backend, b.bucket, b.start, b.err)
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+)
+
+func TestInstrumentedUploader(t *testing.T) {
+	inner, err := NewFilesystemUploader(t.TempDir(), "", "", logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := NewMetrics(prometheus.NewRegistry())
+	u := NewInstrumentedUploader(inner, "filesystem", metrics)
+	ctx := context.Background()
+	content := []byte("backup content")
+
+	if err := u.PutObject(ctx, "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	if _, err := u.ObjectExists(ctx, "velero", "backups/b1/b1.tar.gz"); err != nil {
+		t.Fatalf("ObjectExists() error = %v", err)
+	}
+	body, err := u.GetObject(ctx, "velero", "backups/b1/b1.tar.gz")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	// GetObject 在关闭对象内容时才记录
+	if got := testutil.ToFloat64(metrics.operations.WithLabelValues("GetObject", "filesystem", "velero", resultSuccess)); got != 0 {
+		t.Errorf("GetObject operations before Close = %v, want 0", got)
+	}
+	io.Copy(io.Discard, body)
+	body.Close()
+	body.Close()
+	if _, err := u.GetObject(ctx, "velero", "backups/b1/missing"); err == nil {
+		t.Fatalf("GetObject() of a missing object succeeded, want error")
+	}
+	if _, err := u.ListObjects(ctx, "velero", "backups/"); err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	if _, err := u.CreateSignedURL(ctx, "velero", "backups/b1/b1.tar.gz", time.Minute); err == nil {
+		t.Fatalf("CreateSignedURL() without file server succeeded, want error")
+	}
+
+	tests := []struct {
+		operation string
+		result    string
+		want      float64
+	}{
+		{operation: "PutObject", result: resultSuccess, want: 1},
+		{operation: "ObjectExists", result: resultSuccess, want: 1},
+		{operation: "GetObject", result: resultSuccess, want: 1},
+		{operation: "GetObject", result: resultError, want: 1},
+		{operation: "ListObjects", result: resultSuccess, want: 1},
+		{operation: "CreateSignedURL", result: resultError, want: 1},
+		{operation: "DeleteObject", result: resultSuccess, want: 0},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(metrics.operations.WithLabelValues(tt.operation, "filesystem", "velero", tt.result)); got != tt.want {
+			t.Errorf("%s %s operations = %v, want %v", tt.operation, tt.result, got, tt.want)
+		}
+	}
+	if got := testutil.CollectAndCount(metrics.duration); got != 6 {
+		t.Errorf("duration series = %d, want 6", got)
+	}
+	if got := testutil.ToFloat64(metrics.sent.WithLabelValues("filesystem", "velero")); got != float64(len(content)) {
+		t.Errorf("sent bytes = %v, want %d", got, len(content))
+	}
+	if got := testutil.ToFloat64(metrics.received.WithLabelValues("filesystem", "velero")); got != float64(len(content)) {
+		t.Errorf("received bytes = %v, want %d", got, len(content))
+	}
+	select {
+	case <-metrics.Updated():
+	default:
+		t.Errorf("Updated() not notified")
+	}
+}
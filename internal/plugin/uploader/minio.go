@@ -1,61 +1,125 @@
 package uploader
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	miniocreds "github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/minio/minio-go/v7/pkg/tags"
 	"github.com/sirupsen/logrus"
 	"io"
-	"path/filepath"
+	"net/http"
 	"strings"
 	"time"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
 )
 
+// minioListPageSize 单次列举请求返回的最大对象数
+const minioListPageSize = 1000
+
 // MinioUploader 实现了 Uploader 接口
 type MinioUploader struct {
-	client *minio.Client
-	core   *minio.Core
+	client    *minio.Client
+	core      *minio.Core
+	multipart MultipartOptions
+	// sse 服务端加密参数，为 nil 时不加密
+	sse    encrypt.ServerSide
 	logger logrus.FieldLogger
 }
 
-func (m *MinioUploader) DeleteObject(bucket, key string) error {
+func (m *MinioUploader) DeleteObject(ctx context.Context, bucket, key string) error {
 	logrus.Debugf("delete object [%s/%s]", bucket, key)
-	return m.client.RemoveObject(context.Background(), bucket, key, minio.RemoveObjectOptions{})
+	return m.client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{})
 }
 
 // NewMinioUploader 创建一个 MinioUploader 实例
-func NewMinioUploader(endpoint, accessKey, secretKey string, useSSL bool, region string, logger logrus.FieldLogger) (Uploader, error) {
+func NewMinioUploader(endpoint string, creds *credentials.Credentials, useSSL bool, region string, multipart MultipartOptions,
+	sse ServerSideEncryption, logger logrus.FieldLogger) (Uploader, error) {
+	if err := sse.Validate(); err != nil {
+		return nil, err
+	}
+	serverSide, err := sse.minio()
+	if err != nil {
+		return nil, err
+	}
+
 	if strings.HasPrefix(endpoint, "http://") {
 		endpoint = strings.TrimPrefix(endpoint, "http://")
 	} else if strings.HasPrefix(endpoint, "https://") {
 		endpoint = strings.TrimPrefix(endpoint, "https://")
 	}
+	transport, err := minio.DefaultTransport(useSSL)
+	if err != nil {
+		return nil, err
+	}
 	// 创建 Minio 客户端
 	minioCore, err := minio.NewCore(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
-		Secure: useSSL,
-		Region: region,
+		Creds:     miniocreds.New(&minioProvider{creds: creds}),
+		Secure:    useSSL,
+		Region:    region,
+		Transport: tracingTransport(transport),
 	})
 	if err != nil {
 		return nil, err
 	}
 
 	logger.Info("build minio uploader success")
-	return &MinioUploader{client: minioCore.Client, core: minioCore, logger: logger}, nil
+	return &MinioUploader{client: minioCore.Client, core: minioCore, multipart: multipart.withDefaults(), sse: serverSide, logger: logger}, nil
 }
 
-// PutObject 将数据上传到指定的桶和键中
-func (m *MinioUploader) PutObject(bucket, key string, body io.Reader) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	_, err := m.client.PutObject(ctx, bucket, key, body, -1, minio.PutObjectOptions{})
-	return err
+// PutObject 将数据上传到指定的桶和键中，超过 Threshold 的对象以流式分片的方式上传，
+// 分片并行发送，失败时由 SDK 中止本次分片上传，不影响同一对象的其他分片上传。每个请求都带有 Content-MD5 由服务端校验，
+// 整个对象的 SHA-256 记录在元数据或对象标签中，读取时校验
+func (m *MinioUploader) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	// 预读 Threshold 大小的数据，数据读完说明对象较小，以已知长度直接上传，
+	// 流式分片上传无法处理空对象
+	head, complete, err := readHead(body, m.multipart.Threshold)
+	if err != nil {
+		return err
+	}
+	if complete {
+		_, err = m.client.PutObject(ctx, bucket, key, bytes.NewReader(head.Bytes()), int64(head.Len()), minio.PutObjectOptions{
+			UserMetadata:         map[string]string{checksumMetadataKey: checksumSHA256.checksum(head.Bytes())},
+			ContentEncoding:      contentEncoding(ctx),
+			ServerSideEncryption: m.sse,
+			SendContentMd5:       true,
+		})
+		return err
+	}
+
+	hr := newHashingReader(io.MultiReader(head, body), checksumSHA256)
+	_, err = m.client.PutObject(ctx, bucket, key, hr, -1, minio.PutObjectOptions{
+		PartSize:              m.multipart.PartSize,
+		NumThreads:            m.multipart.Concurrency,
+		ConcurrentStreamParts: m.multipart.Concurrency > 1,
+		ContentEncoding:       contentEncoding(ctx),
+		ServerSideEncryption:  m.sse,
+		SendContentMd5:        true,
+	})
+	if err != nil {
+		return err
+	}
+	m.tagChecksum(ctx, bucket, key, hr.sum())
+	return nil
+}
+
+// tagChecksum 将分片上传对象的 SHA-256 记录在对象标签中，失败时对象仍然可用，只是读取时无法校验
+func (m *MinioUploader) tagChecksum(ctx context.Context, bucket, key, sum string) {
+	tagSet, err := tags.NewTags(map[string]string{checksumMetadataKey: sum}, true)
+	if err == nil {
+		err = m.client.PutObjectTagging(ctx, bucket, key, tagSet, minio.PutObjectTaggingOptions{})
+	}
+	if err != nil {
+		m.logger.Warnf("record checksum of [%s/%s] error, it will not be verified on read: %v", bucket, key, err)
+	}
 }
 
 // ObjectExists 检查指定的桶和键是否存在对象
-func (m *MinioUploader) ObjectExists(bucket, key string) (bool, error) {
-	_, err := m.client.StatObject(context.Background(), bucket, key, minio.StatObjectOptions{})
+func (m *MinioUploader) ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	_, err := m.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{ServerSideEncryption: m.sse})
 	if err != nil {
 		if minioErr, ok := err.(minio.ErrorResponse); ok && minioErr.Code == "NoSuchKey" {
 			return false, nil
@@ -65,28 +129,37 @@ func (m *MinioUploader) ObjectExists(bucket, key string) (bool, error) {
 	return true, nil
 }
 
-// GetObject 获取指定桶和键的对象内容
-func (m *MinioUploader) GetObject(bucket, key string) (io.ReadCloser, error) {
-	obj, err := m.client.GetObject(context.Background(), bucket, key, minio.GetObjectOptions{})
+// GetObject 获取指定桶和键的对象内容，SSE-C 加密的对象需要提供密钥才能读取。
+// 对象记录了 SHA-256 时边读取边校验，不一致时返回 ErrChecksumMismatch
+func (m *MinioUploader) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	obj, err := m.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{ServerSideEncryption: m.sse})
 	if err != nil {
 		return nil, err
 	}
-	return obj, nil
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	want := metadataValue(info.UserMetadata, checksumMetadataKey)
+	if want == "" && info.UserTagCount > 0 {
+		tagSet, err := m.client.GetObjectTagging(ctx, bucket, key, minio.GetObjectTaggingOptions{})
+		if err != nil {
+			m.logger.Warnf("get checksum of [%s/%s] error, it will not be verified: %v", bucket, key, err)
+		} else {
+			want = tagSet.ToMap()[checksumMetadataKey]
+		}
+	}
+	return verifyChecksum(obj, checksumSHA256, want, key), nil
 }
 
-// ListObjects 列出指定桶和前缀下的所有对象键
-func (m *MinioUploader) ListObjects(bucket, prefix string) ([]string, error) {
+// ListObjects 递归列出指定桶和前缀下的所有对象键，前缀为空时列出整个存储桶
+func (m *MinioUploader) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
 	var objects []string
 
-	ListObjectsOptions := minio.ListObjectsOptions{}
-	if prefix != "" {
-		ListObjectsOptions = minio.ListObjectsOptions{Prefix: prefix, Recursive: true}
-	}
-
-	for object := range m.client.ListObjects(context.Background(), bucket, ListObjectsOptions) {
+	for object := range m.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
 		if object.Err != nil {
-			m.logger.Error(object.Err)
-			continue
+			return nil, object.Err
 		}
 
 		objects = append(objects, object.Key)
@@ -95,55 +168,78 @@ func (m *MinioUploader) ListObjects(bucket, prefix string) ([]string, error) {
 	return objects, nil
 }
 
-func (m *MinioUploader) ListCommonPrefixes(bucket, prefix, delimiter string) ([]string, error) {
-	ctx := context.Background()
-	prefixes := make([]string, 0)
-
-	ListObjectsOptions := minio.ListObjectsOptions{}
-	if prefix != "" {
-		ListObjectsOptions = minio.ListObjectsOptions{Prefix: prefix, Recursive: true}
+// ListCommonPrefixes 使用服务端的 delimiter 列举分页获取指定前缀下的公共前缀，
+// 只返回前缀的下一级，不会遍历整个存储桶
+func (m *MinioUploader) ListCommonPrefixes(ctx context.Context, bucket, prefix, delimiter string) ([]string, error) {
+	if delimiter != "/" {
+		return m.listCommonPrefixesV2(ctx, bucket, prefix, delimiter)
 	}
-	// 列举对象
-	objectCh := m.client.ListObjects(ctx, bucket, ListObjectsOptions)
 
-	for object := range objectCh {
+	prefixes := make([]string, 0)
+	var err error
+	// 出错后继续读完结果，SDK 的列举 goroutine 在 ctx 取消时还会再发送一次错误
+	for object := range m.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, MaxKeys: minioListPageSize}) {
+		if err != nil {
+			continue
+		}
 		if object.Err != nil {
-			return nil, object.Err
+			err = object.Err
+			continue
 		}
+		// 服务端返回的公共前缀以分隔符结尾，其余是前缀下一级的对象
+		if strings.HasSuffix(object.Key, delimiter) && object.LastModified.IsZero() {
+			prefixes = append(prefixes, object.Key)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
 
-		// 提取公共前缀
-		objectKey := object.Key
-
-		dir := filepath.Dir(objectKey[len(prefix):])
-		commonPrefix := fmt.Sprintf(prefix + dir + delimiter)
+// listCommonPrefixesV2 按 continuation token 翻页，以任意分隔符列举公共前缀。
+// SDK 的 ListObjects 只支持 / 分隔符，Core 的列举接口不接受 ctx，因此在每页之前检查 ctx
+func (m *MinioUploader) listCommonPrefixesV2(ctx context.Context, bucket, prefix, delimiter string) ([]string, error) {
+	prefixes := make([]string, 0)
 
-		// 如果该前缀已经存在于 prefixes 切片中，则跳过
-		if contains(prefixes, commonPrefix) {
-			continue
+	token := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result, err := m.core.ListObjectsV2(bucket, prefix, "", token, delimiter, minioListPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, commonPrefix := range result.CommonPrefixes {
+			prefixes = append(prefixes, commonPrefix.Prefix)
 		}
 
-		// 将公共前缀添加到切片中
-		prefixes = append(prefixes, commonPrefix)
+		if !result.IsTruncated {
+			return prefixes, nil
+		}
+		if result.NextContinuationToken == "" || result.NextContinuationToken == token {
+			return nil, fmt.Errorf("list bucket %s is truncated without next continuation token", bucket)
+		}
+		token = result.NextContinuationToken
 	}
-
-	return prefixes, nil
 }
 
-func (m *MinioUploader) CreateSignedURL(bucket, key string, ttl time.Duration) (string, error) {
+// CreateSignedURL 在本地生成对象的预签名下载地址，不访问服务端。
+// SSE-C 加密时密钥请求头也参与签名，下载时需要携带同样的请求头
+func (m *MinioUploader) CreateSignedURL(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	// 创建预签名 URL
-	presignedURL, err := m.client.PresignedGetObject(context.Background(), bucket, key, ttl, nil)
+	header := make(http.Header)
+	if m.sse != nil && m.sse.Type() == encrypt.SSEC {
+		m.sse.Marshal(header)
+	}
+	presignedURL, err := m.client.PresignHeader(ctx, http.MethodGet, bucket, key, ttl, nil, header)
 	if err != nil {
 		return "", err
 	}
 
 	return presignedURL.String(), nil
 }
-
-func contains(arr []string, str string) bool {
-	for _, v := range arr {
-		if v == str {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,179 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestMinioUploader(t *testing.T, server *fakeServer) Uploader {
+	u, err := NewMinioUploader(server.URL, testCredentials(), false, "us-east-1", MultipartOptions{}, ServerSideEncryption{}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewMinioUploader() error = %v", err)
+	}
+	return u
+}
+
+func TestMinioUploaderMultipart(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency uint
+		// failPart 上传失败的分片编号，为 0 时上传成功
+		failPart int
+	}{
+		{name: "parallel", concurrency: 4},
+		{name: "sequential part rejected", concurrency: 1, failPart: 2},
+		{name: "parallel part rejected", concurrency: 4, failPart: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			if tt.failPart != 0 {
+				server.failPart(tt.failPart, http.StatusForbidden)
+			}
+			u, err := NewMinioUploader(server.URL, testCredentials(), false, "us-east-1",
+				MultipartOptions{PartSize: MinPartSize, Concurrency: tt.concurrency}, ServerSideEncryption{}, logrus.New())
+			if err != nil {
+				t.Fatalf("NewMinioUploader() error = %v", err)
+			}
+
+			// 其他客户端正在上传同一个对象
+			other := server.startUpload("velero", "backups/b1/b1.tar.gz")
+
+			content := make([]byte, int(MinPartSize)*4+1)
+			rand.Read(content)
+			err = u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content))
+			if (err != nil) != (tt.failPart != 0) {
+				t.Fatalf("PutObject() error = %v, want error %v", err, tt.failPart != 0)
+			}
+			if got := server.count("POST initiate"); got != 1 {
+				t.Errorf("PutObject() initiated %d multipart uploads, want 1", got)
+			}
+			// 失败的分片上传被中止，不会遗留已上传的分片，其他客户端的分片上传不受影响
+			if pending := server.pendingUploads(); !reflect.DeepEqual(pending, []string{other}) {
+				t.Errorf("pending multipart uploads = %v, want only %s", pending, other)
+			}
+			stored, ok := server.get("velero", "backups/b1/b1.tar.gz")
+			if tt.failPart != 0 {
+				if ok || server.count("DELETE abort") == 0 {
+					t.Errorf("object exists = %v, abort requests = %d after a failed upload, want no object and an abort",
+						ok, server.count("DELETE abort"))
+				}
+				return
+			}
+			if !bytes.Equal(stored, content) {
+				t.Errorf("stored object = %d bytes, want %d bytes", len(stored), len(content))
+			}
+			if got, err := readObject(u, "backups/b1/b1.tar.gz"); err != nil || !bytes.Equal(got, content) {
+				t.Errorf("GetObject() = %d bytes, %v, want %d bytes", len(got), err, len(content))
+			}
+		})
+	}
+}
+
+func TestMinioUploaderListCommonPrefixes(t *testing.T) {
+	server := newFakeServer(t)
+	for _, key := range []string{
+		"backups/b1/velero-backup.json",
+		"backups/b1/b1.tar.gz",
+		"backups/b2/velero-backup.json",
+		"backups/b2/nested/deep/object",
+		"restores/r1/restore-r1-logs.gz",
+		"metadata/revision",
+		"top-level-object",
+		"kopia/ns1/kopia.repository",
+		"kopia/ns2/kopia.repository",
+	} {
+		server.put("velero", key, []byte("x"))
+	}
+	u := newTestMinioUploader(t, server)
+
+	tests := []struct {
+		name      string
+		prefix    string
+		delimiter string
+		want      []string
+	}{
+		{name: "empty prefix", prefix: "", delimiter: "/", want: []string{"backups/", "kopia/", "metadata/", "restores/"}},
+		{name: "backups", prefix: "backups/", delimiter: "/", want: []string{"backups/b1/", "backups/b2/"}},
+		{name: "nested keys", prefix: "backups/b2/", delimiter: "/", want: []string{"backups/b2/nested/"}},
+		{name: "partial name", prefix: "backups/b", delimiter: "/", want: []string{"backups/b1/", "backups/b2/"}},
+		{name: "prefix without trailing delimiter", prefix: "kopia", delimiter: "/", want: []string{"kopia/"}},
+		{name: "objects only", prefix: "backups/b1/", delimiter: "/", want: []string{}},
+		{name: "no match", prefix: "missing/", delimiter: "/", want: []string{}},
+		{name: "custom delimiter", prefix: "backups/", delimiter: "-", want: []string{"backups/b1/velero-", "backups/b2/velero-"}},
+		{name: "multi-character delimiter", prefix: "", delimiter: "/b", want: []string{"backups/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := u.ListCommonPrefixes(context.Background(), "velero", tt.prefix, tt.delimiter)
+			if err != nil {
+				t.Fatalf("ListCommonPrefixes() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListCommonPrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinioUploaderListCommonPrefixesCancelled(t *testing.T) {
+	server := newFakeServer(t)
+	server.put("velero", "backups/b1/velero-backup.json", []byte("{}"))
+	u := newTestMinioUploader(t, server)
+
+	for _, delimiter := range []string{"/", "-"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := u.ListCommonPrefixes(ctx, "velero", "backups/", delimiter); !errors.Is(err, context.Canceled) {
+			t.Errorf("ListCommonPrefixes(%q) with a cancelled context error = %v, want %v", delimiter, err, context.Canceled)
+		}
+	}
+}
+
+func TestMinioUploaderListCommonPrefixesPagination(t *testing.T) {
+	const backups = 2500
+	tests := []struct {
+		delimiter string
+		// keyFormat 按备份序号生成一个备份下的对象键
+		keyFormat []string
+	}{
+		{delimiter: "/", keyFormat: []string{"backups/b%04d/velero-backup.json", "backups/b%04d/b.tar.gz"}},
+		{delimiter: "-", keyFormat: []string{"backups/b%04d-velero-backup.json", "backups/b%04d-b.tar.gz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.delimiter, func(t *testing.T) {
+			server := newFakeServer(t)
+			for i := 0; i < backups; i++ {
+				for _, format := range tt.keyFormat {
+					server.put("velero", fmt.Sprintf(format, i), []byte("{}"))
+				}
+			}
+			u := newTestMinioUploader(t, server)
+
+			prefixes, err := u.ListCommonPrefixes(context.Background(), "velero", "backups/", tt.delimiter)
+			if err != nil {
+				t.Fatalf("ListCommonPrefixes() error = %v", err)
+			}
+			if len(prefixes) != backups {
+				t.Fatalf("ListCommonPrefixes() returned %d prefixes, want %d", len(prefixes), backups)
+			}
+			for i, prefix := range prefixes {
+				if want := fmt.Sprintf("backups/b%04d%s", i, tt.delimiter); prefix != want {
+					t.Fatalf("ListCommonPrefixes()[%d] = %s, want %s", i, prefix, want)
+				}
+			}
+			// 基于 delimiter 的列举只需要按公共前缀数量翻页，而不是遍历所有对象
+			if got := server.count("GET list"); got != 3 {
+				t.Errorf("ListCommonPrefixes() made %d list requests, want 3", got)
+			}
+		})
+	}
+}
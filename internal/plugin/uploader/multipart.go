@@ -0,0 +1,112 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// partUploadAttempts 单个分片上传失败时的最大尝试次数
+const partUploadAttempts = 3
+
+// partRetryDelay 分片第一次重试前的等待时间，之后每次重试递增
+var partRetryDelay = time.Second
+
+// readHead 预读 body 开头最多 size 字节，只分配实际读到的数据。
+// 读到的数据少于 size 时说明 body 已读完，complete 为 true
+func readHead(body io.Reader, size uint64) (head *bytes.Buffer, complete bool, err error) {
+	head = new(bytes.Buffer)
+	n, err := io.Copy(head, io.LimitReader(body, int64(size)))
+	if err != nil {
+		return nil, false, err
+	}
+	return head, uint64(n) < size, nil
+}
+
+// partUploadFunc 上传编号为 number 的分片，data 在函数返回后会被复用
+type partUploadFunc func(ctx context.Context, number int, data []byte) error
+
+// uploadPartsConcurrently 顺序读取 body 并按 PartSize 切分，最多 Concurrency 个分片并行上传，
+// 内存占用为 Concurrency 个分片大小。分片上传失败时重试，仍失败则取消其余分片并返回第一个错误
+func uploadPartsConcurrently(ctx context.Context, body io.Reader, opts MultipartOptions, log logrus.FieldLogger, upload partUploadFunc) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	buffers := make(chan []byte, opts.Concurrency)
+	for i := uint(0); i < opts.Concurrency; i++ {
+		buffers <- make([]byte, opts.PartSize)
+	}
+
+read:
+	for number := 1; ; number++ {
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-ctx.Done():
+			break read
+		}
+
+		n, err := io.ReadFull(body, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			fail(err)
+			break
+		}
+
+		wg.Add(1)
+		go func(number int, buf, data []byte) {
+			defer wg.Done()
+			defer func() { buffers <- buf }()
+			if err := uploadPartWithRetry(ctx, number, data, log, upload); err != nil {
+				fail(err)
+			}
+		}(number, buf, buf[:n])
+
+		if n < len(buf) {
+			break
+		}
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return firstErr
+}
+
+// uploadPartWithRetry 上传单个分片，网络抖动导致失败时重试
+func uploadPartWithRetry(ctx context.Context, number int, data []byte, log logrus.FieldLogger, upload partUploadFunc) error {
+	for attempt := 1; ; attempt++ {
+		err := upload(ctx, number, data)
+		if err == nil || ctx.Err() != nil || attempt == partUploadAttempts {
+			return err
+		}
+		log.Warnf("upload part %d failed (attempt %d/%d): %v", number, attempt, partUploadAttempts, err)
+
+		select {
+		case <-time.After(time.Duration(attempt) * partRetryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
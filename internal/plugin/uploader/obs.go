@@ -0,0 +1,313 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/huaweicloud/huaweicloud-sdk-go-obs/obs"
+	"github.com/sirupsen/logrus"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
+)
+
+// obsMaxKeys 单次列举请求返回的最大对象数
+const obsMaxKeys = 1000
+
+// OBSUploader 基于华为云 OBS SDK 实现了 Uploader 接口
+type OBSUploader struct {
+	// newClient 创建绑定了 ctx 的 OBS 客户端。OBS SDK 只能在创建客户端时指定 context，
+	// 因此每次调用都创建一个共用同一个 http.Transport 的轻量客户端
+	newClient func(ctx context.Context) (*obs.ObsClient, error)
+	multipart MultipartOptions
+	log       logrus.FieldLogger
+}
+
+// NewOBSUploader 创建一个 OBSUploader 实例，使用 OBS 原生签名方式访问
+func NewOBSUploader(endpoint string, creds *credentials.Credentials, region string, s3ForcePathStyle, insecureSkipTLSVerify bool,
+	multipart MultipartOptions, log logrus.FieldLogger) (Uploader, error) {
+	// 与 OBS SDK 默认创建的 Transport 保持一致，关闭压缩以免影响服务端校验
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: insecureSkipTLSVerify}
+	transport.DisableCompression = true
+	// 与 SDK 默认的 http.Client 一样不跟随重定向，同时为每个请求创建 span
+	client := &http.Client{
+		Transport: tracingTransport(transport),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	newClient := func(ctx context.Context) (*obs.ObsClient, error) {
+		// SDK 在请求失败后会休眠重试且不检查 ctx，因此 ctx 已结束时直接返回
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// 每次创建客户端时获取凭证，临时凭证即将过期时会重新获取
+		v, err := creds.Get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return obs.New(v.AccessKeyID, v.SecretAccessKey, endpoint,
+			obs.WithSecurityToken(v.SessionToken),
+			obs.WithSignature(obs.SignatureObs),
+			obs.WithRegion(region),
+			obs.WithPathStyle(s3ForcePathStyle),
+			// 设置 transport 以免 SDK 为每个客户端创建一个不使用的 Transport
+			obs.WithHttpTransport(transport),
+			obs.WithHttpClient(client),
+			obs.WithRequestContext(ctx),
+		)
+	}
+	// 提前创建一次客户端以校验 endpoint 等配置
+	if _, err := newClient(context.Background()); err != nil {
+		return nil, err
+	}
+
+	log.Info("build obs uploader success")
+	return &OBSUploader{
+		newClient: newClient,
+		multipart: multipart.withDefaults(),
+		log:       log,
+	}, nil
+}
+
+// PutObject 将数据上传到指定的桶和键中，超过 Threshold 的对象使用分片上传
+func (o *OBSUploader) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	client, err := o.newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 预读 Threshold 大小的数据，数据读完说明对象较小，直接上传
+	head, complete, err := readHead(body, o.multipart.Threshold)
+	if err != nil {
+		return err
+	}
+	if complete {
+		input := &obs.PutObjectInput{Body: bytes.NewReader(head.Bytes())}
+		input.Bucket = bucket
+		input.Key = key
+		input.ContentLength = int64(head.Len())
+		input.ContentEncoding = contentEncoding(ctx)
+		_, err = client.PutObject(input)
+		return err
+	}
+
+	return o.putObjectMultipart(ctx, client, bucket, key, io.MultiReader(head, body))
+}
+
+// putObjectMultipart 并行上传各分片，失败时中止分片上传。
+// SDK 初始化分片上传时无法设置 Content-Encoding，因此完成上传后再修改对象的元数据
+func (o *OBSUploader) putObjectMultipart(ctx context.Context, client *obs.ObsClient, bucket, key string, body io.Reader) error {
+	initInput := &obs.InitiateMultipartUploadInput{}
+	initInput.Bucket = bucket
+	initInput.Key = key
+	imur, err := client.InitiateMultipartUpload(initInput)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu    sync.Mutex
+		parts []obs.Part
+	)
+	log := o.log.WithFields(logrus.Fields{"bucket": bucket, "key": key})
+	err = uploadPartsConcurrently(ctx, body, o.multipart, log, func(ctx context.Context, number int, data []byte) error {
+		// 某个分片失败时 uploadPartsConcurrently 会取消 ctx，其余分片需要随之中断
+		partClient, err := o.newClient(ctx)
+		if err != nil {
+			return err
+		}
+		output, err := partClient.UploadPart(&obs.UploadPartInput{
+			Bucket:     bucket,
+			Key:        key,
+			UploadId:   imur.UploadId,
+			PartNumber: number,
+			Body:       bytes.NewReader(data),
+			PartSize:   int64(len(data)),
+		})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		parts = append(parts, obs.Part{PartNumber: number, ETag: output.ETag})
+		mu.Unlock()
+		return nil
+	})
+	if err == nil {
+		sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+		_, err = client.CompleteMultipartUpload(&obs.CompleteMultipartUploadInput{
+			Bucket:   bucket,
+			Key:      key,
+			UploadId: imur.UploadId,
+			Parts:    parts,
+		})
+		if encoding := contentEncoding(ctx); err == nil && encoding != "" {
+			return o.setContentEncoding(ctx, client, bucket, key, encoding)
+		}
+	}
+	if err != nil {
+		// 上传超时时原 context 已失效，因此这里使用独立的 context
+		abortCtx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+		defer cancel()
+		abortClient, abortErr := o.newClient(abortCtx)
+		if abortErr == nil {
+			_, abortErr = abortClient.AbortMultipartUpload(&obs.AbortMultipartUploadInput{
+				Bucket:   bucket,
+				Key:      key,
+				UploadId: imur.UploadId,
+			})
+		}
+		if abortErr != nil {
+			log.Warnf("abort multipart upload error: %v", abortErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// setContentEncoding 设置分片上传完成的对象的 Content-Encoding，只替换该请求头，保留其余元数据。
+// 设置失败时删除对象，避免通过预签名地址下载到没有标明压缩格式的内容
+func (o *OBSUploader) setContentEncoding(ctx context.Context, client *obs.ObsClient, bucket, key, encoding string) error {
+	input := &obs.SetObjectMetadataInput{Bucket: bucket, Key: key, MetadataDirective: obs.ReplaceNew, ContentEncoding: encoding}
+	_, err := client.SetObjectMetadata(input)
+	if err == nil {
+		return nil
+	}
+	deleteCtx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	if deleteClient, deleteErr := o.newClient(deleteCtx); deleteErr == nil {
+		input := &obs.DeleteObjectInput{Bucket: bucket, Key: key}
+		if _, deleteErr = deleteClient.DeleteObject(input); deleteErr != nil {
+			o.log.Warnf("delete object [%s/%s] without content encoding error: %v", bucket, key, deleteErr)
+		}
+	}
+	return fmt.Errorf("set content encoding of %s error: %w", key, err)
+}
+
+// ObjectExists 检查指定的桶和键是否存在对象，OBS 对不存在的对象返回 404
+func (o *OBSUploader) ObjectExists(ctx context.Context, bucket, key string) (bool, error) {
+	client, err := o.newClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	_, err = client.GetObjectMetadata(&obs.GetObjectMetadataInput{Bucket: bucket, Key: key})
+	if err != nil {
+		if obsErr, ok := err.(obs.ObsError); ok && obsErr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetObject 获取指定桶和键的对象内容
+func (o *OBSUploader) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	client, err := o.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	input := &obs.GetObjectInput{}
+	input.Bucket = bucket
+	input.Key = key
+	output, err := client.GetObject(input)
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// ListObjects 分页列出指定桶和前缀下的所有对象键
+func (o *OBSUploader) ListObjects(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var objects []string
+	err := o.listPages(ctx, bucket, prefix, "", func(output *obs.ListObjectsOutput) {
+		for _, content := range output.Contents {
+			objects = append(objects, content.Key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// DeleteObject 删除指定桶和键的对象
+func (o *OBSUploader) DeleteObject(ctx context.Context, bucket, key string) error {
+	client, err := o.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteObject(&obs.DeleteObjectInput{Bucket: bucket, Key: key})
+	return err
+}
+
+// ListCommonPrefixes 按分隔符分页列出指定前缀下的公共前缀
+func (o *OBSUploader) ListCommonPrefixes(ctx context.Context, bucket, prefix, delimiter string) ([]string, error) {
+	prefixes := make([]string, 0)
+	err := o.listPages(ctx, bucket, prefix, delimiter, func(output *obs.ListObjectsOutput) {
+		prefixes = append(prefixes, output.CommonPrefixes...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prefixes, nil
+}
+
+// listPages 按 marker 翻页列举存储桶，每页结果交给 fn 处理
+func (o *OBSUploader) listPages(ctx context.Context, bucket, prefix, delimiter string, fn func(output *obs.ListObjectsOutput)) error {
+	client, err := o.newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	input := &obs.ListObjectsInput{Bucket: bucket}
+	input.Prefix = prefix
+	input.Delimiter = delimiter
+	input.MaxKeys = obsMaxKeys
+
+	for {
+		output, err := client.ListObjects(input)
+		if err != nil {
+			return err
+		}
+		fn(output)
+
+		if !output.IsTruncated {
+			return nil
+		}
+		// 未指定 delimiter 时 OBS 不返回 NextMarker，此时以最后一个对象键作为 marker
+		marker := output.NextMarker
+		if marker == "" && len(output.Contents) > 0 {
+			marker = output.Contents[len(output.Contents)-1].Key
+		}
+		if marker == "" || marker == input.Marker {
+			return fmt.Errorf("list bucket %s is truncated without next marker", bucket)
+		}
+		input.Marker = marker
+	}
+}
+
+// CreateSignedURL 在本地生成对象的预签名下载地址，不访问服务端
+func (o *OBSUploader) CreateSignedURL(ctx context.Context, bucket, key string, ttl time.Duration) (string, error) {
+	client, err := o.newClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	output, err := client.CreateSignedUrl(&obs.CreateSignedUrlInput{
+		Method:  obs.HttpMethodGet,
+		Bucket:  bucket,
+		Key:     key,
+		Expires: int(ttl.Seconds()),
+	})
+	if err != nil {
+		return "", err
+	}
+	return output.SignedUrl, nil
+}
@@ -0,0 +1,129 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/huaweicloud/huaweicloud-sdk-go-obs/obs"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestOBSUploader(t *testing.T, server *fakeServer, multipart MultipartOptions) Uploader {
+	u, err := NewOBSUploader(server.URL, testCredentials(), "cn-north-4", true, false, multipart, logrus.New())
+	if err != nil {
+		t.Fatalf("NewOBSUploader() error = %v", err)
+	}
+	return u
+}
+
+func TestOBSUploaderObjectLifecycle(t *testing.T) {
+	server := newFakeServer(t)
+	u := newTestOBSUploader(t, server, MultipartOptions{})
+
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", strings.NewReader("content")); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	exists, err := u.ObjectExists(context.Background(), "velero", "backups/b1/b1.tar.gz")
+	if err != nil || !exists {
+		t.Fatalf("ObjectExists() = %v, %v, want true, nil", exists, err)
+	}
+
+	body, err := u.GetObject(context.Background(), "velero", "backups/b1/b1.tar.gz")
+	if err != nil {
+		t.Fatalf("GetObject() error = %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "content" {
+		t.Fatalf("GetObject() content = %q, %v, want %q", data, err, "content")
+	}
+
+	if err := u.DeleteObject(context.Background(), "velero", "backups/b1/b1.tar.gz"); err != nil {
+		t.Fatalf("DeleteObject() error = %v", err)
+	}
+	exists, err = u.ObjectExists(context.Background(), "velero", "backups/b1/b1.tar.gz")
+	if err != nil || exists {
+		t.Fatalf("ObjectExists() after delete = %v, %v, want false, nil", exists, err)
+	}
+}
+
+func TestOBSUploaderGetMissingObject(t *testing.T) {
+	server := newFakeServer(t)
+	u := newTestOBSUploader(t, server, MultipartOptions{})
+
+	_, err := u.GetObject(context.Background(), "velero", "missing")
+	obsErr, ok := err.(obs.ObsError)
+	if !ok {
+		t.Fatalf("GetObject() error = %v, want obs.ObsError", err)
+	}
+	if obsErr.Code != "NoSuchKey" {
+		t.Errorf("GetObject() error code = %s, want NoSuchKey", obsErr.Code)
+	}
+}
+
+func TestOBSUploaderMultipart(t *testing.T) {
+	server := newFakeServer(t)
+	u := newTestOBSUploader(t, server, MultipartOptions{PartSize: MinPartSize, Concurrency: 2})
+
+	data := bytes.Repeat([]byte("0123456789"), int(MinPartSize)*5/20)
+	if err := u.PutObject(context.Background(), "velero", "big", bytes.NewReader(data)); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+
+	stored, _ := server.get("velero", "big")
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes, want %d", len(stored), len(data))
+	}
+	if got := server.count("PUT part"); got != 3 {
+		t.Errorf("uploaded %d parts, want 3", got)
+	}
+}
+
+func TestOBSUploaderListPagination(t *testing.T) {
+	server := newFakeServer(t)
+	u := newTestOBSUploader(t, server, MultipartOptions{})
+
+	const backups = 1500
+	for i := 0; i < backups; i++ {
+		server.put("velero", fmt.Sprintf("backups/b%04d/velero-backup.json", i), []byte("{}"))
+		server.put("velero", fmt.Sprintf("backups/b%04d/b%04d.tar.gz", i, i), []byte("tar"))
+	}
+
+	objects, err := u.ListObjects(context.Background(), "velero", "backups/")
+	if err != nil {
+		t.Fatalf("ListObjects() error = %v", err)
+	}
+	if len(objects) != 2*backups {
+		t.Errorf("ListObjects() returned %d keys, want %d", len(objects), 2*backups)
+	}
+
+	prefixes, err := u.ListCommonPrefixes(context.Background(), "velero", "backups/", "/")
+	if err != nil {
+		t.Fatalf("ListCommonPrefixes() error = %v", err)
+	}
+	if len(prefixes) != backups {
+		t.Errorf("ListCommonPrefixes() returned %d prefixes, want %d", len(prefixes), backups)
+	}
+}
+
+func TestOBSUploaderCreateSignedURL(t *testing.T) {
+	server := newFakeServer(t)
+	u := newTestOBSUploader(t, server, MultipartOptions{})
+
+	signed, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/b1.tar.gz", 10*time.Minute)
+	if err != nil {
+		t.Fatalf("CreateSignedURL() error = %v", err)
+	}
+	if !strings.HasPrefix(signed, server.URL+"/velero/backups/b1/b1.tar.gz?") {
+		t.Errorf("CreateSignedURL() = %s, unexpected object url", signed)
+	}
+	if !strings.Contains(signed, "AccessKeyId=ak") || !strings.Contains(signed, "Signature=") {
+		t.Errorf("CreateSignedURL() = %s, missing obs signature", signed)
+	}
+}
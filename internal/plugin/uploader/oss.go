@@ -1,22 +1,53 @@
 package uploader
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 	"github.com/sirupsen/logrus"
 	"io"
+	"net/http"
+	"sync"
 	"time"
+
+	"github.com/noovertime7/velero-os-plugin/internal/plugin/credentials"
+)
+
+const (
+	// DefaultOSSListPageSize OSS 单次列举请求默认返回的最大对象数
+	DefaultOSSListPageSize = 1000
+	// MaxOSSListPageSize OSS 单次列举请求允许返回的最大对象数
+	MaxOSSListPageSize = 1000
 )
 
 // OSSUploader 实现了 Uploader 接口
 type OSSUploader struct {
-	client *oss.Client
-	log    logrus.FieldLogger
+	client       *oss.Client
+	multipart    MultipartOptions
+	sse          ServerSideEncryption
+	listPageSize int
+	log          logrus.FieldLogger
+	// cleanupOnce 每个进程只在第一次分片上传前清理一次过期的断点记录
+	cleanupOnce sync.Once
 }
 
-// NewOSSUploader 创建一个 OSSUploader 实例
-func NewOSSUploader(endpoint, accessKey, secretKey, region string, s3ForcePathStyle bool, log logrus.FieldLogger) (Uploader, error) {
-	// 创建 OSS 客户端
-	client, err := oss.New(endpoint, accessKey, secretKey, oss.Region(region), oss.ForcePathStyle(s3ForcePathStyle))
+// NewOSSUploader 创建一个 OSSUploader 实例，listPageSize 为 0 时使用 DefaultOSSListPageSize
+func NewOSSUploader(endpoint string, creds *credentials.Credentials, region string, s3ForcePathStyle bool, multipart MultipartOptions,
+	sse ServerSideEncryption, listPageSize int, log logrus.FieldLogger) (Uploader, error) {
+	if err := sse.Validate(); err != nil {
+		return nil, err
+	}
+	if listPageSize <= 0 {
+		listPageSize = DefaultOSSListPageSize
+	}
+	if listPageSize > MaxOSSListPageSize {
+		return nil, fmt.Errorf("oss list page size %d exceeds maximum %d", listPageSize, MaxOSSListPageSize)
+	}
+
+	// 创建 OSS 客户端，凭证由 CredentialsProvider 在每次请求前获取
+	client, err := oss.New(endpoint, "", "", oss.Region(region), oss.ForcePathStyle(s3ForcePathStyle),
+		oss.SetCredentialsProvider(ossCredentialsProvider{creds: creds, log: log}), oss.HTTPClient(ossHTTPClient()))
 	if err != nil {
 		return nil, err
 	}
@@ -24,105 +55,157 @@ func NewOSSUploader(endpoint, accessKey, secretKey, region string, s3ForcePathSt
 	log.Info("build oss uploader success")
 
 	return &OSSUploader{
-		client: client,
-		log:    log,
+		client:       client,
+		multipart:    multipart.withDefaults(),
+		sse:          sse,
+		listPageSize: listPageSize,
+		log:          log,
 	}, nil
 }
 
-// PutObject 将数据上传到指定的桶和键中
-func (o *OSSUploader) PutObject(bucketName, key string, body io.Reader) error {
+// PutObject 将数据上传到指定的桶和键中，超过 Threshold 的对象自动使用可断点续传的分片上传。
+// OSS 服务端为每个对象记录 CRC64，SDK 校验单个请求的 CRC64，分片上传完成后再校验整个对象的 CRC64
+func (o *OSSUploader) PutObject(ctx context.Context, bucketName, key string, body io.Reader) error {
 	// 获取存储空间
 	bucket, err := o.client.Bucket(bucketName)
 	if err != nil {
 		return err
 	}
-	return bucket.PutObject(key, body)
-}
 
-// ObjectExists 检查指定的桶和键是否存在对象
-func (o *OSSUploader) ObjectExists(bucketName, key string) (bool, error) {
-	// 获取存储空间
-	bucket, err := o.client.Bucket(bucketName)
+	// 预读 Threshold 大小的数据，数据读完说明对象较小，直接上传
+	head, complete, err := readHead(body, o.multipart.Threshold)
 	if err != nil {
-		return false, err
+		return err
 	}
-	return bucket.IsObjectExist(key)
+	if complete {
+		return bucket.PutObject(key, bytes.NewReader(head.Bytes()), o.writeOptions(ctx)...)
+	}
+
+	return o.putObjectMultipart(ctx, bucket, key, io.MultiReader(head, body))
 }
 
-// GetObject 获取指定桶和键的对象内容
-func (o *OSSUploader) GetObject(bucketName, key string) (io.ReadCloser, error) {
+// writeOptions 返回写入对象（包括初始化分片上传）时的请求参数
+func (o *OSSUploader) writeOptions(ctx context.Context) []oss.Option {
+	options := append(o.sse.ossWriteOptions(), oss.WithContext(ctx))
+	if encoding := contentEncoding(ctx); encoding != "" {
+		options = append(options, oss.ContentEncoding(encoding))
+	}
+	return options
+}
+
+// ObjectExists 检查指定的桶和键是否存在对象
+func (o *OSSUploader) ObjectExists(ctx context.Context, bucketName, key string) (bool, error) {
 	// 获取存储空间
 	bucket, err := o.client.Bucket(bucketName)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	return bucket.GetObject(key)
+	return bucket.IsObjectExist(key, append(o.sse.ossCustomerKeyOptions(), oss.WithContext(ctx))...)
 }
 
-// ListObjects 列出指定桶和前缀下的所有对象键
-func (o *OSSUploader) ListObjects(bucketName, prefix string) ([]string, error) {
+// GetObject 获取指定桶和键的对象内容，SSE-C 加密的对象需要提供密钥才能读取。
+// 边读取边计算 CRC64，与服务端记录的不一致时返回 ErrChecksumMismatch
+func (o *OSSUploader) GetObject(ctx context.Context, bucketName, key string) (io.ReadCloser, error) {
+	// 获取存储空间
 	bucket, err := o.client.Bucket(bucketName)
 	if err != nil {
 		return nil, err
 	}
-	result, err := bucket.ListObjects(oss.Prefix(prefix))
+	// 显式指定 Accept-Encoding，避免 Content-Encoding: gzip 的对象被自动解压
+	var header http.Header
+	body, err := bucket.GetObject(key, append(o.sse.ossCustomerKeyOptions(),
+		oss.WithContext(ctx), oss.AcceptEncoding("identity"), oss.GetResponseHeader(&header))...)
 	if err != nil {
 		return nil, err
 	}
+	return verifyChecksum(body, checksumCRC64, header.Get(oss.HTTPHeaderOssCRC64), key), nil
+}
 
+// ListObjects 分页列出指定桶和前缀下的所有对象键
+func (o *OSSUploader) ListObjects(ctx context.Context, bucketName, prefix string) ([]string, error) {
 	var objects []string
-	for _, object := range result.Objects {
-		objects = append(objects, object.Key)
+	err := o.listPages(ctx, bucketName, prefix, "", func(result oss.ListObjectsResultV2) {
+		for _, object := range result.Objects {
+			objects = append(objects, object.Key)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
-
 	return objects, nil
 }
 
 // DeleteObject 删除指定桶和键的对象
-func (o *OSSUploader) DeleteObject(bucketName, key string) error {
+func (o *OSSUploader) DeleteObject(ctx context.Context, bucketName, key string) error {
 	bucket, err := o.client.Bucket(bucketName)
 	if err != nil {
 		return err
 	}
-	return bucket.DeleteObject(key)
+	return bucket.DeleteObject(key, oss.WithContext(ctx))
 }
 
-func (o *OSSUploader) ListCommonPrefixes(bucketName, prefix, delimiter string) ([]string, error) {
-	bucket, err := o.client.Bucket(bucketName)
+// ListCommonPrefixes 按分隔符分页列出指定前缀下的公共前缀
+func (o *OSSUploader) ListCommonPrefixes(ctx context.Context, bucketName, prefix, delimiter string) ([]string, error) {
+	prefixes := make([]string, 0)
+	err := o.listPages(ctx, bucketName, prefix, delimiter, func(result oss.ListObjectsResultV2) {
+		prefixes = append(prefixes, result.CommonPrefixes...)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return prefixes, nil
+}
 
-	prefixes := make([]string, 0)
-
-	options := []oss.Option{
-		oss.Prefix(prefix),
-		oss.Delimiter(delimiter),
-	}
-
-	lsRes, err := bucket.ListObjects(options...)
+// listPages 使用 ListObjectsV2 按 continuation token 翻页列举存储桶，每页结果交给 fn 处理
+func (o *OSSUploader) listPages(ctx context.Context, bucketName, prefix, delimiter string, fn func(result oss.ListObjectsResultV2)) error {
+	bucket, err := o.client.Bucket(bucketName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	for _, commonPrefix := range lsRes.CommonPrefixes {
-		prefixes = append(prefixes, commonPrefix)
+	token := ""
+	for {
+		options := []oss.Option{
+			oss.Prefix(prefix),
+			oss.MaxKeys(o.listPageSize),
+			oss.WithContext(ctx),
+		}
+		if delimiter != "" {
+			options = append(options, oss.Delimiter(delimiter))
+		}
+		if token != "" {
+			options = append(options, oss.ContinuationToken(token))
+		}
+
+		result, err := bucket.ListObjectsV2(options...)
+		if err != nil {
+			return err
+		}
+		fn(result)
+
+		if !result.IsTruncated {
+			return nil
+		}
+		if result.NextContinuationToken == "" || result.NextContinuationToken == token {
+			return fmt.Errorf("list bucket %s is truncated without next continuation token", bucketName)
+		}
+		token = result.NextContinuationToken
 	}
-
-	return prefixes, nil
 }
 
-func (o *OSSUploader) CreateSignedURL(bucketName, key string, ttl time.Duration) (string, error) {
+// CreateSignedURL 在本地生成预签名地址，不访问服务端。
+// SSE-C 加密时密钥请求头也参与签名，下载时需要携带同样的请求头
+func (o *OSSUploader) CreateSignedURL(ctx context.Context, bucketName, key string, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	bucket, err := o.client.Bucket(bucketName)
 	if err != nil {
 		return "", err
 	}
 
-	// 生成过期时间
-	expireTime := time.Now().Add(ttl)
-
-	// 生成预签名 URL
-	signedURL, err := bucket.SignURL(key, oss.HTTPGet, int64(expireTime.Unix()))
+	// 生成预签名 URL，SDK 的过期时间是相对于当前时间的秒数
+	signedURL, err := bucket.SignURL(key, oss.HTTPGet, int64(ttl.Seconds()), o.sse.ossCustomerKeyOptions()...)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,276 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/sirupsen/logrus"
+)
+
+const checkpointSuffix = ".cp"
+
+// ossCheckpoint 断点续传记录，保存未完成的分片上传信息，
+// 已上传的分片以服务端 ListUploadedParts 的结果为准
+type ossCheckpoint struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	UploadID string `json:"uploadId"`
+	PartSize uint64 `json:"partSize"`
+}
+
+// checkpointPath 返回对象对应的断点记录文件路径，未配置 CheckpointDir 时返回空
+func (o *OSSUploader) checkpointPath(bucket, key string) string {
+	if o.multipart.CheckpointDir == "" {
+		return ""
+	}
+	sum := md5.Sum([]byte(bucket + "/" + key))
+	return filepath.Join(o.multipart.CheckpointDir, hex.EncodeToString(sum[:])+checkpointSuffix)
+}
+
+// loadCheckpoint 读取断点记录，文件不存在时返回 nil
+func loadCheckpoint(path string) (*ossCheckpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	cp := &ossCheckpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("parse checkpoint %s error: %w", path, err)
+	}
+	return cp, nil
+}
+
+// saveCheckpoint 先写临时文件再重命名，避免进程中断时留下不完整的记录
+func saveCheckpoint(path string, cp *ossCheckpoint) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeCheckpoint 删除断点记录，文件不存在时忽略
+func (o *OSSUploader) removeCheckpoint(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		o.log.Warnf("remove checkpoint %s error: %v", path, err)
+	}
+}
+
+// cleanupCheckpoints 中止断点记录超过 CheckpointMaxAge 的分片上传并删除记录。
+// Velero 不会以同一个键重新上传失败的备份，这些上传不会再被续传，留在存储桶中会持续产生存储费用
+func (o *OSSUploader) cleanupCheckpoints() {
+	paths, _ := filepath.Glob(filepath.Join(o.multipart.CheckpointDir, "*"+checkpointSuffix))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) < o.multipart.CheckpointMaxAge {
+			continue
+		}
+		cp, err := loadCheckpoint(path)
+		if err != nil || cp == nil {
+			o.removeCheckpoint(path)
+			continue
+		}
+		bucket, err := o.client.Bucket(cp.Bucket)
+		if err == nil {
+			err = abortMultipartUpload(bucket, oss.InitiateMultipartUploadResult{Bucket: cp.Bucket, Key: cp.Key, UploadID: cp.UploadID})
+		}
+		if err != nil {
+			// 保留记录，下次清理时重试
+			o.log.Warnf("abort expired multipart upload [%s/%s] error: %v", cp.Bucket, cp.Key, err)
+			continue
+		}
+		o.log.Infof("aborted expired multipart upload [%s/%s] of checkpoint %s", cp.Bucket, cp.Key, path)
+		o.removeCheckpoint(path)
+	}
+}
+
+// abortMultipartUpload 中止分片上传，服务端已经不存在该上传时视为成功。
+// 上传超时时原 context 已失效，因此这里使用独立的 context
+func abortMultipartUpload(bucket *oss.Bucket, imur oss.InitiateMultipartUploadResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	err := bucket.AbortMultipartUpload(imur, oss.WithContext(ctx))
+	if serviceErr, ok := err.(oss.ServiceError); ok && serviceErr.Code == "NoSuchUpload" {
+		return nil
+	}
+	return err
+}
+
+// resumable 判断分片上传失败后是否保留断点：网络错误与服务端 5xx 错误在下次上传时可以续传，
+// 超时、取消、4xx 错误以及读取 body 的错误再次上传也无法成功
+func resumable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// putObjectMultipart 以分片方式上传对象，存在断点记录时跳过服务端已有且内容一致的分片
+func (o *OSSUploader) putObjectMultipart(ctx context.Context, bucket *oss.Bucket, key string, body io.Reader) error {
+	cpPath := o.checkpointPath(bucket.BucketName, key)
+	if cpPath != "" {
+		o.cleanupOnce.Do(o.cleanupCheckpoints)
+	}
+
+	imur, uploaded, err := o.resumeOrInitiate(ctx, bucket, key, cpPath)
+	if err != nil {
+		return err
+	}
+
+	hr := newHashingReader(body, checksumCRC64)
+	parts, err := o.uploadParts(ctx, bucket, imur, hr, uploaded)
+	var header http.Header
+	if err == nil {
+		_, err = bucket.CompleteMultipartUpload(imur, parts, oss.WithContext(ctx), oss.GetResponseHeader(&header))
+	}
+	if err != nil {
+		if cpPath != "" && resumable(ctx, err) {
+			o.log.Warnf("multipart upload [%s/%s] interrupted, checkpoint kept at %s", bucket.BucketName, key, cpPath)
+			return err
+		}
+		if abortErr := abortMultipartUpload(bucket, imur); abortErr != nil {
+			// 中止失败时保留断点记录，过期后由 cleanupCheckpoints 再次中止
+			o.log.Warnf("abort multipart upload [%s/%s] error: %v", bucket.BucketName, key, abortErr)
+		} else if cpPath != "" {
+			o.removeCheckpoint(cpPath)
+		}
+		return err
+	}
+
+	if cpPath != "" {
+		o.removeCheckpoint(cpPath)
+	}
+
+	// 服务端根据各分片计算整个对象的 CRC64，与上传的内容不一致说明分片有误，删除已经生成的对象
+	if crc := header.Get(oss.HTTPHeaderOssCRC64); crc != "" && crc != hr.sum() {
+		if err := bucket.DeleteObject(key, oss.WithContext(ctx)); err != nil {
+			o.log.Warnf("delete corrupted object [%s/%s] error: %v", bucket.BucketName, key, err)
+		}
+		return fmt.Errorf("%w: crc64ecma of %s is %s, want %s", ErrChecksumMismatch, key, crc, hr.sum())
+	}
+	return nil
+}
+
+// resumeOrInitiate 根据断点记录恢复分片上传，记录不可用时重新初始化
+func (o *OSSUploader) resumeOrInitiate(ctx context.Context, bucket *oss.Bucket, key, cpPath string) (oss.InitiateMultipartUploadResult, map[int]oss.UploadedPart, error) {
+	if cpPath != "" {
+		cp, err := loadCheckpoint(cpPath)
+		if err != nil {
+			o.log.Warnf("ignore invalid checkpoint: %v", err)
+		}
+		if cp != nil && cp.Bucket == bucket.BucketName && cp.Key == key {
+			imur := oss.InitiateMultipartUploadResult{Bucket: cp.Bucket, Key: cp.Key, UploadID: cp.UploadID}
+			if cp.PartSize == o.multipart.PartSize {
+				uploaded, err := listUploadedParts(ctx, bucket, imur)
+				if err == nil {
+					o.log.Infof("resume multipart upload [%s/%s] with %d uploaded parts", bucket.BucketName, key, len(uploaded))
+					return imur, uploaded, nil
+				}
+				if serviceErr, ok := err.(oss.ServiceError); !ok || serviceErr.Code != "NoSuchUpload" {
+					return imur, nil, err
+				}
+			} else if err := abortMultipartUpload(bucket, imur); err != nil {
+				o.log.Warnf("abort stale multipart upload [%s/%s] error: %v", bucket.BucketName, key, err)
+			}
+		}
+	}
+
+	imur, err := bucket.InitiateMultipartUpload(key, o.writeOptions(ctx)...)
+	if err != nil {
+		return imur, nil, err
+	}
+
+	if cpPath != "" {
+		cp := &ossCheckpoint{Bucket: bucket.BucketName, Key: key, UploadID: imur.UploadID, PartSize: o.multipart.PartSize}
+		if err := saveCheckpoint(cpPath, cp); err != nil {
+			o.log.Warnf("save checkpoint %s error: %v", cpPath, err)
+		}
+	}
+	return imur, nil, nil
+}
+
+// listUploadedParts 分页列出服务端已上传的分片
+func listUploadedParts(ctx context.Context, bucket *oss.Bucket, imur oss.InitiateMultipartUploadResult) (map[int]oss.UploadedPart, error) {
+	uploaded := make(map[int]oss.UploadedPart)
+	marker := 0
+	for {
+		result, err := bucket.ListUploadedParts(imur, oss.PartNumberMarker(marker), oss.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range result.UploadedParts {
+			uploaded[part.PartNumber] = part
+		}
+		if !result.IsTruncated {
+			return uploaded, nil
+		}
+		if marker, err = strconv.Atoi(result.NextPartNumberMarker); err != nil {
+			return nil, fmt.Errorf("invalid next part number marker %q: %w", result.NextPartNumberMarker, err)
+		}
+	}
+}
+
+// uploadParts 并行上传各分片，跳过服务端已有且内容一致的分片
+func (o *OSSUploader) uploadParts(ctx context.Context, bucket *oss.Bucket, imur oss.InitiateMultipartUploadResult,
+	body io.Reader, uploaded map[int]oss.UploadedPart) ([]oss.UploadPart, error) {
+	var (
+		mu    sync.Mutex
+		parts []oss.UploadPart
+	)
+	log := o.log.WithFields(logrus.Fields{"bucket": imur.Bucket, "key": imur.Key})
+	err := uploadPartsConcurrently(ctx, body, o.multipart, log, func(ctx context.Context, number int, data []byte) error {
+		part := oss.UploadPart{PartNumber: number}
+		sum := md5.Sum(data)
+		if prev, ok := uploaded[number]; ok && prev.Size == len(data) &&
+			strings.EqualFold(strings.Trim(prev.ETag, `"`), hex.EncodeToString(sum[:])) {
+			part.ETag = prev.ETag
+		} else {
+			var err error
+			if part, err = bucket.UploadPart(imur, bytes.NewReader(data), int64(len(data)), number,
+				append(o.sse.ossCustomerKeyOptions(), oss.WithContext(ctx))...); err != nil {
+				return err
+			}
+		}
+		mu.Lock()
+		parts = append(parts, part)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
@@ -0,0 +1,315 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestOSSUploader(t *testing.T, server *fakeServer, listPageSize int) Uploader {
+	u, err := NewOSSUploader(server.URL, testCredentials(), "cn-hangzhou", true, MultipartOptions{}, ServerSideEncryption{}, listPageSize, logrus.New())
+	if err != nil {
+		t.Fatalf("NewOSSUploader() error = %v", err)
+	}
+	return u
+}
+
+func TestNewOSSUploaderRejectsLargePageSize(t *testing.T) {
+	if _, err := NewOSSUploader("http://127.0.0.1", testCredentials(), "", true, MultipartOptions{}, ServerSideEncryption{}, MaxOSSListPageSize+1, logrus.New()); err == nil {
+		t.Errorf("NewOSSUploader() with page size %d succeeded, want error", MaxOSSListPageSize+1)
+	}
+}
+
+func TestOSSUploaderListPagination(t *testing.T) {
+	const backups = 2500
+
+	server := newFakeServer(t)
+	var wantObjects, wantPrefixes []string
+	for i := 0; i < backups; i++ {
+		for _, name := range []string{fmt.Sprintf("b%04d.tar.gz", i), "velero-backup.json"} {
+			key := fmt.Sprintf("backups/b%04d/%s", i, name)
+			server.put("velero", key, []byte("x"))
+			wantObjects = append(wantObjects, key)
+		}
+		wantPrefixes = append(wantPrefixes, fmt.Sprintf("backups/b%04d/", i))
+	}
+	server.put("velero", "restores/r1/restore-r1-logs.gz", []byte("x"))
+	sort.Strings(wantObjects)
+
+	tests := []struct {
+		name         string
+		listPageSize int
+		objectPages  int
+		prefixPages  int
+	}{
+		{name: "default page size", listPageSize: 0, objectPages: 5, prefixPages: 3},
+		{name: "small page size", listPageSize: 100, objectPages: 50, prefixPages: 25},
+		{name: "uneven page size", listPageSize: 7, objectPages: 715, prefixPages: 358},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newTestOSSUploader(t, server, tt.listPageSize)
+
+			before := server.count("GET list")
+			objects, err := u.ListObjects(context.Background(), "velero", "backups/")
+			if err != nil {
+				t.Fatalf("ListObjects() error = %v", err)
+			}
+			if !reflect.DeepEqual(objects, wantObjects) {
+				t.Errorf("ListObjects() returned %d keys, want %d", len(objects), len(wantObjects))
+			}
+			if got := server.count("GET list") - before; got != tt.objectPages {
+				t.Errorf("ListObjects() made %d list requests, want %d", got, tt.objectPages)
+			}
+
+			before = server.count("GET list")
+			prefixes, err := u.ListCommonPrefixes(context.Background(), "velero", "backups/", "/")
+			if err != nil {
+				t.Fatalf("ListCommonPrefixes() error = %v", err)
+			}
+			if !reflect.DeepEqual(prefixes, wantPrefixes) {
+				t.Errorf("ListCommonPrefixes() returned %d prefixes, want %d", len(prefixes), len(wantPrefixes))
+			}
+			if got := server.count("GET list") - before; got != tt.prefixPages {
+				t.Errorf("ListCommonPrefixes() made %d list requests, want %d", got, tt.prefixPages)
+			}
+		})
+	}
+}
+
+func TestOSSUploaderListEmptyPrefix(t *testing.T) {
+	server := newFakeServer(t)
+	server.put("velero", "backups/b1/velero-backup.json", []byte("{}"))
+	u := newTestOSSUploader(t, server, 0)
+
+	prefixes, err := u.ListCommonPrefixes(context.Background(), "velero", "restores/", "/")
+	if err != nil {
+		t.Fatalf("ListCommonPrefixes() error = %v", err)
+	}
+	if prefixes == nil || len(prefixes) != 0 {
+		t.Errorf("ListCommonPrefixes() = %#v, want empty slice", prefixes)
+	}
+	objects, err := u.ListObjects(context.Background(), "velero", "restores/")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("ListObjects() = %v, %v, want no keys", objects, err)
+	}
+}
+
+func TestOSSUploaderCreateSignedURLExpires(t *testing.T) {
+	u := newTestOSSUploader(t, newFakeServer(t), 0)
+	for _, ttl := range []time.Duration{time.Minute, 10 * time.Minute, 24 * time.Hour} {
+		t.Run(ttl.String(), func(t *testing.T) {
+			before := time.Now()
+			signed, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/b1.tar.gz", ttl)
+			if err != nil {
+				t.Fatalf("CreateSignedURL() error = %v", err)
+			}
+			parsed, err := url.Parse(signed)
+			if err != nil {
+				t.Fatalf("parse signed url error = %v", err)
+			}
+			// Expires 是预签名地址失效的 Unix 时间
+			expires, err := strconv.ParseInt(parsed.Query().Get("Expires"), 10, 64)
+			if err != nil {
+				t.Fatalf("signed url %s has no valid Expires: %v", signed, err)
+			}
+			if got := time.Unix(expires, 0); got.Before(before.Add(ttl).Add(-time.Second)) || got.After(time.Now().Add(ttl).Add(time.Second)) {
+				t.Errorf("signed url expires at %s, want %s after %s", got, ttl, before)
+			}
+		})
+	}
+}
+
+func TestOSSUploaderResumesFromCheckpoint(t *testing.T) {
+	prevDelay := partRetryDelay
+	partRetryDelay = time.Millisecond
+	defer func() { partRetryDelay = prevDelay }()
+
+	// 4 个分片，第 3 个分片上传失败时前 2 个分片已经上传
+	content := make([]byte, 3*MinPartSize+MinPartSize/2)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	tests := []struct {
+		name string
+		// resumePartSize 第二次上传使用的分片大小
+		resumePartSize uint64
+		// dropUpload 第二次上传前服务端已经清理了未完成的上传
+		dropUpload    bool
+		wantParts     int
+		wantAborts    int
+		wantInitiates int
+	}{
+		{name: "resume", resumePartSize: MinPartSize, wantParts: 2},
+		{name: "part size changed", resumePartSize: 2 * MinPartSize, wantParts: 2, wantAborts: 1, wantInitiates: 1},
+		{name: "upload not found", resumePartSize: MinPartSize, dropUpload: true, wantParts: 4, wantInitiates: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			dir := t.TempDir()
+			newUploader := func(partSize uint64) Uploader {
+				u, err := NewOSSUploader(server.URL, testCredentials(), "cn-hangzhou", true,
+					MultipartOptions{PartSize: partSize, Concurrency: 1, CheckpointDir: dir}, ServerSideEncryption{}, 0, logrus.New())
+				if err != nil {
+					t.Fatalf("NewOSSUploader() error = %v", err)
+				}
+				return u
+			}
+			checkpoints := func() []string {
+				paths, _ := filepath.Glob(filepath.Join(dir, "*"+checkpointSuffix))
+				return paths
+			}
+
+			server.failPart(3, http.StatusServiceUnavailable)
+			if err := newUploader(MinPartSize).PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err == nil {
+				t.Fatal("PutObject() with failing part succeeded, want error")
+			}
+			if got := checkpoints(); len(got) != 1 {
+				t.Fatalf("checkpoints after failure = %v, want 1", got)
+			}
+			pending := server.pendingUploads()
+			if len(pending) != 1 || server.count("DELETE abort") != 0 {
+				t.Fatalf("pending uploads = %v, aborts = %d, want the interrupted upload kept", pending, server.count("DELETE abort"))
+			}
+			if tt.dropUpload {
+				server.dropUpload(pending[0])
+			}
+
+			server.failPart(3, 0)
+			parts, initiates := server.count("PUT part"), server.count("POST initiate")
+			if err := newUploader(tt.resumePartSize).PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			if got := server.count("PUT part") - parts; got != tt.wantParts {
+				t.Errorf("resumed upload sent %d parts, want %d", got, tt.wantParts)
+			}
+			if got := server.count("POST initiate") - initiates; got != tt.wantInitiates {
+				t.Errorf("resumed upload initiated %d uploads, want %d", got, tt.wantInitiates)
+			}
+			if got := server.count("DELETE abort"); got != tt.wantAborts {
+				t.Errorf("aborted %d uploads, want %d", got, tt.wantAborts)
+			}
+			if data, _ := server.get("velero", "backups/b1/b1.tar.gz"); !bytes.Equal(data, content) {
+				t.Errorf("stored object differs from the uploaded content (%d bytes, want %d)", len(data), len(content))
+			}
+			if got := checkpoints(); len(got) != 0 {
+				t.Errorf("checkpoints after success = %v, want none", got)
+			}
+			if got := server.pendingUploads(); len(got) != 0 {
+				t.Errorf("pending uploads after success = %v, want none", got)
+			}
+		})
+	}
+}
+
+// newCheckpointOSSUploader 返回每次上传一个分片、在 dir 中记录断点的 OSSUploader
+func newCheckpointOSSUploader(t *testing.T, server *fakeServer, dir string, maxAge time.Duration) Uploader {
+	u, err := NewOSSUploader(server.URL, testCredentials(), "cn-hangzhou", true,
+		MultipartOptions{PartSize: MinPartSize, Concurrency: 1, CheckpointDir: dir, CheckpointMaxAge: maxAge}, ServerSideEncryption{}, 0, logrus.New())
+	if err != nil {
+		t.Fatalf("NewOSSUploader() error = %v", err)
+	}
+	return u
+}
+
+func TestOSSUploaderAbortsUnresumableUpload(t *testing.T) {
+	prevDelay := partRetryDelay
+	defer func() { partRetryDelay = prevDelay }()
+	content := make([]byte, 3*MinPartSize)
+
+	tests := []struct {
+		name    string
+		status  int
+		timeout time.Duration
+		// retryDelay 足够长时，上传在等待重试时超时
+		retryDelay     time.Duration
+		wantCheckpoint bool
+	}{
+		{name: "server error", status: http.StatusServiceUnavailable, retryDelay: time.Millisecond, wantCheckpoint: true},
+		{name: "access denied", status: http.StatusForbidden, retryDelay: time.Millisecond},
+		{name: "timeout", status: http.StatusServiceUnavailable, timeout: time.Second, retryDelay: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partRetryDelay = tt.retryDelay
+			server := newFakeServer(t)
+			dir := t.TempDir()
+			server.failPart(2, tt.status)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			if err := newCheckpointOSSUploader(t, server, dir, 0).PutObject(ctx, "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err == nil {
+				t.Fatal("PutObject() with failing part succeeded, want error")
+			}
+			checkpoints, _ := filepath.Glob(filepath.Join(dir, "*"+checkpointSuffix))
+			pending := server.pendingUploads()
+			if tt.wantCheckpoint {
+				if len(checkpoints) != 1 || len(pending) != 1 {
+					t.Errorf("checkpoints = %v, pending uploads = %v, want the upload kept for resuming", checkpoints, pending)
+				}
+				return
+			}
+			if len(checkpoints) != 0 || len(pending) != 0 {
+				t.Errorf("checkpoints = %v, pending uploads = %v, want the upload aborted", checkpoints, pending)
+			}
+		})
+	}
+}
+
+func TestOSSUploaderCleansUpExpiredCheckpoints(t *testing.T) {
+	prevDelay := partRetryDelay
+	partRetryDelay = time.Millisecond
+	defer func() { partRetryDelay = prevDelay }()
+	content := make([]byte, 2*MinPartSize)
+
+	server := newFakeServer(t)
+	dir := t.TempDir()
+	server.failPart(2, http.StatusServiceUnavailable)
+	for _, key := range []string{"backups/expired/expired.tar.gz", "backups/recent/recent.tar.gz"} {
+		if err := newCheckpointOSSUploader(t, server, dir, 0).PutObject(context.Background(), "velero", key, bytes.NewReader(content)); err == nil {
+			t.Fatalf("PutObject(%s) with failing part succeeded, want error", key)
+		}
+	}
+	server.failPart(2, 0)
+	u := newCheckpointOSSUploader(t, server, dir, time.Hour).(*OSSUploader)
+	expired := u.checkpointPath("velero", "backups/expired/expired.tar.gz")
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(expired, old, old); err != nil {
+		t.Fatal(err)
+	}
+	// 一个无法解析的过期记录
+	invalid := filepath.Join(dir, "invalid"+checkpointSuffix)
+	if err := os.WriteFile(invalid, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(invalid, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+		t.Fatalf("PutObject() error = %v", err)
+	}
+	checkpoints, _ := filepath.Glob(filepath.Join(dir, "*"+checkpointSuffix))
+	if want := u.checkpointPath("velero", "backups/recent/recent.tar.gz"); len(checkpoints) != 1 || checkpoints[0] != want {
+		t.Errorf("checkpoints = %v, want only %s", checkpoints, want)
+	}
+	if got := server.pendingUploads(); len(got) != 1 || server.count("DELETE abort") != 1 {
+		t.Errorf("pending uploads = %v, aborts = %d, want the expired upload aborted", got, server.count("DELETE abort"))
+	}
+}
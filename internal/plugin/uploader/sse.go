@@ -0,0 +1,116 @@
+package uploader
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// SSEMode 服务端加密方式
+type SSEMode string
+
+const (
+	// SSEManaged 使用存储服务管理的密钥加密，即 SSE-S3 与 SSE-OSS
+	SSEManaged SSEMode = "AES256"
+	// SSEKMS 使用 KMS 中的密钥加密
+	SSEKMS SSEMode = "KMS"
+	// SSECustomer 使用客户提供的密钥加密，读取对象时需要提供同一个密钥
+	SSECustomer SSEMode = "SSE-C"
+
+	// SSECustomerKeySize SSE-C 密钥的长度（字节）
+	SSECustomerKeySize = 32
+	// sseCustomerAlgorithm SSE-C 使用的加密算法
+	sseCustomerAlgorithm = "AES256"
+)
+
+// ServerSideEncryption 服务端加密参数，零值表示不指定，由存储桶的默认加密配置决定
+type ServerSideEncryption struct {
+	Mode SSEMode
+	// KMSKeyID SSE-KMS 使用的密钥 ID，为空时使用服务端的默认密钥
+	KMSKeyID string
+	// CustomerKey SSE-C 使用的 256 位密钥
+	CustomerKey []byte
+}
+
+// Validate 检查加密参数是否完整
+func (s ServerSideEncryption) Validate() error {
+	switch s.Mode {
+	case "", SSEManaged, SSEKMS:
+	case SSECustomer:
+		if len(s.CustomerKey) != SSECustomerKeySize {
+			return fmt.Errorf("sse-c key must be %d bytes, got %d", SSECustomerKeySize, len(s.CustomerKey))
+		}
+	default:
+		return fmt.Errorf("unknown server side encryption mode %q", s.Mode)
+	}
+	if s.KMSKeyID != "" && s.Mode != SSEKMS {
+		return fmt.Errorf("kms key id is only used with %s encryption", SSEKMS)
+	}
+	return nil
+}
+
+// customerKey 返回 base64 编码的 SSE-C 密钥及其 MD5
+func (s ServerSideEncryption) customerKey() (key, keyMD5 string) {
+	sum := md5.Sum(s.CustomerKey)
+	return base64.StdEncoding.EncodeToString(s.CustomerKey), base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// minio 返回 minio SDK 的加密参数，未设置时返回 nil
+func (s ServerSideEncryption) minio() (encrypt.ServerSide, error) {
+	switch s.Mode {
+	case SSEManaged:
+		return encrypt.NewSSE(), nil
+	case SSEKMS:
+		return encrypt.NewSSEKMS(s.KMSKeyID, nil)
+	case SSECustomer:
+		return encrypt.NewSSEC(s.CustomerKey)
+	}
+	return nil, nil
+}
+
+// awsMode 返回 S3 x-amz-server-side-encryption 头的取值，SSE-C 不使用该头
+func (s ServerSideEncryption) awsMode() *string {
+	switch s.Mode {
+	case SSEManaged:
+		return aws.String("AES256")
+	case SSEKMS:
+		return aws.String("aws:kms")
+	}
+	return nil
+}
+
+// awsCustomerKey 返回 S3 SSE-C 的算法与密钥，aws-sdk-go 会自动计算密钥的 MD5
+func (s ServerSideEncryption) awsCustomerKey() (algorithm, key *string) {
+	if s.Mode != SSECustomer {
+		return nil, nil
+	}
+	return aws.String(sseCustomerAlgorithm), aws.String(string(s.CustomerKey))
+}
+
+// ossWriteOptions 返回 OSS 写入对象（包括初始化分片上传与上传分片）时的加密请求头
+func (s ServerSideEncryption) ossWriteOptions() []oss.Option {
+	switch s.Mode {
+	case SSEManaged:
+		return []oss.Option{oss.ServerSideEncryption("AES256")}
+	case SSEKMS:
+		options := []oss.Option{oss.ServerSideEncryption("KMS")}
+		if s.KMSKeyID != "" {
+			options = append(options, oss.ServerSideEncryptionKeyID(s.KMSKeyID))
+		}
+		return options
+	}
+	return s.ossCustomerKeyOptions()
+}
+
+// ossCustomerKeyOptions 返回 SSE-C 的请求头，读取对象与上传分片时都需要提供密钥，其他加密方式返回 nil
+func (s ServerSideEncryption) ossCustomerKeyOptions() []oss.Option {
+	if s.Mode != SSECustomer {
+		return nil
+	}
+	key, keyMD5 := s.customerKey()
+	return []oss.Option{oss.SSECAlgorithm(sseCustomerAlgorithm), oss.SSECKey(key), oss.SSECKeyMd5(keyMD5)}
+}
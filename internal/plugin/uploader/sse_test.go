@@ -0,0 +1,220 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sseBackend 描述一种支持服务端加密的存储及其加密请求头的前缀
+type sseBackend struct {
+	name string
+	// headerPrefix 加密请求头的前缀，如 X-Amz-Server-Side-Encryption
+	headerPrefix string
+	// tls SSE-C 测试是否需要 https 服务
+	tls         bool
+	newUploader func(t *testing.T, server *fakeServer, multipart MultipartOptions, sse ServerSideEncryption) Uploader
+}
+
+var sseBackends = []sseBackend{
+	{
+		name:         "minio",
+		headerPrefix: "X-Amz-Server-Side-Encryption",
+		newUploader: func(t *testing.T, server *fakeServer, multipart MultipartOptions, sse ServerSideEncryption) Uploader {
+			u, err := NewMinioUploader(server.URL, testCredentials(), false, "us-east-1", multipart, sse, logrus.New())
+			if err != nil {
+				t.Fatalf("NewMinioUploader() error = %v", err)
+			}
+			return u
+		},
+	},
+	{
+		name:         "oss",
+		headerPrefix: "X-Oss-Server-Side-Encryption",
+		newUploader: func(t *testing.T, server *fakeServer, multipart MultipartOptions, sse ServerSideEncryption) Uploader {
+			u, err := NewOSSUploader(server.URL, testCredentials(), "cn-hangzhou", true, multipart, sse, 0, logrus.New())
+			if err != nil {
+				t.Fatalf("NewOSSUploader() error = %v", err)
+			}
+			return u
+		},
+	},
+	{
+		name:         "aws",
+		headerPrefix: "X-Amz-Server-Side-Encryption",
+		tls:          true,
+		newUploader: func(t *testing.T, server *fakeServer, multipart MultipartOptions, sse ServerSideEncryption) Uploader {
+			u, err := NewAWSUploader(server.URL, testCredentials(), "", true, true, multipart, sse, logrus.New())
+			if err != nil {
+				t.Fatalf("NewAWSUploader() error = %v", err)
+			}
+			return u
+		},
+	},
+}
+
+// sseMultipart 让超过 5MiB 的对象使用分片上传
+var sseMultipart = MultipartOptions{PartSize: MinPartSize, Threshold: MinPartSize, Concurrency: 2}
+
+func TestServerSideEncryptionHeaders(t *testing.T) {
+	modes := []struct {
+		name      string
+		sse       ServerSideEncryption
+		wantMode  map[string]string
+		wantKeyID string
+	}{
+		{name: "managed", sse: ServerSideEncryption{Mode: SSEManaged}, wantMode: map[string]string{"minio": "AES256", "oss": "AES256", "aws": "AES256"}},
+		{
+			name:      "kms",
+			sse:       ServerSideEncryption{Mode: SSEKMS, KMSKeyID: "key-1"},
+			wantMode:  map[string]string{"minio": "aws:kms", "oss": "KMS", "aws": "aws:kms"},
+			wantKeyID: "key-1",
+		},
+	}
+	for _, backend := range sseBackends {
+		for _, mode := range modes {
+			t.Run(backend.name+" "+mode.name, func(t *testing.T) {
+				server := newFakeServer(t)
+				u := backend.newUploader(t, server, sseMultipart, mode.sse)
+				keyIDHeader := backend.headerPrefix + "-Aws-Kms-Key-Id"
+				if backend.name == "oss" {
+					keyIDHeader = backend.headerPrefix + "-Key-Id"
+				}
+
+				large := bytes.Repeat([]byte("x"), int(MinPartSize)+1)
+				for _, content := range [][]byte{[]byte("small"), large} {
+					if err := u.PutObject(context.Background(), "velero", "backups/b1/b1.tar.gz", bytes.NewReader(content)); err != nil {
+						t.Fatalf("PutObject() error = %v", err)
+					}
+				}
+				for _, op := range []string{"PUT object", "POST initiate"} {
+					header := server.header(op)
+					if got := header.Get(backend.headerPrefix); got != mode.wantMode[backend.name] {
+						t.Errorf("%s %s = %q, want %q", op, backend.headerPrefix, got, mode.wantMode[backend.name])
+					}
+					if got := header.Get(keyIDHeader); got != mode.wantKeyID {
+						t.Errorf("%s %s = %q, want %q", op, keyIDHeader, got, mode.wantKeyID)
+					}
+				}
+
+				// 上传分片与读取对象时不能携带加密方式，S3 会拒绝这类请求
+				body, err := u.GetObject(context.Background(), "velero", "backups/b1/b1.tar.gz")
+				if err != nil {
+					t.Fatalf("GetObject() error = %v", err)
+				}
+				io.Copy(io.Discard, body)
+				body.Close()
+				for _, op := range []string{"PUT part", "GET object"} {
+					if got := server.header(op).Get(backend.headerPrefix); got != "" {
+						t.Errorf("%s %s = %q, want none", op, backend.headerPrefix, got)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestServerSideEncryptionCustomerKey(t *testing.T) {
+	key := bytes.Repeat([]byte("k"), SSECustomerKeySize)
+	sse := ServerSideEncryption{Mode: SSECustomer, CustomerKey: key}
+	other := ServerSideEncryption{Mode: SSECustomer, CustomerKey: bytes.Repeat([]byte("o"), SSECustomerKeySize)}
+
+	for _, backend := range sseBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			server := newFakeServer(t)
+			if backend.tls {
+				server = newFakeTLSServer(t)
+			}
+			u := backend.newUploader(t, server, sseMultipart, sse)
+
+			contents := map[string][]byte{
+				"backups/b1/velero-backup.json": []byte("small"),
+				"backups/b1/b1.tar.gz":          bytes.Repeat([]byte("x"), int(MinPartSize)+1),
+			}
+			for key, content := range contents {
+				if err := u.PutObject(context.Background(), "velero", key, bytes.NewReader(content)); err != nil {
+					t.Fatalf("PutObject(%s) error = %v", key, err)
+				}
+				body, err := u.GetObject(context.Background(), "velero", key)
+				if err != nil {
+					t.Fatalf("GetObject(%s) error = %v", key, err)
+				}
+				got, err := io.ReadAll(body)
+				body.Close()
+				if err != nil || !bytes.Equal(got, content) {
+					t.Errorf("GetObject(%s) = %d bytes, %v, want %d bytes", key, len(got), err, len(content))
+				}
+			}
+			if exists, err := u.ObjectExists(context.Background(), "velero", "backups/b1/b1.tar.gz"); err != nil || !exists {
+				t.Errorf("ObjectExists() = %v, %v, want true", exists, err)
+			}
+
+			// 使用其他密钥无法读取
+			if body, err := backend.newUploader(t, server, sseMultipart, other).GetObject(context.Background(), "velero", "backups/b1/b1.tar.gz"); err == nil {
+				_, err = io.ReadAll(body)
+				body.Close()
+				if err == nil {
+					t.Errorf("GetObject() with another key succeeded, want error")
+				}
+			}
+
+			// 预签名地址需要携带同一个密钥
+			url, err := u.CreateSignedURL(context.Background(), "velero", "backups/b1/velero-backup.json", time.Minute)
+			if err != nil {
+				t.Fatalf("CreateSignedURL() error = %v", err)
+			}
+			sum := md5.Sum(key)
+			prefix := backend.headerPrefix + "-Customer-"
+			for _, withKey := range []bool{true, false} {
+				req, _ := http.NewRequest(http.MethodGet, url, nil)
+				if withKey {
+					req.Header.Set(prefix+"Algorithm", "AES256")
+					req.Header.Set(prefix+"Key", base64.StdEncoding.EncodeToString(key))
+					req.Header.Set(prefix+"Key-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+				}
+				resp, err := server.Client().Do(req)
+				if err != nil {
+					t.Fatalf("GET signed url error = %v", err)
+				}
+				resp.Body.Close()
+				if (resp.StatusCode == http.StatusOK) != withKey {
+					t.Errorf("GET signed url with key %v returned status %d", withKey, resp.StatusCode)
+				}
+			}
+			if backend.name != "oss" && !strings.Contains(strings.ToLower(url), strings.ToLower(prefix+"Key-Md5")) {
+				t.Errorf("CreateSignedURL() = %s, want the customer key headers signed", url)
+			}
+		})
+	}
+}
+
+func TestServerSideEncryptionValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		sse     ServerSideEncryption
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "managed", sse: ServerSideEncryption{Mode: SSEManaged}},
+		{name: "kms with default key", sse: ServerSideEncryption{Mode: SSEKMS}},
+		{name: "customer key", sse: ServerSideEncryption{Mode: SSECustomer, CustomerKey: make([]byte, SSECustomerKeySize)}},
+		{name: "short customer key", sse: ServerSideEncryption{Mode: SSECustomer, CustomerKey: make([]byte, 16)}, wantErr: true},
+		{name: "kms key without kms", sse: ServerSideEncryption{Mode: SSEManaged, KMSKeyID: "key-1"}, wantErr: true},
+		{name: "unknown mode", sse: ServerSideEncryption{Mode: "SM4"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.sse.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
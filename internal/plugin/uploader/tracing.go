@@ -0,0 +1,39 @@
+package uploader
+
+import (
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// tracingTransport 为 SDK 发出的每个 HTTP 请求创建一个 span，作为 ctx 中 ObjectStore 调用的子 span，
+// 未配置 OpenTelemetry 时 span 为空实现。不注入 traceparent 等请求头，以免影响请求签名
+func tracingTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return "HTTP " + r.Method }))
+}
+
+// TracingHTTPClient 返回整体超时时间为 timeout 并为每个请求创建 span 的 HTTP 客户端，用于访问 STS 等凭证服务
+func TracingHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: tracingTransport(http.DefaultTransport.(*http.Transport).Clone()), Timeout: timeout}
+}
+
+// ossHeaderTimeout 与 OSS SDK 默认的等待响应头超时时间一致
+const ossHeaderTimeout = 60 * time.Second
+
+// ossHTTPClient 返回 OSS 使用的 HTTP 客户端。OSS SDK 只能整体替换 http.Client，
+// 因此这里保留 SDK 默认的响应头超时并禁止重定向，读取过慢的请求由 ObjectStore 各操作的超时时间兜底
+func ossHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = ossHeaderTimeout
+	return &http.Client{
+		Transport: tracingTransport(transport),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,68 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTestTracer 将全局 TracerProvider 替换为记录到内存的实现，测试结束后恢复
+func newTestTracer(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return exporter
+}
+
+func TestTracingHTTPRequests(t *testing.T) {
+	backends := append([]sseBackend{
+		{
+			name: "cos",
+			newUploader: func(t *testing.T, server *fakeServer, multipart MultipartOptions, _ ServerSideEncryption) Uploader {
+				return newTestCOSUploader(t, server, multipart)
+			},
+		},
+		{
+			name: "obs",
+			newUploader: func(t *testing.T, server *fakeServer, multipart MultipartOptions, _ ServerSideEncryption) Uploader {
+				return newTestOBSUploader(t, server, multipart)
+			},
+		},
+	}, sseBackends...)
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			exporter := newTestTracer(t)
+			server := newFakeServer(t)
+			u := backend.newUploader(t, server, MultipartOptions{}, ServerSideEncryption{})
+
+			ctx, parent := otel.Tracer("test").Start(context.Background(), "ObjectStore.PutObject")
+			if err := u.PutObject(ctx, "velero", "backups/b1/b1.tar.gz", bytes.NewReader([]byte("backup"))); err != nil {
+				t.Fatalf("PutObject() error = %v", err)
+			}
+			parent.End()
+
+			var requests int
+			for _, span := range exporter.GetSpans() {
+				if span.Name != "HTTP PUT" {
+					continue
+				}
+				requests++
+				if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+					t.Errorf("HTTP PUT span parent = %s, want the ObjectStore span", span.Parent.SpanID())
+				}
+			}
+			if requests != 1 {
+				t.Errorf("HTTP PUT spans = %d, want 1", requests)
+			}
+			// 不注入 traceparent 请求头，以免影响请求签名
+			if got := server.header("PUT object").Get("Traceparent"); got != "" {
+				t.Errorf("PUT object Traceparent = %q, want none", got)
+			}
+		})
+	}
+}
@@ -1,16 +1,65 @@
 package uploader
 
 import (
+	"context"
 	"io"
 	"time"
 )
 
+const (
+	// MinPartSize S3 协议允许的最小分片大小（最后一个分片除外）
+	MinPartSize uint64 = 5 << 20
+	// DefaultPartSize 分片上传时每个分片的默认大小
+	DefaultPartSize uint64 = 16 << 20
+	// DefaultConcurrency 分片上传时默认的并发数
+	DefaultConcurrency uint = 4
+
+	// DefaultCheckpointMaxAge 断点记录的默认保留时间
+	DefaultCheckpointMaxAge = 24 * time.Hour
+
+	// abortTimeout 中止失败分片上传时使用的超时时间
+	abortTimeout = 30 * time.Second
+)
+
+// Uploader 对象存储的统一访问接口，所有方法在 ctx 取消或超时后尽快返回。
+// GetObject 返回的内容在读取完毕之前，调用方需要保证 ctx 一直有效
 type Uploader interface {
-	PutObject(bucket string, key string, body io.Reader) error
-	ObjectExists(bucket, key string) (bool, error)
-	GetObject(bucket, key string) (io.ReadCloser, error)
-	ListObjects(bucket, prefix string) ([]string, error)
-	DeleteObject(bucket, key string) error
-	ListCommonPrefixes(bucket, prefix, delimiter string) ([]string, error)
-	CreateSignedURL(bucketName, key string, ttl time.Duration) (string, error)
+	PutObject(ctx context.Context, bucket string, key string, body io.Reader) error
+	ObjectExists(ctx context.Context, bucket, key string) (bool, error)
+	GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error)
+	ListObjects(ctx context.Context, bucket, prefix string) ([]string, error)
+	DeleteObject(ctx context.Context, bucket, key string) error
+	ListCommonPrefixes(ctx context.Context, bucket, prefix, delimiter string) ([]string, error)
+	CreateSignedURL(ctx context.Context, bucketName, key string, ttl time.Duration) (string, error)
+}
+
+// MultipartOptions 分片上传参数，零值字段使用默认值
+type MultipartOptions struct {
+	// PartSize 每个分片的大小（字节）
+	PartSize uint64
+	// Concurrency 并行上传的分片数
+	Concurrency uint
+	// Threshold 超过该大小（字节）的对象使用分片上传，默认等于 PartSize，仅 OSS 使用
+	Threshold uint64
+	// CheckpointDir 断点续传记录文件的保存目录，为空时不记录断点，仅 OSS 使用
+	CheckpointDir string
+	// CheckpointMaxAge 断点记录的保留时间，超过后中止对应的分片上传并删除记录，仅 OSS 使用
+	CheckpointMaxAge time.Duration
+}
+
+// withDefaults 返回补全默认值后的参数
+func (o MultipartOptions) withDefaults() MultipartOptions {
+	if o.PartSize == 0 {
+		o.PartSize = DefaultPartSize
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = DefaultConcurrency
+	}
+	if o.Threshold == 0 {
+		o.Threshold = o.PartSize
+	}
+	if o.CheckpointMaxAge == 0 {
+		o.CheckpointMaxAge = DefaultCheckpointMaxAge
+	}
+	return o
 }
@@ -27,6 +27,8 @@ func main() {
 	framework.NewServer().BindFlags(pflag.CommandLine).
 		RegisterObjectStore("velero.io/cloud", newObjectStorePlugin).
 		Serve()
+	// Serve returns once Velero stops the plugin, flush the audit records and spans before exiting
+	plugin.Shutdown(logrus.New())
 }
 
 func newObjectStorePlugin(logger logrus.FieldLogger) (interface{}, error) {